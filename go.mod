@@ -6,8 +6,11 @@ require (
 	github.com/dop251/goja v0.0.0-20241024094426-79f3a7efcdbd
 	github.com/gorilla/mux v1.8.1
 	github.com/lib/pq v1.10.9
+	github.com/tyler-smith/go-bip39 v1.1.0
 	github.com/wasmerio/wasmer-go v1.0.4
 	golang.org/x/crypto v0.29.0
+	golang.org/x/term v0.26.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -2,43 +2,180 @@ package internal
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
 
-	"golang.org/x/crypto/blake2b"
+	"blockchain-go/wallet"
 )
 
-// GetAddress genera una dirección única, devuelve la clave privada asociada y asigna saldo inicial.
-func GetAddress(db *Database, initialBalance int64) (string, *ecdsa.PrivateKey, error) {
-	// Generar una clave privada
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// RegisterAddress valida una clave pública (P-256 o Ed25519), deriva su dirección y registra
+// la cuenta sin saldo. La clave privada se genera en el cliente (ver el paquete wallet) y nunca
+// llega al nodo.
+func RegisterAddress(db *Database, publicKey string) (string, error) {
+	accountAddress, _, err := wallet.AddressFromPublicKey(publicKey)
 	if err != nil {
-		return "", nil, err
+		return "", err
+	}
+	if err := db.SaveAccountKey(accountAddress, publicKey); err != nil {
+		return "", err
 	}
 
-	// Crear un hash Blake2b de la clave pública
-	pubKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
-	address := blake2b.Sum256(pubKey)
-
-	// Convertir la dirección a formato hexadecimal
-	accountAddress := hex.EncodeToString(address[:])
-
-	// Verificar si la cuenta ya existe, si no, guardar el saldo
+	// Verificar si la cuenta ya existe, si no, registrarla con saldo cero
 	exists, err := db.AccountExists(accountAddress)
 	if err != nil {
-		return "", nil, fmt.Errorf("error verificando si la cuenta existe: %w", err)
+		return "", fmt.Errorf("error verificando si la cuenta existe: %w", err)
 	}
 
 	if !exists {
-		fmt.Printf("Guardando saldo inicial de %d para la nueva dirección: %s\n", initialBalance, accountAddress)
-		err = db.SaveBalance(accountAddress, initialBalance)
+		fmt.Printf("Registrando la nueva dirección: %s\n", accountAddress)
+		err = db.SaveBalance(accountAddress, 0)
+		if err != nil {
+			return "", fmt.Errorf("error registrando la cuenta: %w", err)
+		}
+	}
+
+	return accountAddress, nil
+}
+
+// SaveAccountKey recuerda la clave pública de una cuenta, y con ella su esquema de firma.
+func (d *Database) SaveAccountKey(address, publicKey string) error {
+	publicKey, err := wallet.NormalizePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Connection.Exec(
+		"INSERT INTO account_keys (address, key_type, public_key) VALUES ($1, $2, $3) ON CONFLICT (address) DO NOTHING",
+		address, string(wallet.PublicKeyType(publicKey)), publicKey,
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando la clave de %s: %w", address, err)
+	}
+	return nil
+}
+
+// addressVersion determina la versión de dirección de una cuenta según su tipo.
+func (d *Database) addressVersion(account string) byte {
+	if policy, err := d.GetMultisigPolicy(account); err == nil && policy != nil {
+		return wallet.AddressVersionMultisig
+	}
+	if exists, err := d.ContractExists(account); err == nil && exists {
+		return wallet.AddressVersionContract
+	}
+
+	var keyType string
+	err := d.Connection.QueryRow("SELECT key_type FROM account_keys WHERE address = $1", account).Scan(&keyType)
+	if err == nil && wallet.KeyType(keyType) == wallet.KeyTypeEd25519 {
+		return wallet.AddressVersionEd25519
+	}
+	return wallet.AddressVersionP256
+}
+
+// AddressFromPublicKey deriva la dirección de una cuenta a partir de su clave pública P-256.
+func AddressFromPublicKey(pub *ecdsa.PublicKey) string {
+	return wallet.Address(pub)
+}
+
+// EncodePublicKey codifica una clave pública P-256 en hexadecimal (formato SEC1 sin comprimir).
+func EncodePublicKey(pub *ecdsa.PublicKey) string {
+	return wallet.EncodePublicKey(pub)
+}
+
+// ParsePublicKey decodifica una clave pública P-256 codificada con EncodePublicKey.
+func ParsePublicKey(encoded string) (*ecdsa.PublicKey, error) {
+	return wallet.ParsePublicKey(encoded)
+}
+
+// ParseAddress valida una dirección recibida por la API y devuelve su forma canónica: el hash
+// hexadecimal con el que se guardan las cuentas y que cubren las firmas. Las direcciones
+// hexadecimales heredadas se aceptan solo mientras dure la migración.
+func (d *Database) ParseAddress(address string) (string, error) {
+	return parseAddress(address, d.AddressPrefix, d.AcceptLegacyAddresses)
+}
+
+// parseAddress valida una dirección Bech32m de la red con el prefijo dado, o una heredada si
+// acceptLegacy es true, y devuelve su forma canónica.
+func parseAddress(address, prefix string, acceptLegacy bool) (string, error) {
+	if wallet.IsLegacyAddress(address) {
+		if !acceptLegacy {
+			return "", fmt.Errorf("%w: el formato hexadecimal heredado ya no se acepta", wallet.ErrInvalidAddress)
+		}
+		return strings.ToLower(address), nil
+	}
+
+	decodedPrefix, version, hash, err := wallet.DecodeAddress(address)
+	if err != nil {
+		return "", err
+	}
+	if decodedPrefix != prefix {
+		return "", fmt.Errorf("%w: el prefijo %q no corresponde a esta red (%q)", wallet.ErrInvalidAddress, decodedPrefix, prefix)
+	}
+	switch version {
+	case wallet.AddressVersionP256, wallet.AddressVersionMultisig, wallet.AddressVersionEd25519, wallet.AddressVersionContract:
+	default:
+		return "", fmt.Errorf("%w: versión %d no soportada", wallet.ErrInvalidAddress, version)
+	}
+	return hash, nil
+}
+
+// FormatAddress codifica una cuenta canónica con el prefijo de la red para mostrarla.
+// Las cuentas que no son un hash (por ejemplo las creadas por la API heredada) no cambian.
+func (d *Database) FormatAddress(account string) string {
+	if !wallet.IsLegacyAddress(account) {
+		return account
+	}
+
+	account = strings.ToLower(account)
+	encoded, err := wallet.EncodeAddress(d.AddressPrefix, d.addressVersion(account), account)
+	if err != nil {
+		return account
+	}
+	return encoded
+}
+
+// parseAddresses normaliza las direcciones recibidas en una solicitud y responde con error
+// si alguna es inválida.
+func (s *Server) parseAddresses(w http.ResponseWriter, addresses ...*string) bool {
+	for _, address := range addresses {
+		canonical, err := s.DB.ParseAddress(*address)
 		if err != nil {
-			return "", nil, fmt.Errorf("error guardando el saldo inicial: %w", err)
+			http.Error(w, fmt.Sprintf("Dirección %q inválida: %s", *address, err), http.StatusBadRequest)
+			return false
 		}
+		*address = canonical
+	}
+	return true
+}
+
+// RegisterAddressHandler maneja el registro de una dirección a partir de una clave pública.
+func (s *Server) RegisterAddressHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PublicKey string `json:"public_key"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+
+	publicKey, err := wallet.NormalizePublicKey(payload.PublicKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Clave pública inválida: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	address, err := RegisterAddress(s.DB, publicKey)
+	if err != nil {
+		http.Error(w, "Error registrando la dirección", http.StatusInternalServerError)
+		return
 	}
 
-	// Devolver la dirección y la clave privada
-	return accountAddress, privateKey, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"address":        s.DB.FormatAddress(address),
+		"legacy_address": address,
+		"public_key":     publicKey,
+	})
 }
@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxBatchOutputs es el número máximo de destinatarios de un pago múltiple.
+const MaxBatchOutputs = 500
+
+// BatchPayload contiene los destinatarios de un pago múltiple.
+type BatchPayload struct {
+	Outputs []wallet.Output `json:"outputs"`
+}
+
+// HistoryEntry es un movimiento aplicado en el historial de una cuenta. Los pagos múltiples
+// aparecen como un movimiento por destinatario, con el hash de la transacción que los agrupa.
+type HistoryEntry struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Amount    int64     `json:"amount"`
+	Type      string    `json:"type,omitempty"`
+	TxHash    string    `json:"tx_hash,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// parseBatch valida los datos de un pago múltiple: salidas no vacías, montos positivos,
+// destinos canónicos y un total igual al monto de la transacción.
+func parseBatch(tx Transaction) (BatchPayload, error) {
+	var payload BatchPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return payload, fmt.Errorf("datos de pago múltiple inválidos: %w", err)
+	}
+	if len(payload.Outputs) == 0 || len(payload.Outputs) > MaxBatchOutputs {
+		return payload, fmt.Errorf("un pago múltiple necesita entre 1 y %d destinatarios", MaxBatchOutputs)
+	}
+
+	var total int64
+	for i, output := range payload.Outputs {
+		if !wallet.IsLegacyAddress(output.To) {
+			return payload, fmt.Errorf("salida %d: dirección no canónica %q", i, output.To)
+		}
+		if output.Amount <= 0 || total+output.Amount < total {
+			return payload, fmt.Errorf("salida %d: monto inválido", i)
+		}
+		total += output.Amount
+	}
+	if total != tx.Amount {
+		return payload, fmt.Errorf("el total de las salidas (%d) no coincide con el monto (%d)", total, tx.Amount)
+	}
+	return payload, nil
+}
+
+// applyBatch aplica un pago múltiple en una única transacción de base de datos: o se
+// acreditan todas las salidas o ninguna.
+func (d *Database) applyBatch(tx Transaction, ctx BlockContext) error {
+	hash, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+	payload, err := parseBatch(tx)
+	if err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		for _, output := range payload.Outputs {
+			if err := state.credit(output.To, output.Amount); err != nil {
+				return err
+			}
+			err := state.recordHistory(HistoryEntry{
+				From:      tx.From,
+				To:        output.To,
+				Amount:    output.Amount,
+				Type:      TxTypeBatch,
+				TxHash:    hash,
+				Timestamp: ctx.Time,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Pago múltiple completado: de %s a %d destinatarios por %d\n", tx.From, len(payload.Outputs), tx.Amount)
+		return nil
+	})
+}
+
+const insertHistoryQuery = `INSERT INTO transactions (from_account, to_account, amount, timestamp, tx_hash, tx_type)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+// recordHistory registra un movimiento aplicado en el historial de cuentas.
+func (d *Database) recordHistory(entry HistoryEntry) error {
+	return sqlState{d.Connection}.recordHistory(entry)
+}
+
+// LoadAccountHistory carga los movimientos aplicados en los que participa una cuenta.
+func (d *Database) LoadAccountHistory(account string) ([]HistoryEntry, error) {
+	rows, err := d.Connection.Query(
+		`SELECT from_account, to_account, amount, tx_type, tx_hash, timestamp FROM transactions
+		WHERE from_account = $1 OR to_account = $1 ORDER BY id ASC`,
+		account,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo historial de %s: %w", account, err)
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var entry HistoryEntry
+		if err := rows.Scan(&entry.From, &entry.To, &entry.Amount, &entry.Type, &entry.TxHash, &entry.Timestamp); err != nil {
+			return nil, fmt.Errorf("error al escanear movimiento: %w", err)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// AddBatchTransaction maneja el envío de un pago múltiple con un emisor y varios destinatarios.
+func (s *Server) AddBatchTransaction(w http.ResponseWriter, r *http.Request) {
+	var batch wallet.Batch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Outputs) == 0 || len(batch.Outputs) > MaxBatchOutputs {
+		http.Error(w, fmt.Sprintf("Un pago múltiple necesita entre 1 y %d destinatarios", MaxBatchOutputs), http.StatusBadRequest)
+		return
+	}
+
+	addresses := []*string{&batch.From}
+	for i := range batch.Outputs {
+		addresses = append(addresses, &batch.Outputs[i].To)
+	}
+	if !s.parseAddresses(w, addresses...) {
+		return
+	}
+
+	payload, total, err := wallet.BatchPayload(batch.Outputs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Pago múltiple inválido: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	tx := Transaction{
+		From:      batch.From,
+		Amount:    total,
+		Type:      TxTypeBatch,
+		Payload:   payload,
+		Nonce:     batch.Nonce,
+		PublicKey: batch.PublicKey,
+		Signature: batch.Signature,
+	}
+	s.submitTransaction(w, tx)
+}
+
+// GetAccountHistory maneja la solicitud del historial de movimientos de una cuenta.
+func (s *Server) GetAccountHistory(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !s.parseAddresses(w, &address) {
+		return
+	}
+
+	history, err := s.DB.LoadAccountHistory(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo el historial", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":      s.DB.FormatAddress(address),
+		"transactions": history,
+	})
+}
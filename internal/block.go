@@ -17,6 +17,7 @@ type Block struct {
 	Hash          string
 	MetadataRef   string // Referencia a metadatos almacenados en la base de datos PostgreSQL
 	WASMContracts []WASMContract
+	StorageRoot   string // Raíz Merkle del almacenamiento de los contratos tras aplicar el bloque
 }
 
 // NewBlock crea un nuevo bloque.
@@ -32,10 +33,24 @@ func NewBlock(index int, transactions []Transaction, prevHash, metadataRef strin
 	return block
 }
 
-// CalculateHash calcula el hash de un bloque basado en su contenido.
+// Time devuelve la marca de tiempo del bloque, o el instante cero si no es RFC 3339.
+func (b *Block) Time() time.Time {
+	t, err := time.Parse(time.RFC3339, b.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// CalculateHash calcula el hash de un bloque basado en su contenido. StorageRoot se incluye
+// solo si no está vacía, para que el génesis y los bloques anteriores a las raíces de
+// almacenamiento conserven su hash.
 func (b *Block) CalculateHash() string {
 	transactionsHash := b.HashTransactions()
 	record := fmt.Sprintf("%d%s%s%s%s", b.Index, b.Timestamp, transactionsHash, b.PrevHash, b.MetadataRef)
+	if b.StorageRoot != "" {
+		record += b.StorageRoot
+	}
 	h := sha256.New()
 	h.Write([]byte(record))
 	return hex.EncodeToString(h.Sum(nil))
@@ -46,6 +61,16 @@ func (b *Block) HashTransactions() string {
 	h := sha256.New()
 	for _, tx := range b.Transactions {
 		txRecord := fmt.Sprintf("%s%s%d", tx.From, tx.To, tx.Amount)
+		// Las transferencias simples conservan el formato original del hash.
+		if tx.Type != "" {
+			txRecord += tx.Type + string(canonicalPayload(tx.Payload))
+		}
+		if tx.IsSigned() {
+			txRecord += fmt.Sprintf("%d%s%s", tx.Nonce, tx.PublicKey, tx.Signature)
+			for _, sig := range tx.Signatures {
+				txRecord += sig.PublicKey + sig.Signature
+			}
+		}
 		h.Write([]byte(txRecord))
 	}
 	return hex.EncodeToString(h.Sum(nil))
@@ -77,6 +102,7 @@ func (b *Block) Serialize() (map[string]interface{}, error) {
 		"hash":           b.Hash,
 		"metadata_ref":   b.MetadataRef,
 		"wasm_contracts": string(wasmContractsJSON),
+		"storage_root":   b.StorageRoot,
 	}, nil
 }
 
@@ -96,6 +122,9 @@ func Deserialize(data map[string]interface{}) (*Block, error) {
 		}
 	}
 
+	// Los bloques anteriores a las raíces de almacenamiento no la incluyen.
+	storageRoot, _ := data["storage_root"].(string)
+
 	return &Block{
 		Index:         int(data["index"].(float64)),
 		Timestamp:     data["timestamp"].(string),
@@ -104,5 +133,6 @@ func Deserialize(data map[string]interface{}) (*Block, error) {
 		Hash:          data["hash"].(string),
 		MetadataRef:   data["metadata_ref"].(string),
 		WASMContracts: wasmContracts,
+		StorageRoot:   storageRoot,
 	}, nil
 }
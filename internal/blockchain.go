@@ -17,12 +17,30 @@ func NewBlockchain(metadataRef string) *Blockchain {
 	}
 }
 
-// AddBlock agrega un nuevo bloque a la cadena utilizando transacciones.
-func (bc *Blockchain) AddBlock(transactions []Transaction, metadataRef string) *Block {
+// NextBlock crea el bloque siguiente al último de la cadena sin agregarlo. Su hash es
+// provisional: ApplyBlock lo recalcula al fijar la raíz de almacenamiento.
+func (bc *Blockchain) NextBlock(transactions []Transaction, metadataRef string) *Block {
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(prevBlock.Index+1, transactions, prevBlock.Hash, metadataRef)
-	bc.Blocks = append(bc.Blocks, newBlock)
-	return newBlock
+	return NewBlock(prevBlock.Index+1, transactions, prevBlock.Hash, metadataRef)
+}
+
+// AppendBlock agrega a la cadena un bloque ya aplicado, comprobando que enlace con el último
+// y que su hash incluya su contenido.
+func (bc *Blockchain) AppendBlock(block *Block) error {
+	prevBlock := bc.Blocks[len(bc.Blocks)-1]
+	if block.Index != prevBlock.Index+1 || block.PrevHash != prevBlock.Hash {
+		return fmt.Errorf("el bloque %d no enlaza con el bloque %d", block.Index, prevBlock.Index)
+	}
+	if !block.Validate() {
+		return fmt.Errorf("el bloque %d tiene un hash inválido", block.Index)
+	}
+	bc.Blocks = append(bc.Blocks, block)
+	return nil
+}
+
+// Height devuelve el índice del último bloque de la cadena.
+func (bc *Blockchain) Height() int {
+	return bc.Blocks[len(bc.Blocks)-1].Index
 }
 
 // FindWASMContractByID busca un contrato WASM en la blockchain.
@@ -37,14 +55,6 @@ func (bc *Blockchain) FindWASMContractByID(id string) *WASMContract {
 	return nil
 }
 
-// AddWASMContract agrega un contrato WASM a un bloque reciente.
-func (bc *Blockchain) AddWASMContract(contract WASMContract) *Block {
-	latestBlock := bc.Blocks[len(bc.Blocks)-1]
-	latestBlock.AddWASMContract(contract)
-	latestBlock.Hash = latestBlock.CalculateHash()
-	return latestBlock
-}
-
 // IsValid verifica la integridad de la cadena de bloques.
 func (bc *Blockchain) IsValid() bool {
 	if len(bc.Blocks) == 0 || bc.Blocks[0].Hash != bc.Blocks[0].CalculateHash() {
@@ -69,17 +79,26 @@ func (bc *Blockchain) IsValid() bool {
 	return true
 }
 
-// LoadBlockchain carga todos los bloques desde una base de datos.
+// LoadBlockchain carga todos los bloques desde una base de datos. La cadena almacenada
+// reemplaza a la actual con ReplaceChain, por lo que debe respetar el último punto de control.
 func (bc *Blockchain) LoadBlockchain(db *Database) error {
 	blocks, err := db.LoadBlocks()
 	if err != nil {
 		return fmt.Errorf("error al cargar bloques desde la base de datos: %w", err)
 	}
-	bc.Blocks = make([]*Block, len(blocks))
-	for i, block := range blocks {
-		bc.Blocks[i] = &block
+	if len(blocks) <= len(bc.Blocks) {
+		return nil
 	}
-	return nil
+
+	checkpoint, err := db.LatestCheckpoint()
+	if err != nil {
+		return err
+	}
+	chain := make([]*Block, len(blocks))
+	for i := range blocks {
+		chain[i] = &blocks[i]
+	}
+	return bc.ReplaceChain(chain, checkpoint)
 }
 
 // SaveBlockchain guarda la cadena completa en la base de datos.
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+)
+
+// TestBlockHashCommitsStorageRoot comprueba que el hash de un bloque incluya su raíz de
+// almacenamiento, y que un bloque sin raíz conserve el hash original.
+func TestBlockHashCommitsStorageRoot(t *testing.T) {
+	bc := NewBlockchain("génesis")
+	block := bc.NextBlock([]Transaction{{From: "a", To: "b", Amount: 10}}, "meta")
+	unsealed := block.CalculateHash()
+
+	block.StorageRoot = EmptyMerkleRoot
+	block.Hash = block.CalculateHash()
+	if block.Hash == unsealed {
+		t.Fatal("la raíz de almacenamiento no cambió el hash")
+	}
+
+	tampered := *block
+	tampered.StorageRoot = merkleRoot([][]byte{merkleLeaf([]byte("c"), []byte("r"))})
+	if tampered.Validate() {
+		t.Error("se validó un bloque con la raíz alterada")
+	}
+	if err := bc.AppendBlock(&tampered); err == nil {
+		t.Error("se agregó un bloque con la raíz alterada")
+	}
+
+	if err := bc.AppendBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if !bc.IsValid() {
+		t.Error("la cadena con el bloque sellado no es válida")
+	}
+	if err := bc.AppendBlock(bc.NextBlock(nil, "meta")); err != nil {
+		t.Errorf("bloque sin raíz: %s", err)
+	}
+	stale := bc.NextBlock(nil, "meta")
+	stale.PrevHash = block.Hash
+	stale.Hash = stale.CalculateHash()
+	if err := bc.AppendBlock(stale); err == nil {
+		t.Error("se agregó un bloque que no enlaza con el último")
+	}
+}
@@ -0,0 +1,488 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Estados de un canal de pago.
+const (
+	ChannelOpen    = "open"
+	ChannelClosing = "closing" // Cierre unilateral del pagador en período de impugnación
+	ChannelSettled = "settled"
+)
+
+// MinChallengePeriod es el período de impugnación mínimo, en bloques, que da tiempo al receptor
+// a presentar un estado más reciente tras un cierre unilateral.
+const MinChallengePeriod = 10
+
+// ChannelOpenPayload define el período de impugnación de un canal nuevo. El pagador es el
+// emisor de la transacción, el receptor su destinatario y el depósito su monto.
+type ChannelOpenPayload struct {
+	ChallengePeriod int `json:"challenge_period"`
+}
+
+// ChannelClosePayload contiene el estado con el que se cierra o impugna un canal. Update lleva la
+// firma de la contraparte del emisor; PayeeKey es la clave del receptor cuando el pagador cierra
+// de forma cooperativa.
+type ChannelClosePayload struct {
+	Update   wallet.ChannelUpdate `json:"update"`
+	PayeeKey string               `json:"payee_public_key,omitempty"`
+}
+
+// Channel es un canal de pago unidireccional. Su identificador es el hash de la transacción que lo abrió.
+type Channel struct {
+	ID              string `json:"id"`
+	Payer           string `json:"payer"`
+	Payee           string `json:"payee"`
+	PayerKey        string `json:"payer_public_key"` // Verifica las actualizaciones fuera de la cadena
+	Deposit         int64  `json:"deposit"`
+	ChallengePeriod int    `json:"challenge_period"`
+	Status          string `json:"status"`
+	Amount          int64  `json:"amount"` // Monto cedido al receptor según el estado de cierre
+	ClosesAt        int    `json:"closes_at,omitempty"`
+	CreatedHeight   int    `json:"created_height"`
+	SettledHeight   int    `json:"settled_height,omitempty"`
+}
+
+// checkState comprueba que el estado sea de este canal y no exceda el depósito.
+func (c Channel) checkState(update wallet.ChannelUpdate) error {
+	if update.Channel != c.ID {
+		return errors.New("la actualización es de otro canal")
+	}
+	if update.Amount < 0 || update.Amount > c.Deposit {
+		return fmt.Errorf("el monto debe estar entre 0 y el depósito (%d)", c.Deposit)
+	}
+	return nil
+}
+
+// checkUpdate comprueba que la actualización sea válida para el canal y esté firmada por el pagador.
+func (c Channel) checkUpdate(update wallet.ChannelUpdate) error {
+	if err := c.checkState(update); err != nil {
+		return err
+	}
+	if err := update.Verify(c.PayerKey); err != nil {
+		return fmt.Errorf("firma del pagador inválida: %w", err)
+	}
+	return nil
+}
+
+// closeAction valida un cierre o impugnación enviado por la cuenta from e indica si el canal
+// se liquida de inmediato o entra en período de impugnación.
+func (c Channel) closeAction(txType, from string, payload ChannelClosePayload, ctx BlockContext) (settle bool, err error) {
+	update := payload.Update
+	switch txType {
+	case TxTypeChannelClose:
+		if c.Status != ChannelOpen {
+			return false, fmt.Errorf("el canal %s no está abierto", c.ID)
+		}
+		switch from {
+		case c.Payee:
+			// El receptor cierra con un estado firmado por el pagador. La transacción va firmada
+			// para que nadie pueda cerrar en su nombre con un estado anterior.
+			return true, c.checkUpdate(update)
+		case c.Payer:
+			if err := c.checkState(update); err != nil {
+				return false, err
+			}
+			// Sin la firma del receptor el cierre es unilateral y puede impugnarse.
+			if update.Signature == "" {
+				return false, nil
+			}
+			address, _, err := wallet.AddressFromPublicKey(payload.PayeeKey)
+			if err != nil || address != c.Payee {
+				return false, errors.New("la clave pública no corresponde al receptor")
+			}
+			if err := update.Verify(payload.PayeeKey); err != nil {
+				return false, fmt.Errorf("firma del receptor inválida: %w", err)
+			}
+			return true, nil
+		default:
+			return false, errors.New("solo el pagador o el receptor pueden cerrar el canal")
+		}
+	case TxTypeChannelChallenge:
+		if c.Status != ChannelClosing || ctx.Height >= c.ClosesAt {
+			return false, fmt.Errorf("el canal %s no está en período de impugnación", c.ID)
+		}
+		if from != c.Payee {
+			return false, errors.New("solo el receptor puede impugnar el cierre")
+		}
+		if update.Amount <= c.Amount {
+			return false, fmt.Errorf("el estado debe ser más reciente que el de cierre (%d)", c.Amount)
+		}
+		return true, c.checkUpdate(update)
+	default:
+		return false, fmt.Errorf("tipo de transacción desconocido: %s", txType)
+	}
+}
+
+// applyChannelOpen debita el depósito al pagador y abre el canal hacia el receptor.
+func (d *Database) applyChannelOpen(tx Transaction, ctx BlockContext) error {
+	id, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+	// La clave pública verifica las actualizaciones del pagador fuera de la cadena.
+	if tx.PublicKey == "" {
+		return errors.New("la apertura del canal debe estar firmada con la clave del pagador")
+	}
+
+	var payload ChannelOpenPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos del canal inválidos: %w", err)
+	}
+	if err := payload.validate(tx.From, tx.To); err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			`INSERT INTO payment_channels (id, payer, payee, payer_key, deposit, challenge_period, status, created_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			id, tx.From, tx.To, tx.PublicKey, tx.Amount, payload.ChallengePeriod, ChannelOpen, ctx.Height,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando canal: %w", err)
+		}
+
+		fmt.Printf("Canal %s abierto: depósito de %d de %s para %s\n", id, tx.Amount, tx.From, tx.To)
+		return nil
+	})
+}
+
+// validate comprueba el período de impugnación y que pagador y receptor sean distintos.
+func (p ChannelOpenPayload) validate(payer, payee string) error {
+	if payer == payee {
+		return errors.New("el pagador y el receptor deben ser cuentas distintas")
+	}
+	if p.ChallengePeriod < MinChallengePeriod {
+		return fmt.Errorf("el período de impugnación debe ser de al menos %d bloques", MinChallengePeriod)
+	}
+	return nil
+}
+
+// applyChannelClose aplica un cierre o una impugnación: liquida el canal o inicia el período de impugnación.
+func (d *Database) applyChannelClose(tx Transaction, ctx BlockContext) error {
+	if !tx.IsSigned() {
+		return errors.New("el cierre o la impugnación de un canal debe estar firmado")
+	}
+
+	var payload ChannelClosePayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos del canal inválidos: %w", err)
+	}
+
+	channel, err := d.GetChannel(payload.Update.Channel)
+	if err != nil {
+		return err
+	}
+	if channel == nil {
+		return fmt.Errorf("el canal %s no existe", payload.Update.Channel)
+	}
+
+	settle, err := channel.closeAction(tx.Type, tx.From, payload, ctx)
+	if err != nil {
+		return err
+	}
+	if settle {
+		return d.settleChannel(channel, payload.Update.Amount, tx.Type, ctx)
+	}
+
+	closesAt := ctx.Height + channel.ChallengePeriod
+	result, err := d.Connection.Exec(
+		"UPDATE payment_channels SET status = $2, amount = $3, closes_at = $4 WHERE id = $1 AND status = $5",
+		channel.ID, ChannelClosing, payload.Update.Amount, closesAt, ChannelOpen,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando canal: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("el canal %s ya no está abierto", channel.ID)
+	}
+
+	fmt.Printf("Canal %s en cierre con %d para el receptor hasta el bloque %d\n", channel.ID, payload.Update.Amount, closesAt)
+	return nil
+}
+
+// settleChannel liquida un canal: el monto cedido va al receptor y el resto del depósito vuelve al pagador.
+func (d *Database) settleChannel(channel *Channel, amount int64, txType string, ctx BlockContext) error {
+	return d.atomically(func(state sqlState) error {
+		result, err := state.q.Exec(
+			"UPDATE payment_channels SET status = $2, amount = $3, settled_height = $4 WHERE id = $1 AND status = $5",
+			channel.ID, ChannelSettled, amount, ctx.Height, channel.Status,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando canal: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("el canal %s cambió de estado", channel.ID)
+		}
+
+		shares := []struct {
+			account string
+			amount  int64
+		}{
+			{channel.Payee, amount},
+			{channel.Payer, channel.Deposit - amount},
+		}
+		for _, share := range shares {
+			if share.amount == 0 {
+				continue
+			}
+			if err := state.credit(share.account, share.amount); err != nil {
+				return err
+			}
+			err := state.recordHistory(HistoryEntry{
+				From:      channel.Payer,
+				To:        share.account,
+				Amount:    share.amount,
+				Type:      txType,
+				TxHash:    channel.ID,
+				Timestamp: ctx.Time,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Canal %s liquidado: %d para el receptor, %d para el pagador\n",
+			channel.ID, amount, channel.Deposit-amount)
+		return nil
+	})
+}
+
+// channelTimeoutType identifica en el historial las liquidaciones al vencer el período de impugnación.
+const channelTimeoutType = "channel_timeout"
+
+// settleChallengedChannels liquida los canales cuyo período de impugnación termina en el bloque.
+func (d *Database) settleChallengedChannels(ctx BlockContext) error {
+	rows, err := d.Connection.Query(
+		selectChannelQuery+" WHERE status = $1 AND closes_at <= $2",
+		ChannelClosing, ctx.Height,
+	)
+	if err != nil {
+		return fmt.Errorf("error obteniendo canales en cierre: %w", err)
+	}
+
+	var closing []*Channel
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error al escanear canal: %w", err)
+		}
+		closing = append(closing, channel)
+	}
+	rows.Close()
+
+	for _, channel := range closing {
+		if err := d.settleChannel(channel, channel.Amount, channelTimeoutType, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const selectChannelQuery = `SELECT id, payer, payee, payer_key, deposit, challenge_period, status, amount,
+	closes_at, created_height, settled_height FROM payment_channels`
+
+// scanChannel lee un canal de una fila de selectChannelQuery.
+func scanChannel(row interface{ Scan(...interface{}) error }) (*Channel, error) {
+	var c Channel
+	err := row.Scan(&c.ID, &c.Payer, &c.Payee, &c.PayerKey, &c.Deposit, &c.ChallengePeriod, &c.Status,
+		&c.Amount, &c.ClosesAt, &c.CreatedHeight, &c.SettledHeight)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetChannel obtiene un canal por su identificador, o nil si no existe.
+func (d *Database) GetChannel(id string) (*Channel, error) {
+	channel, err := scanChannel(d.Connection.QueryRow(selectChannelQuery+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo canal: %w", err)
+	}
+	return channel, nil
+}
+
+// GetChannels obtiene los canales con el estado dado en los que participa una cuenta (todos si es vacía).
+func (d *Database) GetChannels(account, status string) ([]Channel, error) {
+	rows, err := d.Connection.Query(
+		selectChannelQuery+` WHERE status = $1 AND ($2 = '' OR payer = $2 OR payee = $2) ORDER BY created_height ASC`,
+		status, account,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo canales: %w", err)
+	}
+	defer rows.Close()
+
+	channels := []Channel{}
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error al escanear canal: %w", err)
+		}
+		channels = append(channels, *channel)
+	}
+	return channels, nil
+}
+
+// ChannelOpenHandler maneja la apertura de un canal de pago. La transacción debe estar firmada;
+// su hash, devuelto como tx_hash, identifica el canal.
+func (s *Server) ChannelOpenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		ChannelOpenPayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From, &payload.To) {
+		return
+	}
+	if payload.Signature == "" || payload.PublicKey == "" {
+		http.Error(w, "La apertura del canal debe estar firmada con la clave del pagador", http.StatusBadRequest)
+		return
+	}
+	if err := payload.ChannelOpenPayload.validate(payload.From, payload.To); err != nil {
+		http.Error(w, fmt.Sprintf("Canal inválido: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	data, _ := json.Marshal(payload.ChannelOpenPayload)
+	s.submitTransaction(w, Transaction{
+		From:      payload.From,
+		To:        payload.To,
+		Amount:    payload.Amount,
+		Type:      TxTypeChannelOpen,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// ChannelCloseHandler maneja el cierre de un canal por el receptor, cooperativo o unilateral del pagador.
+func (s *Server) ChannelCloseHandler(w http.ResponseWriter, r *http.Request) {
+	s.channelCloseHandler(w, r, TxTypeChannelClose)
+}
+
+// ChannelChallengeHandler maneja la impugnación de un cierre unilateral con un estado más reciente.
+func (s *Server) ChannelChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	s.channelCloseHandler(w, r, TxTypeChannelChallenge)
+}
+
+// channelCloseHandler valida un cierre o impugnación contra el estado actual y lo encola.
+func (s *Server) channelCloseHandler(w http.ResponseWriter, r *http.Request, txType string) {
+	var payload struct {
+		From string `json:"from"`
+		ChannelClosePayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From) {
+		return
+	}
+	if payload.Signature == "" {
+		http.Error(w, "El cierre o la impugnación de un canal debe estar firmado", http.StatusBadRequest)
+		return
+	}
+
+	channel, err := s.DB.GetChannel(payload.Update.Channel)
+	if err != nil {
+		http.Error(w, "Error obteniendo el canal", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil {
+		http.Error(w, "El canal no existe", http.StatusNotFound)
+		return
+	}
+	if _, err := channel.closeAction(txType, payload.From, payload.ChannelClosePayload, s.nextBlock()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.queueSettlement(w, payload.From, txType, payload.ChannelClosePayload, payload.TxAuth)
+}
+
+// VerifyChannelUpdate maneja la verificación de una actualización recibida fuera de la cadena,
+// para que el receptor compruebe un pago antes de entregar lo que vende.
+func (s *Server) VerifyChannelUpdate(w http.ResponseWriter, r *http.Request) {
+	var update wallet.ChannelUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	update.Channel = mux.Vars(r)["id"]
+
+	channel, err := s.DB.GetChannel(update.Channel)
+	if err != nil {
+		http.Error(w, "Error obteniendo el canal", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil {
+		http.Error(w, "El canal no existe", http.StatusNotFound)
+		return
+	}
+	if channel.Status != ChannelOpen {
+		http.Error(w, "El canal no está abierto", http.StatusConflict)
+		return
+	}
+	if err := channel.checkUpdate(update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"channel":   channel.ID,
+		"amount":    update.Amount,
+		"remaining": channel.Deposit - update.Amount,
+	})
+}
+
+// GetChannel maneja la consulta de un canal por su identificador.
+func (s *Server) GetChannel(w http.ResponseWriter, r *http.Request) {
+	channel, err := s.DB.GetChannel(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Error obteniendo el canal", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil {
+		http.Error(w, "El canal no existe", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channel)
+}
+
+// GetChannels maneja la consulta de canales por cuenta y estado (por defecto, los abiertos).
+func (s *Server) GetChannels(w http.ResponseWriter, r *http.Request) {
+	s.listByAccount(w, r, ChannelOpen, "canales", func(account, status string) (interface{}, error) {
+		return s.DB.GetChannels(account, status)
+	})
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config contiene la configuración del nodo leída de configs/config.yaml.
+type Config struct {
+	Difficulty         int               `yaml:"difficulty"`
+	TrustedCheckpoints []Checkpoint      `yaml:"trusted_checkpoints"`         // Puntos de control confiables para nodos que sincronizan
+	AllowUnsigned      bool              `yaml:"allow_unsigned_transactions"` // Acepta transferencias sin firma de cuentas que nunca firmaron
+	LegacyAddresses    bool              `yaml:"legacy_addresses"`            // Acepta direcciones hexadecimales sin checksum
+	Faucet             FaucetConfig      `yaml:"faucet"`
+	WASM               ModuleCacheConfig `yaml:"wasm"`
+}
+
+// LoadConfig carga la configuración desde un archivo YAML. Si el archivo no existe
+// se devuelve la configuración por defecto.
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{Difficulty: 3, LegacyAddresses: true, WASM: ModuleCacheConfig{Size: DefaultModuleCacheSize}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Archivo de configuración %s no encontrado, usando valores por defecto\n", path)
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error leyendo la configuración: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error interpretando la configuración: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Estados de un recibo de llamada a contrato.
+const (
+	ReceiptSuccess = "success"
+	ReceiptFailed  = "failed"
+)
+
+// methodName restringe los métodos invocables a identificadores simples.
+var methodName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// ContractCallPayload contiene el método, los argumentos y el límite de gas de una llamada. El
+// emisor de la transacción es quien llama, el destinatario el contrato y el monto el valor
+// que se transfiere a la cuenta del contrato antes de ejecutarlo.
+type ContractCallPayload struct {
+	Method   string `json:"method"`
+	Args     []byte `json:"args,omitempty"`
+	GasLimit uint64 `json:"gas_limit"`
+}
+
+// Receipt es el resultado de una llamada a contrato incluida en un bloque.
+type Receipt struct {
+	TxHash      string          `json:"tx_hash"`
+	Contract    string          `json:"contract"`
+	Caller      string          `json:"caller"`
+	Method      string          `json:"method"`
+	Value       int64           `json:"value"`
+	Status      string          `json:"status"`
+	Output      []byte          `json:"output,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	GasUsed     uint64          `json:"gas_used"`
+	Logs        []string        `json:"logs"`
+	Events      []ContractEvent `json:"events"` // Vacío si la llamada falló
+	BlockHeight int             `json:"block_height"`
+}
+
+// validateContractCall comprueba el nombre del método y el límite de gas de una llamada.
+func validateContractCall(method string, gasLimit uint64) error {
+	if !methodName.MatchString(method) || method == ContractAllocExport || method == ReentrancyGuardExport {
+		return fmt.Errorf("método inválido: %q", method)
+	}
+	if gasLimit == 0 || gasLimit > MaxGasLimit {
+		return fmt.Errorf("el límite de gas debe estar entre 1 y %d", MaxGasLimit)
+	}
+	return nil
+}
+
+// applyContractCall ejecuta una llamada a contrato durante la aplicación del bloque. El valor
+// transferido, las escrituras de almacenamiento, las transferencias del contrato, los eventos y
+// el recibo se confirman en una única transacción de base de datos; si la ejecución falla se
+// descartan y solo queda el recibo del fallo. Los eventos confirmados se envían a los
+// suscriptores.
+func (d *Database) applyContractCall(tx Transaction, ctx BlockContext) error {
+	id, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+
+	var payload ContractCallPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos de la llamada inválidos: %w", err)
+	}
+	if err := validateContractCall(payload.Method, payload.GasLimit); err != nil {
+		return err
+	}
+	if tx.Amount < 0 {
+		return errors.New("el valor no puede ser negativo")
+	}
+
+	receipt := Receipt{
+		TxHash:      id,
+		Contract:    tx.To,
+		Caller:      tx.From,
+		Method:      payload.Method,
+		Value:       tx.Amount,
+		Logs:        []string{},
+		Events:      []ContractEvent{},
+		BlockHeight: ctx.Height,
+	}
+
+	contract, err := d.LoadWASMContract(tx.To)
+	if err != nil {
+		return err
+	}
+	if contract == nil {
+		return d.failReceipt(receipt, fmt.Errorf("el contrato %s no existe", tx.To))
+	}
+
+	dbTx, err := d.Connection.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando llamada a contrato: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	state := sqlState{dbTx}
+	if tx.Amount > 0 {
+		if err := state.transfer(tx.From, tx.To, tx.Amount, TxTypeContractCall, receipt.TxHash, ctx); err != nil {
+			return d.failReceipt(receipt, err)
+		}
+	}
+
+	buffer := newStorageBuffer(state)
+	env := ExecutionEnv{Caller: tx.From, Block: ctx, State: buffer}
+	result, err := contract.Execute(env, payload.Method, payload.Args, payload.GasLimit)
+	receipt.Logs = contract.Logs
+	if result != nil {
+		receipt.GasUsed = result.GasUsed
+	}
+	if err != nil {
+		dbTx.Rollback()
+		return d.failReceipt(receipt, err)
+	}
+
+	if err := state.commitStorage(buffer, ctx.Height); err != nil {
+		return err
+	}
+	receipt.Status = ReceiptSuccess
+	receipt.Output = result.Output
+	for i, event := range result.Events {
+		event.TxHash, event.LogIndex, event.BlockHeight = receipt.TxHash, i, ctx.Height
+		receipt.Events = append(receipt.Events, event)
+	}
+	if err := saveReceipt(dbTx, receipt); err != nil {
+		return err
+	}
+	if err := saveEvents(dbTx, receipt.Events); err != nil {
+		return err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return fmt.Errorf("error confirmando llamada a contrato: %w", err)
+	}
+	contractEvents.Publish(receipt.Events)
+
+	fmt.Printf("Llamada a %s.%s de %s completada con %d de gas\n", tx.To, payload.Method, tx.From, receipt.GasUsed)
+	return nil
+}
+
+// failReceipt guarda el recibo de una llamada fallida y devuelve el error de la ejecución.
+func (d *Database) failReceipt(receipt Receipt, cause error) error {
+	receipt.Status = ReceiptFailed
+	receipt.Error = cause.Error()
+	if err := saveReceipt(d.Connection, receipt); err != nil {
+		return err
+	}
+	return fmt.Errorf("llamada a %s.%s fallida: %w", receipt.Contract, receipt.Method, cause)
+}
+
+// saveReceipt guarda un recibo de llamada a contrato.
+func saveReceipt(q querier, receipt Receipt) error {
+	logs, err := json.Marshal(receipt.Logs)
+	if err != nil {
+		return fmt.Errorf("error serializando registros: %w", err)
+	}
+
+	_, err = q.Exec(
+		`INSERT INTO contract_receipts (tx_hash, contract_id, caller, method, value, status, output, error, gas_used, logs, block_height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		receipt.TxHash, receipt.Contract, receipt.Caller, receipt.Method, receipt.Value, receipt.Status,
+		receipt.Output, receipt.Error, int64(receipt.GasUsed), logs, receipt.BlockHeight,
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando recibo: %w", err)
+	}
+	return nil
+}
+
+// GetReceipt obtiene el recibo de una llamada a contrato por el hash de su transacción, o nil si no existe.
+func (d *Database) GetReceipt(txHash string) (*Receipt, error) {
+	var receipt Receipt
+	var logs []byte
+	var gasUsed int64
+	err := d.Connection.QueryRow(
+		`SELECT tx_hash, contract_id, caller, method, value, status, output, error, gas_used, logs, block_height
+		FROM contract_receipts WHERE tx_hash = $1`,
+		txHash,
+	).Scan(&receipt.TxHash, &receipt.Contract, &receipt.Caller, &receipt.Method, &receipt.Value, &receipt.Status,
+		&receipt.Output, &receipt.Error, &gasUsed, &logs, &receipt.BlockHeight)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo recibo: %w", err)
+	}
+
+	receipt.GasUsed = uint64(gasUsed)
+	if err := json.Unmarshal(logs, &receipt.Logs); err != nil {
+		return nil, fmt.Errorf("error deserializando registros: %w", err)
+	}
+	if receipt.Events, err = d.receiptEvents(txHash); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// ContractCallHandler maneja el envío de una llamada a contrato, que se ejecuta al aplicarse
+// el bloque que la incluye. El tx_hash de la respuesta permite consultar el recibo.
+func (s *Server) ContractCallHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From     string `json:"from"`
+		Contract string `json:"contract"`
+		Value    int64  `json:"value"`
+		ContractCallPayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From) {
+		return
+	}
+	if payload.Signature == "" {
+		http.Error(w, "Las llamadas a contratos requieren una transacción firmada", http.StatusBadRequest)
+		return
+	}
+	if err := validateContractCall(payload.Method, payload.GasLimit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.Value < 0 {
+		http.Error(w, "El valor no puede ser negativo", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Args) > MaxContractIOSize {
+		http.Error(w, fmt.Sprintf("Los argumentos no pueden superar %d bytes", MaxContractIOSize), http.StatusBadRequest)
+		return
+	}
+
+	payload.Contract = s.contractID(payload.Contract)
+	contract, err := s.DB.LoadWASMContract(payload.Contract)
+	if err != nil {
+		http.Error(w, "Error obteniendo el contrato", http.StatusInternalServerError)
+		return
+	}
+	if contract == nil {
+		http.Error(w, "Contrato WASM no encontrado", http.StatusNotFound)
+		return
+	}
+
+	data, _ := json.Marshal(payload.ContractCallPayload)
+	tx := Transaction{
+		From:      payload.From,
+		To:        payload.Contract,
+		Amount:    payload.Value,
+		Type:      TxTypeContractCall,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	}
+	if payload.Value > 0 {
+		s.submitTransaction(w, tx)
+	} else if s.authorized(w, tx) {
+		s.queueTransaction(w, tx)
+	}
+}
+
+// GetReceipt maneja la consulta del recibo de una llamada a contrato.
+func (s *Server) GetReceipt(w http.ResponseWriter, r *http.Request) {
+	receipt, err := s.DB.GetReceipt(mux.Vars(r)["hash"])
+	if err != nil {
+		http.Error(w, "Error obteniendo el recibo", http.StatusInternalServerError)
+		return
+	}
+	if receipt == nil {
+		http.Error(w, "Recibo no encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
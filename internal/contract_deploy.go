@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blockchain-go/wallet"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// MaxContractCodeSize es el tamaño máximo del módulo WASM de un contrato.
+const MaxContractCodeSize = 512 * 1024
+
+// ContractDeployPayload contiene el módulo WASM de una transacción de despliegue. El destino de
+// la transacción es la dirección del contrato, derivada del emisor y el nonce.
+type ContractDeployPayload struct {
+	Code []byte `json:"code"`
+}
+
+// ValidateContractCode comprueba que un módulo pueda ejecutarse como contrato: que admita la
+// instrumentación de gas, que compile, que solo importe funciones del host con su firma
+// correcta y que exporte su memoria.
+func ValidateContractCode(code []byte) error {
+	if len(code) == 0 {
+		return errors.New("el módulo WASM está vacío")
+	}
+	if len(code) > MaxContractCodeSize {
+		return fmt.Errorf("el módulo WASM no puede superar %d bytes", MaxContractCodeSize)
+	}
+
+	module, store, err := contractModules.Load(code)
+	if err != nil {
+		return err
+	}
+
+	// Instanciar con las funciones del host detecta importaciones desconocidas o con otra firma
+	// y segmentos de datos fuera de la memoria. Sin función de inicio no se ejecuta código.
+	host := &hostEnv{contract: &WASMContract{}}
+	if _, err := wasmer.NewInstance(module, host.hostImports(store)); err != nil {
+		return fmt.Errorf("error al instanciar el módulo WASM: %w", err)
+	}
+
+	for _, export := range module.Exports() {
+		if export.Name() == "memory" && export.Type().Kind() == wasmer.MEMORY {
+			return nil
+		}
+	}
+	return errors.New("el módulo WASM debe exportar su memoria como 'memory'")
+}
+
+// applyContractDeploy registra el contrato de una transacción de despliegue en la dirección
+// derivada del emisor y el nonce, que la firma de la transacción ya cubre.
+func (d *Database) applyContractDeploy(tx Transaction) error {
+	if !tx.IsSigned() {
+		return errors.New("el despliegue de contratos requiere una transacción firmada")
+	}
+	if tx.Amount != 0 {
+		return errors.New("el despliegue de contratos no transfiere fondos")
+	}
+
+	id := wallet.ContractAddress(tx.From, tx.Nonce)
+	if tx.To != id {
+		return fmt.Errorf("la dirección del contrato debe ser %s", id)
+	}
+
+	var payload ContractDeployPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos del despliegue inválidos: %w", err)
+	}
+	if err := ValidateContractCode(payload.Code); err != nil {
+		return err
+	}
+
+	exists, err := d.ContractExists(id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("el contrato %s ya existe", id)
+	}
+
+	return d.SaveWASMContract(*NewWASMContract(id, tx.From, payload.Code))
+}
+
+// recordDeployment agrega al bloque el contrato que desplegó una de sus transacciones y guarda
+// la altura del despliegue.
+func (d *Database) recordDeployment(block *Block, id string) error {
+	contract, err := d.LoadWASMContract(id)
+	if err != nil {
+		return err
+	}
+	if contract == nil {
+		return fmt.Errorf("el contrato %s no se guardó", id)
+	}
+	if _, err := d.Connection.Exec("UPDATE wasm_contracts SET deployed_height = $2 WHERE id = $1", id, block.Index); err != nil {
+		return fmt.Errorf("error guardando la altura de despliegue de %s: %w", id, err)
+	}
+	block.AddWASMContract(*contract)
+	return nil
+}
+
+// DeployContractHandler maneja el envío de una transacción firmada que despliega un contrato
+// WASM. El módulo se valida antes de aceptarlo; la respuesta incluye la dirección que tendrá
+// el contrato cuando se incluya la transacción.
+func (s *Server) DeployContractHandler(w http.ResponseWriter, r *http.Request) {
+	var deployment wallet.Deployment
+	if err := json.NewDecoder(r.Body).Decode(&deployment); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &deployment.From) {
+		return
+	}
+	if deployment.Signature == "" {
+		http.Error(w, "El despliegue de contratos requiere una transacción firmada", http.StatusBadRequest)
+		return
+	}
+	if err := ValidateContractCode(deployment.Code); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := wallet.DeployPayload(deployment.Code)
+	if err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	tx := Transaction{
+		From:      deployment.From,
+		To:        wallet.ContractAddress(deployment.From, deployment.Nonce),
+		Type:      TxTypeContractDeploy,
+		Payload:   payload,
+		Nonce:     deployment.Nonce,
+		PublicKey: deployment.PublicKey,
+		Signature: deployment.Signature,
+	}
+	if !s.authorized(w, tx) {
+		return
+	}
+	if err := s.DB.AddPendingTx(tx); err != nil {
+		http.Error(w, "Error añadiendo transacción pendiente", http.StatusInternalServerError)
+		return
+	}
+
+	contract, _ := wallet.EncodeAddress(s.DB.AddressPrefix, wallet.AddressVersionContract, tx.To)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Despliegue añadido a la cola",
+		"tx_hash":  tx.Hash(),
+		"contract": contract,
+	})
+}
+
+// contractID acepta el ID de un contrato o su dirección codificada y devuelve el ID con el que
+// se guarda. Los contratos de génesis conservan el ID que les asigna el archivo de génesis.
+func (s *Server) contractID(id string) string {
+	if canonical, err := s.DB.ParseAddress(id); err == nil {
+		return canonical
+	}
+	return id
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Límites de los eventos de contratos.
+const (
+	MaxEventTopics   = 4         // Temas por evento
+	EventTopicSize   = 32        // Bytes de cada tema
+	MaxEventDataSize = 16 * 1024 // Bytes de datos por evento
+	MaxLogResults    = 1000      // Eventos que devuelve una consulta de /logs
+)
+
+// logStreamBuffer es la cantidad de eventos que puede acumular un suscriptor lento antes de que
+// se cierre su suscripción.
+const logStreamBuffer = 256
+
+// logStreamHeartbeat es el intervalo de los comentarios que mantienen abierta una suscripción.
+const logStreamHeartbeat = 15 * time.Second
+
+// ContractEvent es un evento emitido por un contrato con emit_event. Los temas, en hexadecimal,
+// permiten filtrar los eventos; los datos son libres.
+type ContractEvent struct {
+	Contract    string   `json:"contract"`
+	Topics      []string `json:"topics"`
+	Data        []byte   `json:"data"`
+	TxHash      string   `json:"tx_hash,omitempty"`
+	LogIndex    int      `json:"log_index"` // Posición del evento dentro de la transacción
+	BlockHeight int      `json:"block_height"`
+}
+
+// LogFilter selecciona eventos por contrato, temas y rango de bloques. Los campos vacíos no
+// filtran.
+type LogFilter struct {
+	Contract  string
+	Topic     string                 // Tema en cualquier posición
+	Topics    [MaxEventTopics]string // Tema en cada posición
+	FromBlock int
+	ToBlock   int // Negativo para no limitar
+}
+
+// Matches indica si un evento cumple el filtro.
+func (f LogFilter) Matches(event ContractEvent) bool {
+	if f.Contract != "" && event.Contract != f.Contract {
+		return false
+	}
+	if event.BlockHeight < f.FromBlock || (f.ToBlock >= 0 && event.BlockHeight > f.ToBlock) {
+		return false
+	}
+	for i, topic := range f.Topics {
+		if topic != "" && (i >= len(event.Topics) || event.Topics[i] != topic) {
+			return false
+		}
+	}
+	if f.Topic == "" {
+		return true
+	}
+	for _, topic := range event.Topics {
+		if topic == f.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// saveEvents guarda los eventos de una llamada a contrato, indexados por contrato y por tema.
+func saveEvents(q querier, events []ContractEvent) error {
+	for _, event := range events {
+		var topics [MaxEventTopics]sql.NullString
+		for i, topic := range event.Topics {
+			topics[i] = sql.NullString{String: topic, Valid: true}
+		}
+		_, err := q.Exec(
+			`INSERT INTO contract_events (tx_hash, log_index, contract_id, topic0, topic1, topic2, topic3, data, block_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			event.TxHash, event.LogIndex, event.Contract, topics[0], topics[1], topics[2], topics[3],
+			event.Data, event.BlockHeight,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando evento: %w", err)
+		}
+	}
+	return nil
+}
+
+// selectEventQuery selecciona los campos de un evento en el orden que espera scanEvents.
+const selectEventQuery = `SELECT tx_hash, log_index, contract_id, topic0, topic1, topic2, topic3, data, block_height
+	FROM contract_events`
+
+// scanEvents lee los eventos de una consulta basada en selectEventQuery.
+func scanEvents(rows *sql.Rows) ([]ContractEvent, error) {
+	defer rows.Close()
+
+	events := []ContractEvent{}
+	for rows.Next() {
+		var event ContractEvent
+		var topics [MaxEventTopics]sql.NullString
+		err := rows.Scan(&event.TxHash, &event.LogIndex, &event.Contract,
+			&topics[0], &topics[1], &topics[2], &topics[3], &event.Data, &event.BlockHeight)
+		if err != nil {
+			return nil, fmt.Errorf("error al escanear evento: %w", err)
+		}
+		event.Topics = []string{}
+		for _, topic := range topics {
+			if topic.Valid {
+				event.Topics = append(event.Topics, topic.String)
+			}
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error obteniendo eventos: %w", err)
+	}
+	return events, nil
+}
+
+// receiptEvents obtiene los eventos de una llamada a contrato, en el orden en que se emitieron.
+func (d *Database) receiptEvents(txHash string) ([]ContractEvent, error) {
+	rows, err := d.Connection.Query(selectEventQuery+" WHERE tx_hash = $1 ORDER BY log_index", txHash)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo eventos: %w", err)
+	}
+	return scanEvents(rows)
+}
+
+// GetLogs obtiene hasta limit eventos que cumplen el filtro, en el orden en que se aplicaron.
+func (d *Database) GetLogs(filter LogFilter, limit int) ([]ContractEvent, error) {
+	rows, err := d.Connection.Query(
+		selectEventQuery+` WHERE ($1 = '' OR contract_id = $1)
+		AND ($2 = '' OR $2 IN (topic0, topic1, topic2, topic3))
+		AND ($3 = '' OR topic0 = $3) AND ($4 = '' OR topic1 = $4)
+		AND ($5 = '' OR topic2 = $5) AND ($6 = '' OR topic3 = $6)
+		AND block_height >= $7 AND ($8::INTEGER < 0 OR block_height <= $8)
+		ORDER BY id LIMIT $9`,
+		filter.Contract, filter.Topic, filter.Topics[0], filter.Topics[1], filter.Topics[2], filter.Topics[3],
+		filter.FromBlock, filter.ToBlock, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo eventos: %w", err)
+	}
+	return scanEvents(rows)
+}
+
+// EventBroker reparte los eventos confirmados entre los suscriptores cuyo filtro cumplen.
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[*logSubscriber]struct{}
+}
+
+// logSubscriber es una suscripción a eventos.
+type logSubscriber struct {
+	filter LogFilter
+	events chan ContractEvent
+}
+
+// NewEventBroker crea un repartidor de eventos sin suscriptores.
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[*logSubscriber]struct{})}
+}
+
+// contractEvents reparte los eventos de las llamadas a contratos que confirma el nodo.
+var contractEvents = NewEventBroker()
+
+// Subscribe registra una suscripción a los eventos que cumplen el filtro. El canal se cierra
+// al cancelar la suscripción o si el suscriptor no consume los eventos a tiempo.
+func (b *EventBroker) Subscribe(filter LogFilter) (<-chan ContractEvent, func()) {
+	subscriber := &logSubscriber{filter: filter, events: make(chan ContractEvent, logStreamBuffer)}
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+
+	return subscriber.events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(subscriber)
+	}
+}
+
+// remove cierra una suscripción si sigue activa. Debe llamarse con el candado tomado.
+func (b *EventBroker) remove(subscriber *logSubscriber) {
+	if _, ok := b.subscribers[subscriber]; ok {
+		delete(b.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// Publish entrega eventos a los suscriptores sin bloquearse: si el búfer de un suscriptor está
+// lleno, su suscripción se cierra.
+func (b *EventBroker) Publish(events []ContractEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscriber := range b.subscribers {
+		for _, event := range events {
+			if !subscriber.filter.Matches(event) {
+				continue
+			}
+			select {
+			case subscriber.events <- event:
+				continue
+			default:
+			}
+			fmt.Printf("Suscripción a eventos cerrada por no consumirlos a tiempo\n")
+			b.remove(subscriber)
+			break
+		}
+	}
+}
+
+// parseLogFilter lee un filtro de eventos de los parámetros de la consulta: contract, topic,
+// topic0 a topic3, from_block y to_block.
+func (s *Server) parseLogFilter(r *http.Request) (LogFilter, error) {
+	query := r.URL.Query()
+	filter := LogFilter{ToBlock: -1}
+	if contract := query.Get("contract"); contract != "" {
+		filter.Contract = s.contractID(contract)
+	}
+
+	topic := func(name string) (string, error) {
+		value := strings.ToLower(query.Get(name))
+		if value == "" {
+			return "", nil
+		}
+		if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != EventTopicSize {
+			return "", fmt.Errorf("%s debe tener %d bytes en hexadecimal", name, EventTopicSize)
+		}
+		return value, nil
+	}
+	var err error
+	if filter.Topic, err = topic("topic"); err != nil {
+		return filter, err
+	}
+	for i := range filter.Topics {
+		if filter.Topics[i], err = topic(fmt.Sprintf("topic%d", i)); err != nil {
+			return filter, err
+		}
+	}
+
+	if value := query.Get("from_block"); value != "" {
+		if filter.FromBlock, err = strconv.Atoi(value); err != nil || filter.FromBlock < 0 {
+			return filter, fmt.Errorf("from_block inválido: %q", value)
+		}
+	}
+	if value := query.Get("to_block"); value != "" {
+		if filter.ToBlock, err = strconv.Atoi(value); err != nil || filter.ToBlock < filter.FromBlock {
+			return filter, fmt.Errorf("to_block inválido: %q", value)
+		}
+	}
+	return filter, nil
+}
+
+// GetLogs maneja la consulta de eventos de contratos por contrato, tema y rango de bloques.
+func (s *Server) GetLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := s.DB.GetLogs(filter, MaxLogResults+1)
+	if err != nil {
+		http.Error(w, "Error obteniendo eventos", http.StatusInternalServerError)
+		return
+	}
+	if len(events) > MaxLogResults {
+		http.Error(w, fmt.Sprintf("El filtro coincide con más de %d eventos; acote el rango de bloques", MaxLogResults), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// StreamLogs envía como Server-Sent Events los eventos que se confirman a partir de la
+// suscripción y cumplen el filtro. Los eventos anteriores se consultan con GET /logs.
+func (s *Server) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := s.parseLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "El servidor no admite respuestas en streaming", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := contractEvents.Subscribe(filter)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-events:
+			if !ok {
+				// La suscripción se cerró por lentitud; el cliente puede reconectarse y
+				// recuperar lo perdido con GET /logs.
+				return
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
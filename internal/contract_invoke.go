@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	"blockchain-go/wallet"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// MaxCallDepth limita cuántas llamadas entre contratos pueden anidarse, contando la llamada
+// inicial.
+const MaxCallDepth = 8
+
+// ReentrancyGuardExport es la exportación con la que un contrato pide no ser llamado mientras
+// ya se está ejecutando. Basta con que exista, sea del tipo que sea.
+const ReentrancyGuardExport = "qubit_nonreentrant"
+
+// Estados que call_contract devuelve al contrato que llama.
+const (
+	callSucceeded = 0
+	callFailed    = 1
+)
+
+var (
+	// ErrCallDepth indica que una llamada entre contratos superó MaxCallDepth.
+	ErrCallDepth = fmt.Errorf("se superó la profundidad máxima de %d llamadas entre contratos", MaxCallDepth)
+	// ErrReentrancy indica que se llamó a un contrato con protección de reentrada mientras ya
+	// se estaba ejecutando.
+	ErrReentrancy = errors.New("el contrato no admite llamadas reentrantes")
+)
+
+// reentrancyGuarded indica si el módulo de un contrato pide protección de reentrada.
+func reentrancyGuarded(module *wasmer.Module) bool {
+	for _, export := range module.Exports() {
+		if export.Name() == ReentrancyGuardExport {
+			return true
+		}
+	}
+	return false
+}
+
+// running indica si el contrato está en la pila de llamadas de la ejecución.
+func (env ExecutionEnv) running(contract string) bool {
+	for _, id := range env.stack {
+		if id == contract {
+			return true
+		}
+	}
+	return false
+}
+
+// remainingGas devuelve el gas que le queda a la instancia en ejecución.
+func (h *hostEnv) remainingGas() (uint64, error) {
+	value, err := h.gas.Get()
+	if err != nil {
+		return 0, fmt.Errorf("error al leer el contador de gas: %w", err)
+	}
+	if left := value.(int64); left > 0 {
+		return uint64(left), nil
+	}
+	return 0, nil
+}
+
+// callContract llama a un método de otro contrato en un marco propio: sus escrituras,
+// transferencias y eventos se guardan en un búfer sobre el estado de quien llama y solo se
+// incorporan a él si la llamada termina bien. Un fallo del contrato llamado no hace fallar a
+// quien llama, que recibe callFailed y puede leer el motivo con call_output; los errores del
+// estado de quien llama sí lo hacen fallar. El gas que consume el contrato llamado, hasta la
+// asignación indicada, se descuenta de quien llama.
+func (h *hostEnv) callContract(args []wasmer.Value) ([]wasmer.Value, error) {
+	ref, err := h.read(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	method, err := h.read(args[2].I32(), args[3].I32())
+	if err != nil {
+		return nil, err
+	}
+	if args[5].I32() > MaxContractIOSize {
+		return nil, fmt.Errorf("la entrada no puede superar %d bytes", MaxContractIOSize)
+	}
+	input, err := h.read(args[4].I32(), args[5].I32())
+	if err != nil {
+		return nil, err
+	}
+	value, gas := args[6].I64(), args[7].I64()
+	if value < 0 {
+		return nil, errors.New("el valor no puede ser negativo")
+	}
+	if value > 0 && h.exec.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if err := h.charge(gasCostContractCall + uint64(len(input))); err != nil {
+		return nil, err
+	}
+
+	// Como en el resto de la API, los contratos de génesis se llaman por su ID.
+	id, err := wallet.ParseAddress(string(ref))
+	if err != nil {
+		id = string(ref)
+	}
+
+	// La asignación no puede superar el gas restante menos 1/64, que se reserva para que
+	// quien llama pueda continuar aunque el contrato llamado agote el suyo; 0 asigna el máximo.
+	remaining, err := h.remainingGas()
+	if err != nil {
+		return nil, err
+	}
+	allowance := remaining - remaining/64
+	if gas > 0 && uint64(gas) < allowance {
+		allowance = uint64(gas)
+	}
+
+	contract, err := h.exec.State.LoadContract(id)
+	if err != nil {
+		return nil, err
+	}
+	frame := newStorageBuffer(h.exec.State)
+	result, callErr := h.invoke(frame, contract, id, string(method), input, value, allowance)
+	if result != nil {
+		if err := h.charge(result.GasUsed); err != nil {
+			return nil, err
+		}
+	}
+	if callErr != nil {
+		h.contract.Log(fmt.Sprintf("Llamada a %s.%s fallida: %s", id, method, callErr))
+		h.returnData = []byte(callErr.Error())
+		return []wasmer.Value{wasmer.NewI32(callFailed)}, nil
+	}
+
+	if _, err := frame.Commit(); err != nil {
+		return nil, err
+	}
+	h.events = append(h.events, result.Events...)
+	h.returnData = result.Output
+	return []wasmer.Value{wasmer.NewI32(callSucceeded)}, nil
+}
+
+// invoke transfiere el valor y ejecuta el método del contrato llamado sobre el marco dado.
+func (h *hostEnv) invoke(frame *storageBuffer, contract *WASMContract, id, method string, input []byte, value int64, gasLimit uint64) (*ExecutionResult, error) {
+	if contract == nil {
+		return nil, fmt.Errorf("el contrato %s no existe", id)
+	}
+	if err := validateContractCall(method, gasLimit); err != nil {
+		return nil, err
+	}
+	if value > 0 {
+		if err := frame.ContractTransfer(h.contract.ID, contract.ID, value, h.exec.Block); err != nil {
+			return nil, err
+		}
+	}
+
+	env := h.exec
+	env.Caller = h.contract.ID
+	env.State = frame
+	return contract.Execute(env, method, input, gasLimit)
+}
+
+// callOutput copia la salida de la última llamada a otro contrato, o el motivo de su fallo.
+func (h *hostEnv) callOutput(args []wasmer.Value) ([]wasmer.Value, error) {
+	if int(args[1].I32()) >= len(h.returnData) {
+		if err := h.write(args[0].I32(), h.returnData); err != nil {
+			return nil, err
+		}
+	}
+	return []wasmer.Value{wasmer.NewI32(int32(len(h.returnData)))}, nil
+}
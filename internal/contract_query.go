@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxQueryGas limita el gas de una consulta. Nadie paga ese gas, así que el límite solo
+// protege al nodo.
+const MaxQueryGas = 10_000_000
+
+// ErrNotDeployed indica que el contrato consultado aún no existía a la altura indicada.
+var ErrNotDeployed = errors.New("el contrato no existía a esa altura")
+
+// ContractQuery son los parámetros de una consulta de solo lectura a un contrato.
+type ContractQuery struct {
+	Method   string `json:"method"`
+	Args     []byte `json:"args,omitempty"`
+	Caller   string `json:"caller,omitempty"`
+	GasLimit uint64 `json:"gas_limit,omitempty"`
+	Height   *int   `json:"height,omitempty"` // Altura de un bloque anterior; nil para el estado actual
+}
+
+// QueryResult es el resultado de una consulta.
+type QueryResult struct {
+	Output      []byte          `json:"output"`
+	GasUsed     uint64          `json:"gas_used"`
+	Events      []ContractEvent `json:"events,omitempty"` // Eventos emitidos, que no se guardan
+	BlockHeight int             `json:"block_height"`     // Bloque tras el cual se leyó el estado
+}
+
+// historicalState lee el almacenamiento de los contratos tal como quedó al aplicar el bloque
+// height, a partir del historial de escrituras. Es de solo lectura.
+type historicalState struct {
+	q      querier
+	height int
+}
+
+// GetStorage obtiene el valor que tenía una clave al final del bloque.
+func (s historicalState) GetStorage(contract string, key []byte) ([]byte, bool, error) {
+	var value []byte
+	var deleted bool
+	err := s.q.QueryRow(
+		`SELECT value, value IS NULL FROM contract_storage_history
+		WHERE contract_id = $1 AND key = $2 AND block_height <= $3
+		ORDER BY block_height DESC LIMIT 1`,
+		contract, key, s.height,
+	).Scan(&value, &deleted)
+	if err == sql.ErrNoRows || (err == nil && deleted) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error leyendo el historial de almacenamiento: %w", err)
+	}
+	return value, true, nil
+}
+
+func (s historicalState) SetStorage(string, []byte, []byte) error { return ErrReadOnly }
+func (s historicalState) DeleteStorage(string, []byte) error      { return ErrReadOnly }
+
+func (s historicalState) ContractTransfer(string, string, int64, BlockContext) error {
+	return ErrReadOnly
+}
+
+// LoadContract obtiene un contrato si ya estaba desplegado a la altura de la consulta. El código
+// de un contrato no cambia, así que se lee el actual.
+func (s historicalState) LoadContract(id string) (*WASMContract, error) {
+	var deployed int
+	err := s.q.QueryRow("SELECT deployed_height FROM wasm_contracts WHERE id = $1", id).Scan(&deployed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error cargando contrato WASM: %w", err)
+	}
+	if deployed > s.height {
+		return nil, fmt.Errorf("%w: %s no existía a la altura %d", ErrNotDeployed, id, s.height)
+	}
+	return sqlState{s.q}.LoadContract(id)
+}
+
+// GetBalance falla: los saldos no tienen historial, y devolver el actual mezclaría estados.
+func (s historicalState) GetBalance(string) (int64, error) {
+	return 0, errors.New("los saldos no están disponibles en consultas a alturas anteriores")
+}
+
+// QueryContract ejecuta un método de un contrato sin modificar el estado, contra el estado
+// confirmado del último bloque o, si se indica una altura, contra el almacenamiento que tenía
+// el contrato tras ese bloque. Toda la consulta lee una misma instantánea de la base de datos.
+func (d *Database) QueryContract(contract *WASMContract, query ContractQuery) (*QueryResult, error) {
+	dbTx, err := d.Connection.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando la consulta: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	height := -1
+	if query.Height != nil {
+		height = *query.Height
+	}
+	var block BlockContext
+	err = dbTx.QueryRow(
+		`SELECT block_index, timestamp FROM blocks WHERE ($1::INTEGER < 0 OR block_index = $1)
+		ORDER BY block_index DESC LIMIT 1`,
+		height,
+	).Scan(&block.Height, &block.Time)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no existe el bloque %d", height)
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo el bloque de la consulta: %w", err)
+	}
+	block.Time = block.Time.In(time.UTC)
+
+	var state ContractState = sqlState{dbTx}
+	if query.Height != nil {
+		state = historicalState{dbTx, block.Height}
+		if _, err := state.LoadContract(contract.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	env := ExecutionEnv{Caller: query.Caller, Block: block, State: state, ReadOnly: true}
+	result, err := contract.Execute(env, query.Method, query.Args, query.GasLimit)
+	if result == nil {
+		return nil, err
+	}
+	return &QueryResult{Output: result.Output, GasUsed: result.GasUsed, Events: result.Events, BlockHeight: block.Height}, err
+}
+
+// QueryContractHandler maneja una consulta de solo lectura a un contrato. No crea una
+// transacción ni modifica el estado; si el contrato intenta escribir, la consulta falla.
+func (s *Server) QueryContractHandler(w http.ResponseWriter, r *http.Request) {
+	var query ContractQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if query.Caller != "" && !s.parseAddresses(w, &query.Caller) {
+		return
+	}
+	if query.Method == "" {
+		query.Method = DefaultContractMethod
+	}
+	if query.GasLimit == 0 {
+		query.GasLimit = DefaultGasLimit
+	}
+	if query.GasLimit > MaxQueryGas {
+		http.Error(w, fmt.Sprintf("El límite de gas de una consulta no puede superar %d", MaxQueryGas), http.StatusBadRequest)
+		return
+	}
+	if err := validateContractCall(query.Method, query.GasLimit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Height != nil && (*query.Height < 0 || *query.Height > s.Blockchain.Height()) {
+		http.Error(w, "Altura fuera de la cadena", http.StatusBadRequest)
+		return
+	}
+
+	contract, err := s.DB.LoadWASMContract(s.contractID(mux.Vars(r)["id"]))
+	if err != nil || contract == nil {
+		http.Error(w, "Contrato WASM no encontrado", http.StatusNotFound)
+		return
+	}
+
+	result, err := s.DB.QueryContract(contract, query)
+	if errors.Is(err, ErrNotDeployed) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Is(err, ErrOutOfGas):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "gas_used": result.GasUsed})
+	case errors.Is(err, ErrReadOnly):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "gas_used": result.GasUsed})
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Error ejecutando la consulta: %s", err), http.StatusInternalServerError)
+	default:
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,344 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// storageSlot identifica una clave del almacenamiento de un contrato.
+type storageSlot struct {
+	contract string
+	key      string
+}
+
+// storageWrite es una escritura pendiente: un valor nuevo o un borrado.
+type storageWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// pendingTransfer es una transferencia de un contrato que aún no llegó al estado subyacente.
+type pendingTransfer struct {
+	from, to string
+	amount   int64
+	ctx      BlockContext
+}
+
+// storageBuffer acumula en memoria las escrituras de almacenamiento y las transferencias de los
+// contratos durante una ejecución. Las lecturas y los saldos ven primero los cambios pendientes,
+// que solo llegan al estado subyacente con Commit, de modo que una llamada fallida no deja
+// cambios a medias. Los búferes se anidan: cada llamada entre contratos usa uno propio sobre el
+// de quien llama.
+type storageBuffer struct {
+	ContractState
+	writes    map[storageSlot]storageWrite
+	transfers []pendingTransfer
+	balances  map[string]int64 // Variación pendiente del saldo de cada cuenta
+}
+
+// newStorageBuffer crea un búfer de escrituras sobre el estado dado.
+func newStorageBuffer(base ContractState) *storageBuffer {
+	return &storageBuffer{
+		ContractState: base,
+		writes:        make(map[storageSlot]storageWrite),
+		balances:      make(map[string]int64),
+	}
+}
+
+// GetStorage obtiene el valor de una clave, considerando las escrituras pendientes.
+func (b *storageBuffer) GetStorage(contract string, key []byte) ([]byte, bool, error) {
+	if write, ok := b.writes[storageSlot{contract, string(key)}]; ok {
+		return write.value, !write.deleted, nil
+	}
+	return b.ContractState.GetStorage(contract, key)
+}
+
+// SetStorage registra la escritura de una clave.
+func (b *storageBuffer) SetStorage(contract string, key, value []byte) error {
+	b.writes[storageSlot{contract, string(key)}] = storageWrite{value: append([]byte{}, value...)}
+	return nil
+}
+
+// DeleteStorage registra el borrado de una clave.
+func (b *storageBuffer) DeleteStorage(contract string, key []byte) error {
+	b.writes[storageSlot{contract, string(key)}] = storageWrite{deleted: true}
+	return nil
+}
+
+// GetBalance obtiene el saldo de una cuenta, considerando las transferencias pendientes.
+func (b *storageBuffer) GetBalance(account string) (int64, error) {
+	balance, err := b.ContractState.GetBalance(account)
+	if err != nil {
+		return 0, err
+	}
+	return balance + b.balances[account], nil
+}
+
+// ContractTransfer registra una transferencia desde la cuenta de un contrato, si su saldo, con
+// los cambios pendientes, alcanza.
+func (b *storageBuffer) ContractTransfer(contract, to string, amount int64, ctx BlockContext) error {
+	balance, err := b.GetBalance(contract)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return fmt.Errorf("saldo insuficiente en la cuenta %s", contract)
+	}
+	b.transfers = append(b.transfers, pendingTransfer{from: contract, to: to, amount: amount, ctx: ctx})
+	b.balances[contract] -= amount
+	b.balances[to] += amount
+	return nil
+}
+
+// pending devuelve las claves con escrituras pendientes, en orden de contrato y clave.
+func (b *storageBuffer) pending() []storageSlot {
+	slots := make([]storageSlot, 0, len(b.writes))
+	for slot := range b.writes {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].contract != slots[j].contract {
+			return slots[i].contract < slots[j].contract
+		}
+		return slots[i].key < slots[j].key
+	})
+	return slots
+}
+
+// Commit aplica al estado subyacente las transferencias pendientes, en el orden en que se
+// hicieron, y las escrituras, en orden de contrato y clave, y devuelve los contratos cuyo
+// almacenamiento cambió.
+func (b *storageBuffer) Commit() ([]string, error) {
+	for _, transfer := range b.transfers {
+		if err := b.ContractState.ContractTransfer(transfer.from, transfer.to, transfer.amount, transfer.ctx); err != nil {
+			return nil, err
+		}
+	}
+	b.transfers = nil
+	b.balances = make(map[string]int64)
+
+	var contracts []string
+	for _, slot := range b.pending() {
+		write := b.writes[slot]
+		var err error
+		if write.deleted {
+			err = b.ContractState.DeleteStorage(slot.contract, []byte(slot.key))
+		} else {
+			err = b.ContractState.SetStorage(slot.contract, []byte(slot.key), write.value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(contracts) == 0 || contracts[len(contracts)-1] != slot.contract {
+			contracts = append(contracts, slot.contract)
+		}
+	}
+	b.writes = make(map[storageSlot]storageWrite)
+	return contracts, nil
+}
+
+// commitStorage aplica las escrituras del búfer, las registra en el historial del bloque dado y
+// recalcula la raíz de almacenamiento de cada contrato modificado.
+func (s sqlState) commitStorage(buffer *storageBuffer, height int) error {
+	for _, slot := range buffer.pending() {
+		write := buffer.writes[slot]
+		_, err := s.q.Exec(
+			`INSERT INTO contract_storage_history (contract_id, key, block_height, value) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (contract_id, key, block_height) DO UPDATE SET value = EXCLUDED.value`,
+			slot.contract, []byte(slot.key), height, write.value,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando el historial de almacenamiento: %w", err)
+		}
+	}
+
+	contracts, err := buffer.Commit()
+	if err != nil {
+		return err
+	}
+	for _, contract := range contracts {
+		root, _, err := s.storageProof(contract, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := s.q.Exec("UPDATE wasm_contracts SET storage_root = $2 WHERE id = $1", contract, root); err != nil {
+			return fmt.Errorf("error guardando la raíz de almacenamiento de %s: %w", contract, err)
+		}
+	}
+	return nil
+}
+
+// storageProof calcula la raíz Merkle del almacenamiento de un contrato, con las claves en
+// orden de bytes, y la prueba de inclusión de key si se indica y existe.
+func (s sqlState) storageProof(contract string, key []byte) (string, []MerkleStep, error) {
+	rows, err := s.q.Query("SELECT key, value FROM contract_storage WHERE contract_id = $1 ORDER BY key", contract)
+	if err != nil {
+		return "", nil, fmt.Errorf("error leyendo almacenamiento del contrato: %w", err)
+	}
+	defer rows.Close()
+
+	var leaves [][]byte
+	index := -1
+	for rows.Next() {
+		var k, v []byte
+		if err := rows.Scan(&k, &v); err != nil {
+			return "", nil, fmt.Errorf("error leyendo almacenamiento del contrato: %w", err)
+		}
+		if key != nil && string(k) == string(key) {
+			index = len(leaves)
+		}
+		leaves = append(leaves, merkleLeaf(k, v))
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("error leyendo almacenamiento del contrato: %w", err)
+	}
+
+	root, proof := merkleProof(leaves, index)
+	return root, proof, nil
+}
+
+// contractsProof calcula la raíz Merkle de las raíces de almacenamiento de todos los contratos,
+// con hojas (ID del contrato, raíz) en orden de ID, y la prueba de inclusión de contract si se
+// indica.
+func (s sqlState) contractsProof(contract string) (string, []MerkleStep, error) {
+	rows, err := s.q.Query("SELECT id, storage_root FROM wasm_contracts ORDER BY id")
+	if err != nil {
+		return "", nil, fmt.Errorf("error leyendo las raíces de almacenamiento: %w", err)
+	}
+	defer rows.Close()
+
+	var leaves [][]byte
+	index := -1
+	for rows.Next() {
+		var id, root string
+		if err := rows.Scan(&id, &root); err != nil {
+			return "", nil, fmt.Errorf("error leyendo las raíces de almacenamiento: %w", err)
+		}
+		rootBytes, err := hex.DecodeString(root)
+		if err != nil {
+			return "", nil, fmt.Errorf("raíz de almacenamiento inválida para %s: %w", id, err)
+		}
+		if id == contract {
+			index = len(leaves)
+		}
+		leaves = append(leaves, merkleLeaf([]byte(id), rootBytes))
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("error leyendo las raíces de almacenamiento: %w", err)
+	}
+
+	root, proof := merkleProof(leaves, index)
+	return root, proof, nil
+}
+
+// StorageRoot calcula la raíz que compromete el almacenamiento de todos los contratos.
+func (d *Database) StorageRoot() (string, error) {
+	root, _, err := sqlState{d.Connection}.contractsProof("")
+	return root, err
+}
+
+// ErrStaleStorageProof indica que el almacenamiento actual no coincide con la raíz guardada en
+// el último bloque aplicado, normalmente porque se está aplicando un bloque nuevo.
+var ErrStaleStorageProof = errors.New("el almacenamiento no coincide con la raíz del último bloque aplicado")
+
+// StorageProof enlaza un valor del almacenamiento con la raíz del contrato y, a través de ella,
+// con la raíz de todos los contratos que se guarda en cada bloque.
+type StorageProof struct {
+	ContractRoot  string       `json:"contract_root"`
+	Proof         []MerkleStep `json:"proof"`
+	StorageRoot   string       `json:"storage_root"`
+	ContractProof []MerkleStep `json:"contract_proof"`
+}
+
+// StorageValue es la respuesta de una lectura de almacenamiento.
+type StorageValue struct {
+	Contract    string        `json:"contract"`
+	Key         []byte        `json:"key"`
+	Value       []byte        `json:"value"`
+	BlockHeight int           `json:"block_height"`
+	Proof       *StorageProof `json:"proof,omitempty"`
+}
+
+// ReadStorage lee una clave del almacenamiento de un contrato, con sus pruebas si se piden, en
+// una instantánea consistente de la base de datos. Devuelve nil si la clave no existe, y
+// ErrStaleStorageProof si la prueba no enlaza con la raíz guardada en el último bloque aplicado.
+func (d *Database) ReadStorage(contract string, key []byte, withProof bool) (*StorageValue, error) {
+	dbTx, err := d.Connection.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando la lectura: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	state := sqlState{dbTx}
+	value, found, err := state.GetStorage(contract, key)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	// La altura es la del último bloque aplicado, el último con raíz de almacenamiento guardada.
+	result := &StorageValue{Contract: contract, Key: key, Value: value}
+	var blockRoot string
+	err = dbTx.QueryRow(
+		"SELECT block_index, storage_root FROM blocks WHERE storage_root <> '' ORDER BY block_index DESC LIMIT 1",
+	).Scan(&result.BlockHeight, &blockRoot)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo la altura: %w", err)
+	}
+
+	if withProof {
+		var proof StorageProof
+		if proof.ContractRoot, proof.Proof, err = state.storageProof(contract, key); err != nil {
+			return nil, err
+		}
+		if proof.StorageRoot, proof.ContractProof, err = state.contractsProof(contract); err != nil {
+			return nil, err
+		}
+		// Una prueba solo sirve si enlaza con la raíz que el bloque tiene guardada.
+		if proof.StorageRoot != blockRoot {
+			return nil, fmt.Errorf("%w (bloque %d)", ErrStaleStorageProof, result.BlockHeight)
+		}
+		result.Proof = &proof
+	}
+	return result, nil
+}
+
+// GetContractStorage maneja la lectura de una clave del almacenamiento de un contrato. Con
+// proof=true la respuesta incluye las pruebas de inclusión.
+func (s *Server) GetContractStorage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	contract := s.contractID(vars["id"])
+	exists, err := s.DB.ContractExists(contract)
+	if err != nil {
+		http.Error(w, "Error obteniendo el contrato", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Contrato WASM no encontrado", http.StatusNotFound)
+		return
+	}
+
+	value, err := s.DB.ReadStorage(contract, []byte(vars["key"]), r.URL.Query().Get("proof") == "true")
+	if errors.Is(err, ErrStaleStorageProof) {
+		http.Error(w, "Se está aplicando un bloque; vuelva a pedir la prueba", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error leyendo el almacenamiento", http.StatusInternalServerError)
+		return
+	}
+	if value == nil {
+		http.Error(w, "Clave no encontrada", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
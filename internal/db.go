@@ -6,17 +6,35 @@ import (
 	"fmt"
 	"time"
 
+	"blockchain-go/wallet"
+
 	_ "github.com/lib/pq" // Driver de PostgreSQL
 )
 
 type Database struct {
 	Connection *sql.DB
+	Staking    StakingParams // Parámetros de staking usados al aplicar bloques
+
+	AllowUnsigned bool // Acepta transferencias sin firma de cuentas que nunca firmaron (heredado)
+
+	AddressPrefix         string // Prefijo de red de las direcciones codificadas
+	AcceptLegacyAddresses bool   // Acepta direcciones hexadecimales sin checksum (migración)
 }
 
 type Transaction struct {
-	From   string
-	To     string
-	Amount int64
+	From    string
+	To      string
+	Amount  int64
+	Type    string          `json:",omitempty"` // Vacío para transferencias simples
+	Payload json.RawMessage `json:",omitempty"` // Datos específicos del tipo de transacción
+
+	// Autenticación del emisor; las transacciones heredadas no incluyen firma.
+	Nonce     uint64 `json:",omitempty"`
+	PublicKey string `json:",omitempty"`
+	Signature string `json:",omitempty"`
+
+	// Firmas de los titulares de una cuenta multifirma (en lugar de PublicKey y Signature).
+	Signatures []wallet.Signature `json:",omitempty"`
 }
 
 // InitDB inicializa la base de datos PostgreSQL y crea las tablas necesarias.
@@ -52,11 +70,187 @@ func InitDB(connectionString string) (*Database, error) {
 			to_account TEXT NOT NULL,
 			amount BIGINT NOT NULL
 		);`,
+		`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS metadata_ref TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS wasm_contracts JSONB NOT NULL DEFAULT '[]';`,
+		`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS storage_root TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS tx_type TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS payload JSONB;`,
+		// Las firmas cubren los bytes exactos del payload, que JSONB normaliza.
+		`ALTER TABLE pending_transactions ALTER COLUMN payload TYPE TEXT;`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS nonce BIGINT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS public_key TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS signature TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE pending_transactions ADD COLUMN IF NOT EXISTS signatures TEXT;`,
+		`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tx_hash TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tx_type TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE balances ADD COLUMN IF NOT EXISTS nonce BIGINT NOT NULL DEFAULT 0;`,
 		`CREATE TABLE IF NOT EXISTS wasm_contracts (
 			id TEXT PRIMARY KEY,
 			owner TEXT NOT NULL,
 			wasm_code BYTEA NOT NULL
 		);`,
+		`ALTER TABLE wasm_contracts ADD COLUMN IF NOT EXISTS storage_root TEXT NOT NULL DEFAULT '` + EmptyMerkleRoot + `';`,
+		// Los contratos del génesis tienen altura 0; los desplegados antes de esta columna toman
+		// la del bloque que los incluye.
+		`ALTER TABLE wasm_contracts ADD COLUMN IF NOT EXISTS deployed_height INTEGER NOT NULL DEFAULT 0;`,
+		`UPDATE wasm_contracts c SET deployed_height = b.block_index FROM blocks b
+			WHERE c.deployed_height = 0 AND b.block_index > 0
+			AND b.wasm_contracts @> jsonb_build_array(jsonb_build_object('ID', c.id));`,
+		`CREATE TABLE IF NOT EXISTS contract_storage (
+			contract_id TEXT NOT NULL,
+			key BYTEA NOT NULL,
+			value BYTEA NOT NULL,
+			PRIMARY KEY (contract_id, key)
+		);`,
+		`CREATE TABLE IF NOT EXISTS contract_storage_history (
+			contract_id TEXT NOT NULL,
+			key BYTEA NOT NULL,
+			block_height INTEGER NOT NULL,
+			value BYTEA,
+			PRIMARY KEY (contract_id, key, block_height)
+		);`,
+		// El historial empieza con esta tabla: las claves anteriores se registran en la altura 0.
+		`INSERT INTO contract_storage_history (contract_id, key, block_height, value)
+			SELECT contract_id, key, 0, value FROM contract_storage s
+			WHERE NOT EXISTS (SELECT 1 FROM contract_storage_history h WHERE h.contract_id = s.contract_id AND h.key = s.key);`,
+		`CREATE TABLE IF NOT EXISTS validators (
+			address TEXT PRIMARY KEY,
+			public_key TEXT NOT NULL,
+			jailed BOOLEAN NOT NULL DEFAULT FALSE
+		);`,
+		`CREATE TABLE IF NOT EXISTS stakes (
+			delegator TEXT NOT NULL,
+			validator TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			PRIMARY KEY (delegator, validator)
+		);`,
+		`CREATE TABLE IF NOT EXISTS unbondings (
+			id SERIAL PRIMARY KEY,
+			delegator TEXT NOT NULL,
+			validator TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			release_height INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS validator_set (
+			epoch INTEGER NOT NULL,
+			validator TEXT NOT NULL,
+			power BIGINT NOT NULL,
+			PRIMARY KEY (epoch, validator)
+		);`,
+		`CREATE TABLE IF NOT EXISTS slashings (
+			validator TEXT NOT NULL,
+			height INTEGER NOT NULL,
+			amount BIGINT NOT NULL,
+			reporter TEXT NOT NULL,
+			PRIMARY KEY (validator, height)
+		);`,
+		`CREATE TABLE IF NOT EXISTS checkpoints (
+			height INTEGER PRIMARY KEY,
+			hash TEXT NOT NULL,
+			source TEXT NOT NULL,
+			votes JSONB NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS locked_funds (
+			id SERIAL PRIMARY KEY,
+			tx_hash TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			beneficiary TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			unlock_height INTEGER NOT NULL DEFAULT 0,
+			unlock_time BIGINT NOT NULL DEFAULT 0,
+			locked_height INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS htlcs (
+			id TEXT PRIMARY KEY,
+			sender TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			hashlock TEXT NOT NULL,
+			expiry_height INTEGER NOT NULL DEFAULT 0,
+			expiry_time BIGINT NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			preimage TEXT NOT NULL DEFAULT '',
+			created_height INTEGER NOT NULL,
+			settled_height INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE TABLE IF NOT EXISTS escrows (
+			id TEXT PRIMARY KEY,
+			buyer TEXT NOT NULL,
+			seller TEXT NOT NULL,
+			arbiter TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			expiry_height INTEGER NOT NULL DEFAULT 0,
+			expiry_time BIGINT NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			seller_amount BIGINT NOT NULL DEFAULT 0,
+			created_height INTEGER NOT NULL,
+			settled_height INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE TABLE IF NOT EXISTS payment_channels (
+			id TEXT PRIMARY KEY,
+			payer TEXT NOT NULL,
+			payee TEXT NOT NULL,
+			payer_key TEXT NOT NULL,
+			deposit BIGINT NOT NULL,
+			challenge_period INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			amount BIGINT NOT NULL DEFAULT 0,
+			closes_at INTEGER NOT NULL DEFAULT 0,
+			created_height INTEGER NOT NULL,
+			settled_height INTEGER NOT NULL DEFAULT 0
+		);`,
+		`CREATE TABLE IF NOT EXISTS contract_receipts (
+			tx_hash TEXT PRIMARY KEY,
+			contract_id TEXT NOT NULL,
+			caller TEXT NOT NULL,
+			method TEXT NOT NULL,
+			value BIGINT NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			output BYTEA,
+			error TEXT NOT NULL DEFAULT '',
+			gas_used BIGINT NOT NULL,
+			logs JSONB NOT NULL DEFAULT '[]',
+			block_height INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS contract_events (
+			id BIGSERIAL PRIMARY KEY,
+			tx_hash TEXT NOT NULL,
+			log_index INTEGER NOT NULL,
+			contract_id TEXT NOT NULL,
+			topic0 TEXT,
+			topic1 TEXT,
+			topic2 TEXT,
+			topic3 TEXT,
+			data BYTEA NOT NULL,
+			block_height INTEGER NOT NULL,
+			UNIQUE (tx_hash, log_index)
+		);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_contract ON contract_events (contract_id, block_height);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_block ON contract_events (block_height);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_topic0 ON contract_events (topic0, block_height);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_topic1 ON contract_events (topic1, block_height);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_topic2 ON contract_events (topic2, block_height);`,
+		`CREATE INDEX IF NOT EXISTS contract_events_topic3 ON contract_events (topic3, block_height);`,
+		`CREATE TABLE IF NOT EXISTS account_keys (
+			address TEXT PRIMARY KEY,
+			key_type TEXT NOT NULL,
+			public_key TEXT NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS multisig_accounts (
+			address TEXT PRIMARY KEY,
+			threshold INTEGER NOT NULL,
+			public_keys JSONB NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS multisig_proposals (
+			id SERIAL PRIMARY KEY,
+			account TEXT NOT NULL,
+			to_account TEXT NOT NULL,
+			amount BIGINT NOT NULL,
+			nonce BIGINT NOT NULL,
+			signatures JSONB NOT NULL DEFAULT '[]',
+			status TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
 	}
 
 	for _, query := range queries {
@@ -65,7 +259,12 @@ func InitDB(connectionString string) (*Database, error) {
 		}
 	}
 
-	return &Database{Connection: db}, nil
+	return &Database{
+		Connection:            db,
+		Staking:               DefaultStakingParams(),
+		AddressPrefix:         wallet.DefaultAddressPrefix,
+		AcceptLegacyAddresses: true,
+	}, nil
 }
 
 // SaveBlock guarda un bloque en la base de datos.
@@ -74,10 +273,14 @@ func (d *Database) SaveBlock(block Block) error {
 	if err != nil {
 		return fmt.Errorf("error serializando transacciones: %w", err)
 	}
+	contracts, err := marshalBlockContracts(block)
+	if err != nil {
+		return err
+	}
 
 	_, err = d.Connection.Exec(
-		"INSERT INTO blocks (block_index, timestamp, transactions, hash, prev_hash) VALUES ($1, $2, $3, $4, $5)",
-		block.Index, block.Timestamp, string(blockData), block.Hash, block.PrevHash,
+		"INSERT INTO blocks (block_index, timestamp, transactions, hash, prev_hash, metadata_ref, wasm_contracts, storage_root) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		block.Index, block.Timestamp, string(blockData), block.Hash, block.PrevHash, block.MetadataRef, contracts, block.StorageRoot,
 	)
 	if err != nil {
 		return fmt.Errorf("error guardando bloque en la base de datos: %w", err)
@@ -87,9 +290,23 @@ func (d *Database) SaveBlock(block Block) error {
 	return nil
 }
 
+// marshalBlockContracts serializa los contratos de un bloque; un bloque sin contratos se
+// guarda como una lista vacía.
+func marshalBlockContracts(block Block) (string, error) {
+	contracts := block.WASMContracts
+	if contracts == nil {
+		contracts = []WASMContract{}
+	}
+	data, err := json.Marshal(contracts)
+	if err != nil {
+		return "", fmt.Errorf("error serializando contratos WASM: %w", err)
+	}
+	return string(data), nil
+}
+
 // LoadBlocks carga todos los bloques desde la base de datos.
 func (d *Database) LoadBlocks() ([]Block, error) {
-	rows, err := d.Connection.Query("SELECT block_index, timestamp, transactions, hash, prev_hash FROM blocks ORDER BY id ASC")
+	rows, err := d.Connection.Query("SELECT block_index, timestamp, transactions, hash, prev_hash, metadata_ref, wasm_contracts, storage_root FROM blocks ORDER BY id ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -98,9 +315,9 @@ func (d *Database) LoadBlocks() ([]Block, error) {
 	var blocks []Block
 	for rows.Next() {
 		var block Block
-		var transactionsJSON string
+		var transactionsJSON, contractsJSON string
 
-		if err := rows.Scan(&block.Index, &block.Timestamp, &transactionsJSON, &block.Hash, &block.PrevHash); err != nil {
+		if err := rows.Scan(&block.Index, &block.Timestamp, &transactionsJSON, &block.Hash, &block.PrevHash, &block.MetadataRef, &contractsJSON, &block.StorageRoot); err != nil {
 			return nil, err
 		}
 
@@ -108,6 +325,9 @@ func (d *Database) LoadBlocks() ([]Block, error) {
 		if err := json.Unmarshal([]byte(transactionsJSON), &block.Transactions); err != nil {
 			return nil, fmt.Errorf("error deserializando transacciones: %w", err)
 		}
+		if err := json.Unmarshal([]byte(contractsJSON), &block.WASMContracts); err != nil {
+			return nil, fmt.Errorf("error deserializando contratos WASM: %w", err)
+		}
 
 		blocks = append(blocks, block)
 	}
@@ -209,19 +429,37 @@ func (d *Database) LoadTransactions() ([]Transaction, error) {
 
 // AddPendingTransaction agrega una transacción pendiente.
 func (d *Database) AddPendingTransaction(from, to string, amount int64) error {
-	query := `INSERT INTO pending_transactions (from_account, to_account, amount) VALUES ($1, $2, $3)`
-	_, err := d.Connection.Exec(query, from, to, amount)
+	return d.AddPendingTx(Transaction{From: from, To: to, Amount: amount})
+}
+
+// AddPendingTx agrega una transacción pendiente de cualquier tipo.
+func (d *Database) AddPendingTx(tx Transaction) error {
+	var payload, signatures interface{}
+	if len(tx.Payload) > 0 {
+		payload = string(tx.Payload)
+	}
+	if len(tx.Signatures) > 0 {
+		data, err := json.Marshal(tx.Signatures)
+		if err != nil {
+			return fmt.Errorf("error serializando firmas: %w", err)
+		}
+		signatures = string(data)
+	}
+
+	query := `INSERT INTO pending_transactions (from_account, to_account, amount, tx_type, payload, nonce, public_key, signature, signatures)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := d.Connection.Exec(query, tx.From, tx.To, tx.Amount, tx.Type, payload, tx.Nonce, tx.PublicKey, tx.Signature, signatures)
 	if err != nil {
 		return fmt.Errorf("error añadiendo transacción pendiente: %w", err)
 	}
 
-	fmt.Printf("Transacción pendiente añadida: de %s a %s por %d\n", from, to, amount)
+	fmt.Printf("Transacción pendiente añadida: de %s a %s por %d\n", tx.From, tx.To, tx.Amount)
 	return nil
 }
 
 // GetPendingTransactions carga todas las transacciones pendientes.
 func (d *Database) GetPendingTransactions() ([]Transaction, error) {
-	query := `SELECT from_account, to_account, amount FROM pending_transactions`
+	query := `SELECT from_account, to_account, amount, tx_type, payload, nonce, public_key, signature, signatures FROM pending_transactions ORDER BY id ASC`
 	rows, err := d.Connection.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("error al obtener transacciones pendientes: %w", err)
@@ -231,9 +469,18 @@ func (d *Database) GetPendingTransactions() ([]Transaction, error) {
 	var transactions []Transaction
 	for rows.Next() {
 		var t Transaction
-		if err := rows.Scan(&t.From, &t.To, &t.Amount); err != nil {
+		var payload, signatures sql.NullString
+		if err := rows.Scan(&t.From, &t.To, &t.Amount, &t.Type, &payload, &t.Nonce, &t.PublicKey, &t.Signature, &signatures); err != nil {
 			return nil, fmt.Errorf("error al escanear transacción pendiente: %w", err)
 		}
+		if payload.Valid {
+			t.Payload = json.RawMessage(payload.String)
+		}
+		if signatures.Valid {
+			if err := json.Unmarshal([]byte(signatures.String), &t.Signatures); err != nil {
+				return nil, fmt.Errorf("error al decodificar firmas de transacción pendiente: %w", err)
+			}
+		}
 		transactions = append(transactions, t)
 	}
 
@@ -304,19 +551,17 @@ func (d *Database) SaveWASMContract(contract WASMContract) error {
 	return nil
 }
 
-// LoadWASMContract carga un contrato WASM desde la base de datos por su ID.
-func (d *Database) LoadWASMContract(id string) (*WASMContract, error) {
-	var contract WASMContract
-
-	err := d.Connection.QueryRow(
-		"SELECT id, owner, wasm_code FROM wasm_contracts WHERE id = $1",
-		id,
-	).Scan(&contract.ID, &contract.Owner, &contract.WASMCode)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, fmt.Errorf("error cargando contrato WASM: %w", err)
+// ContractExists indica si hay un contrato WASM desplegado con el ID dado.
+func (d *Database) ContractExists(id string) (bool, error) {
+	var exists bool
+	err := d.Connection.QueryRow("SELECT EXISTS (SELECT 1 FROM wasm_contracts WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error verificando el contrato WASM: %w", err)
 	}
+	return exists, nil
+}
 
-	return &contract, nil
+// LoadWASMContract carga un contrato WASM desde la base de datos por su ID.
+func (d *Database) LoadWASMContract(id string) (*WASMContract, error) {
+	return sqlState{d.Connection}.LoadContract(id)
 }
@@ -0,0 +1,411 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Estados de un depósito en garantía.
+const (
+	EscrowOpen      = "open"
+	EscrowReleased  = "released"  // El comprador aprobó: los fondos van al vendedor
+	EscrowCancelled = "cancelled" // El vendedor canceló: los fondos vuelven al comprador
+	EscrowResolved  = "resolved"  // El árbitro repartió los fondos
+	EscrowExpired   = "expired"   // Venció sin resolverse: los fondos vuelven al comprador
+)
+
+// escrowExpiryType identifica en el historial las devoluciones por vencimiento, que no
+// provienen de ninguna transacción.
+const escrowExpiryType = "escrow_expire"
+
+// EscrowOpenPayload nombra al árbitro y el vencimiento de un depósito. El vendedor es el
+// destinatario de la transacción.
+type EscrowOpenPayload struct {
+	Arbiter string        `json:"arbiter"`
+	Expiry  LockCondition `json:"expiry"`
+}
+
+// EscrowSettlePayload identifica el depósito que se aprueba, cancela o resuelve. En una
+// resolución, SellerAmount es la parte que el árbitro asigna al vendedor; el resto vuelve al comprador.
+type EscrowSettlePayload struct {
+	ID           string `json:"id"`
+	SellerAmount int64  `json:"seller_amount,omitempty"`
+}
+
+// Escrow es un depósito en garantía. Su identificador es el hash de la transacción que lo abrió.
+type Escrow struct {
+	ID            string `json:"id"`
+	Buyer         string `json:"buyer"`
+	Seller        string `json:"seller"`
+	Arbiter       string `json:"arbiter"`
+	Amount        int64  `json:"amount"`
+	ExpiryHeight  int    `json:"expiry_height,omitempty"`
+	ExpiryTime    int64  `json:"expiry_time,omitempty"`
+	Status        string `json:"status"`
+	SellerAmount  int64  `json:"seller_amount"`
+	CreatedHeight int    `json:"created_height"`
+	SettledHeight int    `json:"settled_height,omitempty"`
+}
+
+// Expiry devuelve la condición de vencimiento del depósito.
+func (e Escrow) Expiry() LockCondition {
+	return LockCondition{UnlockHeight: e.ExpiryHeight, UnlockTime: e.ExpiryTime}
+}
+
+// validate comprueba que comprador, vendedor y árbitro sean distintos y que el vencimiento sea futuro.
+func (p EscrowOpenPayload) validate(buyer, seller string, ctx BlockContext) error {
+	if p.Arbiter == "" {
+		return errors.New("falta el árbitro")
+	}
+	if buyer == seller || p.Arbiter == buyer || p.Arbiter == seller {
+		return errors.New("comprador, vendedor y árbitro deben ser cuentas distintas")
+	}
+	if err := p.Expiry.Validate(ctx); err != nil {
+		return fmt.Errorf("vencimiento inválido: %w", err)
+	}
+	return nil
+}
+
+// settlement determina el estado final y la parte del vendedor que produce una transacción
+// de aprobación, cancelación o resolución enviada por la cuenta from.
+func (e Escrow) settlement(txType, from string, payload EscrowSettlePayload, ctx BlockContext) (string, int64, error) {
+	if e.Status != EscrowOpen {
+		return "", 0, fmt.Errorf("el depósito %s ya fue %s", e.ID, e.Status)
+	}
+	if e.Expiry().Unlocked(ctx) {
+		return "", 0, fmt.Errorf("el depósito %s venció", e.ID)
+	}
+
+	switch txType {
+	case TxTypeEscrowApprove:
+		if from != e.Buyer {
+			return "", 0, errors.New("solo el comprador puede aprobar el depósito")
+		}
+		return EscrowReleased, e.Amount, nil
+	case TxTypeEscrowCancel:
+		if from != e.Seller {
+			return "", 0, errors.New("solo el vendedor puede cancelar el depósito")
+		}
+		return EscrowCancelled, 0, nil
+	case TxTypeEscrowResolve:
+		if from != e.Arbiter {
+			return "", 0, errors.New("solo el árbitro puede resolver el depósito")
+		}
+		if payload.SellerAmount < 0 || payload.SellerAmount > e.Amount {
+			return "", 0, fmt.Errorf("la parte del vendedor debe estar entre 0 y %d", e.Amount)
+		}
+		return EscrowResolved, payload.SellerAmount, nil
+	default:
+		return "", 0, fmt.Errorf("tipo de transacción desconocido: %s", txType)
+	}
+}
+
+// applyEscrowOpen debita al comprador y retiene los fondos hasta que el depósito se resuelva o venza.
+func (d *Database) applyEscrowOpen(tx Transaction, ctx BlockContext) error {
+	id, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+
+	var payload EscrowOpenPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos del depósito inválidos: %w", err)
+	}
+	if err := payload.validate(tx.From, tx.To, ctx); err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			`INSERT INTO escrows (id, buyer, seller, arbiter, amount, expiry_height, expiry_time, status, created_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			id, tx.From, tx.To, payload.Arbiter, tx.Amount,
+			payload.Expiry.UnlockHeight, payload.Expiry.UnlockTime, EscrowOpen, ctx.Height,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando depósito: %w", err)
+		}
+
+		fmt.Printf("Depósito %s abierto: %d de %s para %s\n", id, tx.Amount, tx.From, tx.To)
+		return nil
+	})
+}
+
+// applyEscrowSettlement aplica la aprobación, cancelación o resolución de un depósito abierto.
+func (d *Database) applyEscrowSettlement(tx Transaction, ctx BlockContext) error {
+	var payload EscrowSettlePayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos del depósito inválidos: %w", err)
+	}
+	// Cualquiera podría enviar sin firma la aprobación de un comprador que nunca firmó, así que
+	// toda decisión sobre un depósito debe estar firmada.
+	if !tx.IsSigned() {
+		return errors.New("la aprobación, cancelación o resolución de un depósito debe estar firmada")
+	}
+
+	escrow, err := d.GetEscrow(payload.ID)
+	if err != nil {
+		return err
+	}
+	if escrow == nil {
+		return fmt.Errorf("el depósito %s no existe", payload.ID)
+	}
+
+	status, sellerAmount, err := escrow.settlement(tx.Type, tx.From, payload, ctx)
+	if err != nil {
+		return err
+	}
+	return d.settleEscrow(escrow, status, sellerAmount, tx.Type, ctx)
+}
+
+// settleEscrow cierra un depósito abierto y reparte sus fondos entre vendedor y comprador.
+func (d *Database) settleEscrow(escrow *Escrow, status string, sellerAmount int64, txType string, ctx BlockContext) error {
+	return d.atomically(func(state sqlState) error {
+		result, err := state.q.Exec(
+			"UPDATE escrows SET status = $2, seller_amount = $3, settled_height = $4 WHERE id = $1 AND status = $5",
+			escrow.ID, status, sellerAmount, ctx.Height, EscrowOpen,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando depósito: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("el depósito %s ya no está abierto", escrow.ID)
+		}
+
+		shares := []struct {
+			account string
+			amount  int64
+		}{
+			{escrow.Seller, sellerAmount},
+			{escrow.Buyer, escrow.Amount - sellerAmount},
+		}
+		for _, share := range shares {
+			if share.amount == 0 {
+				continue
+			}
+			if err := state.credit(share.account, share.amount); err != nil {
+				return err
+			}
+			err := state.recordHistory(HistoryEntry{
+				From:      escrow.Buyer,
+				To:        share.account,
+				Amount:    share.amount,
+				Type:      txType,
+				TxHash:    escrow.ID,
+				Timestamp: ctx.Time,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Depósito %s %s: %d para el vendedor, %d para el comprador\n",
+			escrow.ID, status, sellerAmount, escrow.Amount-sellerAmount)
+		return nil
+	})
+}
+
+// expireEscrows devuelve al comprador los depósitos abiertos cuyo vencimiento se cumple en el bloque.
+func (d *Database) expireEscrows(ctx BlockContext) error {
+	rows, err := d.Connection.Query(
+		selectEscrowQuery+` WHERE status = $1
+		AND ((expiry_height > 0 AND expiry_height <= $2) OR (expiry_time > 0 AND expiry_time <= $3))`,
+		EscrowOpen, ctx.Height, ctx.Time.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("error obteniendo depósitos vencidos: %w", err)
+	}
+
+	var expired []*Escrow
+	for rows.Next() {
+		escrow, err := scanEscrow(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error al escanear depósito: %w", err)
+		}
+		expired = append(expired, escrow)
+	}
+	rows.Close()
+
+	for _, escrow := range expired {
+		if err := d.settleEscrow(escrow, EscrowExpired, 0, escrowExpiryType, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const selectEscrowQuery = `SELECT id, buyer, seller, arbiter, amount, expiry_height, expiry_time, status,
+	seller_amount, created_height, settled_height FROM escrows`
+
+// scanEscrow lee un depósito de una fila de selectEscrowQuery.
+func scanEscrow(row interface{ Scan(...interface{}) error }) (*Escrow, error) {
+	var e Escrow
+	err := row.Scan(&e.ID, &e.Buyer, &e.Seller, &e.Arbiter, &e.Amount, &e.ExpiryHeight, &e.ExpiryTime,
+		&e.Status, &e.SellerAmount, &e.CreatedHeight, &e.SettledHeight)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetEscrow obtiene un depósito por su identificador, o nil si no existe.
+func (d *Database) GetEscrow(id string) (*Escrow, error) {
+	escrow, err := scanEscrow(d.Connection.QueryRow(selectEscrowQuery+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo depósito: %w", err)
+	}
+	return escrow, nil
+}
+
+// GetEscrows obtiene los depósitos con el estado dado en los que una cuenta es comprador,
+// vendedor o árbitro (todos si es vacía).
+func (d *Database) GetEscrows(account, status string) ([]Escrow, error) {
+	rows, err := d.Connection.Query(
+		selectEscrowQuery+` WHERE status = $1
+		AND ($2 = '' OR buyer = $2 OR seller = $2 OR arbiter = $2) ORDER BY created_height ASC`,
+		status, account,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo depósitos: %w", err)
+	}
+	defer rows.Close()
+
+	escrows := []Escrow{}
+	for rows.Next() {
+		escrow, err := scanEscrow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error al escanear depósito: %w", err)
+		}
+		escrows = append(escrows, *escrow)
+	}
+	return escrows, nil
+}
+
+// EscrowOpenHandler maneja la apertura de un depósito en garantía. La transacción debe estar
+// firmada; su hash, devuelto como tx_hash, identifica el depósito.
+func (s *Server) EscrowOpenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		EscrowOpenPayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From, &payload.To, &payload.Arbiter) {
+		return
+	}
+	if payload.Signature == "" {
+		http.Error(w, "Los depósitos en garantía requieren una transacción firmada", http.StatusBadRequest)
+		return
+	}
+	if err := payload.EscrowOpenPayload.validate(payload.From, payload.To, s.nextBlock()); err != nil {
+		http.Error(w, fmt.Sprintf("Depósito inválido: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	data, _ := json.Marshal(payload.EscrowOpenPayload)
+	s.submitTransaction(w, Transaction{
+		From:      payload.From,
+		To:        payload.To,
+		Amount:    payload.Amount,
+		Type:      TxTypeEscrowOpen,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// EscrowApproveHandler maneja la aprobación del comprador, que libera los fondos al vendedor.
+func (s *Server) EscrowApproveHandler(w http.ResponseWriter, r *http.Request) {
+	s.escrowSettleHandler(w, r, TxTypeEscrowApprove)
+}
+
+// EscrowCancelHandler maneja la cancelación del vendedor, que devuelve los fondos al comprador.
+func (s *Server) EscrowCancelHandler(w http.ResponseWriter, r *http.Request) {
+	s.escrowSettleHandler(w, r, TxTypeEscrowCancel)
+}
+
+// EscrowResolveHandler maneja la decisión firmada del árbitro sobre el reparto de los fondos.
+func (s *Server) EscrowResolveHandler(w http.ResponseWriter, r *http.Request) {
+	s.escrowSettleHandler(w, r, TxTypeEscrowResolve)
+}
+
+// escrowSettleHandler valida una aprobación, cancelación o resolución contra el estado actual y la encola.
+func (s *Server) escrowSettleHandler(w http.ResponseWriter, r *http.Request, txType string) {
+	var payload struct {
+		From string `json:"from"`
+		EscrowSettlePayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From) {
+		return
+	}
+	if payload.Signature == "" {
+		http.Error(w, "La aprobación, cancelación o resolución de un depósito debe estar firmada", http.StatusBadRequest)
+		return
+	}
+
+	escrow, err := s.DB.GetEscrow(payload.ID)
+	if err != nil {
+		http.Error(w, "Error obteniendo el depósito", http.StatusInternalServerError)
+		return
+	}
+	if escrow == nil {
+		http.Error(w, "El depósito no existe", http.StatusNotFound)
+		return
+	}
+	if _, _, err := escrow.settlement(txType, payload.From, payload.EscrowSettlePayload, s.nextBlock()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.queueSettlement(w, payload.From, txType, payload.EscrowSettlePayload, payload.TxAuth)
+}
+
+// GetEscrow maneja la consulta de un depósito por su identificador.
+func (s *Server) GetEscrow(w http.ResponseWriter, r *http.Request) {
+	escrow, err := s.DB.GetEscrow(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Error obteniendo el depósito", http.StatusInternalServerError)
+		return
+	}
+	if escrow == nil {
+		http.Error(w, "El depósito no existe", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(escrow)
+}
+
+// GetEscrows maneja la consulta de depósitos por cuenta y estado (por defecto, los abiertos).
+func (s *Server) GetEscrows(w http.ResponseWriter, r *http.Request) {
+	s.listByAccount(w, r, EscrowOpen, "depósitos", func(account, status string) (interface{}, error) {
+		return s.DB.GetEscrows(account, status)
+	})
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"blockchain-go/wallet"
+)
+
+// FaucetConfig contiene la configuración del grifo de tokens de prueba.
+type FaucetConfig struct {
+	Enabled         bool          `yaml:"enabled"`          // Desactivar en redes de producción
+	PrivateKeyFile  string        `yaml:"private_key_file"` // Archivo con la clave privada (hex) de la cuenta que financia el grifo
+	KeyType         string        `yaml:"key_type"`         // Esquema de la clave: p256 (por defecto) o ed25519
+	Amount          int64         `yaml:"amount"`           // Tokens entregados por solicitud
+	AddressCooldown time.Duration `yaml:"address_cooldown"` // Espera mínima entre entregas a una misma dirección
+	IPCooldown      time.Duration `yaml:"ip_cooldown"`      // Espera mínima entre solicitudes de una misma IP
+	DailyCap        int64         `yaml:"daily_cap"`        // Tokens máximos entregados por día (UTC)
+}
+
+// Faucet entrega tokens desde una cuenta financiada mediante transacciones firmadas.
+type Faucet struct {
+	config  FaucetConfig
+	key     wallet.Key
+	Address string
+
+	mu        sync.Mutex
+	byAddress map[string]time.Time
+	byIP      map[string]time.Time
+	day       string
+	dispensed int64
+}
+
+// FaucetKeyEnv es la variable de entorno con la clave privada (hex) del grifo. Tiene prioridad
+// sobre private_key_file; la clave nunca se lee de configs/config.yaml.
+const FaucetKeyEnv = "QUBIT_FAUCET_KEY"
+
+// Errores de limitación del grifo.
+var (
+	ErrFaucetDisabled  = errors.New("el grifo está desactivado en esta red")
+	ErrFaucetRateLimit = errors.New("límite de solicitudes al grifo alcanzado, intente más tarde")
+	ErrFaucetDailyCap  = errors.New("el grifo alcanzó su límite diario")
+)
+
+// NewFaucet crea un grifo a partir de su configuración.
+func NewFaucet(config FaucetConfig) (*Faucet, error) {
+	faucet := &Faucet{
+		config:    config,
+		byAddress: make(map[string]time.Time),
+		byIP:      make(map[string]time.Time),
+	}
+	if !config.Enabled {
+		return faucet, nil
+	}
+
+	if config.Amount <= 0 {
+		return nil, errors.New("el monto del grifo debe ser mayor que cero")
+	}
+
+	keyType, err := wallet.ParseKeyType(config.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := config.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := wallet.ParseKey(keyType, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("clave privada del grifo inválida: %w", err)
+	}
+	faucet.key = key
+	faucet.Address = key.Address()
+	return faucet, nil
+}
+
+// privateKey obtiene la clave privada del grifo de FaucetKeyEnv o, si no está definida, de
+// private_key_file.
+func (c FaucetConfig) privateKey() (string, error) {
+	if key := os.Getenv(FaucetKeyEnv); key != "" {
+		return strings.TrimSpace(key), nil
+	}
+	if c.PrivateKeyFile == "" {
+		return "", fmt.Errorf("falta la clave del grifo: defina %s o faucet.private_key_file", FaucetKeyEnv)
+	}
+	data, err := os.ReadFile(c.PrivateKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("error leyendo la clave del grifo: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Dispense encola una transferencia firmada desde la cuenta del grifo hacia la dirección dada.
+func (f *Faucet) Dispense(db *Database, address, ip string, now time.Time) (Transaction, error) {
+	if !f.config.Enabled {
+		return Transaction{}, ErrFaucetDisabled
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if last, ok := f.byAddress[address]; ok && now.Sub(last) < f.config.AddressCooldown {
+		return Transaction{}, ErrFaucetRateLimit
+	}
+	if last, ok := f.byIP[ip]; ok && now.Sub(last) < f.config.IPCooldown {
+		return Transaction{}, ErrFaucetRateLimit
+	}
+
+	day := now.UTC().Format("2006-01-02")
+	if day != f.day {
+		f.day = day
+		f.dispensed = 0
+	}
+	if f.config.DailyCap > 0 && f.dispensed+f.config.Amount > f.config.DailyCap {
+		return Transaction{}, ErrFaucetDailyCap
+	}
+
+	balance, err := db.GetBalance(f.Address)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if balance < f.config.Amount {
+		return Transaction{}, errors.New("el grifo no tiene fondos suficientes")
+	}
+
+	// Las entregas aún no minadas ya reservaron nonces consecutivos.
+	nonce, err := db.GetPendingNonce(f.Address)
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	tx := Transaction{
+		From:   f.Address,
+		To:     address,
+		Amount: f.config.Amount,
+		Nonce:  nonce + 1,
+	}
+	if err := SignTransaction(&tx, f.key); err != nil {
+		return Transaction{}, err
+	}
+	if err := db.AddPendingTx(tx); err != nil {
+		return Transaction{}, err
+	}
+
+	f.byAddress[address] = now
+	f.byIP[ip] = now
+	f.dispensed += f.config.Amount
+	return tx, nil
+}
+
+// FaucetHandler maneja la solicitud de fondos de prueba para una dirección.
+func (s *Server) FaucetHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Faucet == nil {
+		http.Error(w, ErrFaucetDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Address == "" {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.Address) {
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	tx, err := s.Faucet.Dispense(s.DB, payload.Address, ip, time.Now())
+	switch {
+	case errors.Is(err, ErrFaucetDisabled):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrFaucetRateLimit), errors.Is(err, ErrFaucetDailyCap):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Error entregando fondos: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Fondos en camino, se acreditarán en el próximo bloque",
+		"tx_hash": tx.Hash(),
+		"amount":  tx.Amount,
+	})
+}
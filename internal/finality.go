@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Orígenes posibles de un punto de control.
+const (
+	CheckpointSourceConsensus = "consensus" // Firmado por más de 2/3 del poder de los validadores
+	CheckpointSourceConfig    = "config"    // Configurado manualmente como confiable
+)
+
+// Checkpoint marca un bloque finalizado que nunca puede revertirse.
+type Checkpoint struct {
+	Height int              `json:"height" yaml:"height"`
+	Hash   string           `json:"hash" yaml:"hash"`
+	Source string           `json:"source" yaml:"-"`
+	Votes  []CheckpointVote `json:"votes,omitempty" yaml:"-"`
+}
+
+// CheckpointVote es el voto firmado de un validador a favor de un punto de control.
+type CheckpointVote struct {
+	Validator string `json:"validator"`
+	BlockVote
+}
+
+// ErrBelowCheckpoint indica un intento de reorganizar la cadena por debajo del último punto de control.
+var ErrBelowCheckpoint = errors.New("la reorganización revertiría bloques finalizados")
+
+// ErrCheckpointConflict indica un punto de control con un hash distinto del ya guardado a esa altura.
+var ErrCheckpointConflict = errors.New("ya existe un punto de control distinto a esa altura")
+
+// ReplaceChain reemplaza la cadena local por otra más larga y válida, siempre que no
+// revierta bloques a la altura del punto de control o por debajo.
+func (bc *Blockchain) ReplaceChain(blocks []*Block, checkpoint *Checkpoint) error {
+	if len(blocks) <= len(bc.Blocks) {
+		return errors.New("la cadena propuesta no es más larga que la actual")
+	}
+
+	candidate := &Blockchain{Blocks: blocks}
+	if !candidate.IsValid() {
+		return errors.New("la cadena propuesta no es válida")
+	}
+
+	if checkpoint != nil {
+		if err := candidate.VerifyCheckpoints([]Checkpoint{*checkpoint}); err != nil {
+			return fmt.Errorf("%w: %s", ErrBelowCheckpoint, err)
+		}
+
+		// Buscar el punto de bifurcación entre ambas cadenas.
+		fork := 0
+		for fork < len(bc.Blocks) && bc.Blocks[fork].Hash == blocks[fork].Hash {
+			fork++
+		}
+		if fork <= checkpoint.Height && fork < len(bc.Blocks) {
+			return fmt.Errorf("%w: bifurcación en el bloque %d, finalizado hasta %d", ErrBelowCheckpoint, fork, checkpoint.Height)
+		}
+	}
+
+	bc.Blocks = blocks
+	return nil
+}
+
+// VerifyCheckpoints comprueba que la cadena coincide con los puntos de control dados.
+// Los puntos de control por encima de la altura actual se ignoran.
+func (bc *Blockchain) VerifyCheckpoints(checkpoints []Checkpoint) error {
+	for _, cp := range checkpoints {
+		if cp.Height < 0 || cp.Height >= len(bc.Blocks) {
+			continue
+		}
+		if bc.Blocks[cp.Height].Hash != cp.Hash {
+			return fmt.Errorf("el bloque %d tiene hash %s, se esperaba %s", cp.Height, bc.Blocks[cp.Height].Hash, cp.Hash)
+		}
+	}
+	return nil
+}
+
+// SaveCheckpoint guarda un punto de control en la base de datos. Volver a guardar el mismo
+// punto de control no tiene efecto; uno con otro hash a la misma altura devuelve
+// ErrCheckpointConflict.
+func (d *Database) SaveCheckpoint(cp Checkpoint) error {
+	votes, err := json.Marshal(cp.Votes)
+	if err != nil {
+		return fmt.Errorf("error serializando votos: %w", err)
+	}
+
+	result, err := d.Connection.Exec(
+		"INSERT INTO checkpoints (height, hash, source, votes) VALUES ($1, $2, $3, $4) ON CONFLICT (height) DO NOTHING",
+		cp.Height, cp.Hash, cp.Source, string(votes),
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando punto de control: %w", err)
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("error guardando punto de control: %w", err)
+	} else if inserted == 0 {
+		var hash string
+		err := d.Connection.QueryRow("SELECT hash FROM checkpoints WHERE height = $1", cp.Height).Scan(&hash)
+		if err != nil {
+			return fmt.Errorf("error obteniendo punto de control: %w", err)
+		}
+		if hash != cp.Hash {
+			return fmt.Errorf("%w: el bloque %d está finalizado con hash %s, no %s", ErrCheckpointConflict, cp.Height, hash, cp.Hash)
+		}
+		return nil
+	}
+
+	fmt.Printf("Punto de control guardado en el bloque %d (%s)\n", cp.Height, cp.Source)
+	return nil
+}
+
+// LatestCheckpoint obtiene el punto de control de mayor altura, o nil si no hay ninguno.
+func (d *Database) LatestCheckpoint() (*Checkpoint, error) {
+	var cp Checkpoint
+	var votes string
+	err := d.Connection.QueryRow(
+		"SELECT height, hash, source, votes FROM checkpoints ORDER BY height DESC LIMIT 1",
+	).Scan(&cp.Height, &cp.Hash, &cp.Source, &votes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo punto de control: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(votes), &cp.Votes); err != nil {
+		return nil, fmt.Errorf("error deserializando votos: %w", err)
+	}
+	return &cp, nil
+}
+
+// LoadCheckpoints carga todos los puntos de control ordenados por altura.
+func (d *Database) LoadCheckpoints() ([]Checkpoint, error) {
+	rows, err := d.Connection.Query("SELECT height, hash, source FROM checkpoints ORDER BY height ASC")
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo puntos de control: %w", err)
+	}
+	defer rows.Close()
+
+	checkpoints := []Checkpoint{}
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.Height, &cp.Hash, &cp.Source); err != nil {
+			return nil, fmt.Errorf("error al escanear punto de control: %w", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, nil
+}
+
+// ImportTrustedCheckpoints guarda los puntos de control confiables de la configuración. Falla
+// si alguno contradice un punto de control ya guardado.
+func (d *Database) ImportTrustedCheckpoints(checkpoints []Checkpoint) error {
+	for _, cp := range checkpoints {
+		cp.Source = CheckpointSourceConfig
+		if err := d.SaveCheckpoint(cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyCheckpointVotes comprueba que los votos provienen de validadores del conjunto actual
+// que suman más de 2/3 del poder total.
+func (d *Database) VerifyCheckpointVotes(cp Checkpoint) error {
+	_, validators, err := d.GetLatestValidatorSet()
+	if err != nil {
+		return err
+	}
+	if len(validators) == 0 {
+		return errors.New("no hay un conjunto de validadores activo")
+	}
+
+	power := make(map[string]int64, len(validators))
+	var total int64
+	for _, v := range validators {
+		power[v.Address] = v.Power
+		total += v.Power
+	}
+
+	var signed int64
+	seen := make(map[string]bool)
+	for _, vote := range cp.Votes {
+		if seen[vote.Validator] || power[vote.Validator] == 0 {
+			continue
+		}
+		if vote.Height != cp.Height || vote.BlockHash != cp.Hash {
+			continue
+		}
+
+		publicKey, err := d.GetValidatorPublicKey(vote.Validator)
+		if err != nil {
+			return err
+		}
+		if !vote.Verify(publicKey) {
+			return fmt.Errorf("firma inválida del validador %s", vote.Validator)
+		}
+
+		seen[vote.Validator] = true
+		signed += power[vote.Validator]
+	}
+
+	if signed*3 <= total*2 {
+		return fmt.Errorf("los votos suman %d de %d de poder, se requieren más de 2/3", signed, total)
+	}
+	return nil
+}
+
+// GetFinality maneja la solicitud para consultar el estado de finalidad de la cadena.
+func (s *Server) GetFinality(w http.ResponseWriter, r *http.Request) {
+	latest, err := s.DB.LatestCheckpoint()
+	if err != nil {
+		http.Error(w, "Error obteniendo el punto de control", http.StatusInternalServerError)
+		return
+	}
+
+	checkpoints, err := s.DB.LoadCheckpoints()
+	if err != nil {
+		http.Error(w, "Error obteniendo los puntos de control", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"latest_height":    len(s.Blockchain.Blocks) - 1,
+		"finalized_height": -1,
+		"checkpoints":      checkpoints,
+	}
+	if latest != nil {
+		response["finalized_height"] = latest.Height
+		response["finalized_hash"] = latest.Hash
+		response["source"] = latest.Source
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// AddCheckpoint maneja la solicitud para finalizar un bloque con votos de los validadores.
+func (s *Server) AddCheckpoint(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Height int              `json:"height"`
+		Votes  []CheckpointVote `json:"votes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Height < 0 || payload.Height >= len(s.Blockchain.Blocks) {
+		http.Error(w, "El bloque no existe", http.StatusBadRequest)
+		return
+	}
+
+	latest, err := s.DB.LatestCheckpoint()
+	if err != nil {
+		http.Error(w, "Error obteniendo el punto de control", http.StatusInternalServerError)
+		return
+	}
+	if latest != nil && payload.Height <= latest.Height {
+		http.Error(w, "El bloque ya está finalizado", http.StatusConflict)
+		return
+	}
+
+	cp := Checkpoint{
+		Height: payload.Height,
+		Hash:   s.Blockchain.Blocks[payload.Height].Hash,
+		Source: CheckpointSourceConsensus,
+		Votes:  payload.Votes,
+	}
+	if err := s.DB.VerifyCheckpointVotes(cp); err != nil {
+		http.Error(w, fmt.Sprintf("Votos insuficientes: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DB.SaveCheckpoint(cp); errors.Is(err, ErrCheckpointConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Error guardando el punto de control", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Bloque finalizado",
+		"height":  cp.Height,
+		"hash":    cp.Hash,
+	})
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"blockchain-go/wallet"
+)
+
+// Genesis describe el estado inicial de la cadena y sus parámetros.
+type Genesis struct {
+	ChainID       string            `json:"chain_id"`
+	Timestamp     string            `json:"timestamp"`
+	InitialSupply int64             `json:"initial_supply"` // Suministro inicial de TokenSupply, igual a la suma de Alloc
+	Alloc         map[string]int64  `json:"alloc"`          // Saldos iniciales por cuenta, en forma canónica tras Validate
+	Consensus     ConsensusParams   `json:"consensus"`
+	Contracts     []GenesisContract `json:"contracts"`
+	AddressPrefix string            `json:"address_prefix,omitempty"` // Prefijo de red de las direcciones (por defecto "qbt")
+}
+
+// ConsensusParams agrupa los parámetros de consenso fijados en el génesis.
+type ConsensusParams struct {
+	Staking StakingParams `json:"staking"`
+}
+
+// GenesisContract es un contrato WASM desplegado desde el bloque génesis.
+type GenesisContract struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner"`
+	WASMCode []byte `json:"wasm_code"` // Codificado en base64 en el archivo
+}
+
+// LoadGenesis carga y valida un archivo de génesis en formato JSON.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo el archivo de génesis: %w", err)
+	}
+
+	var genesis Genesis
+	if err := json.Unmarshal(data, &genesis); err != nil {
+		return nil, fmt.Errorf("error interpretando el archivo de génesis: %w", err)
+	}
+
+	if err := genesis.Validate(); err != nil {
+		return nil, fmt.Errorf("génesis inválido: %w", err)
+	}
+	return &genesis, nil
+}
+
+// Validate verifica la coherencia del génesis.
+func (g *Genesis) Validate() error {
+	if g.ChainID == "" {
+		return errors.New("chain_id no puede estar vacío")
+	}
+	if _, err := time.Parse(time.RFC3339, g.Timestamp); err != nil {
+		return fmt.Errorf("timestamp debe estar en formato RFC3339: %w", err)
+	}
+
+	// Las cuentas de alloc pueden escribirse en cualquier formato; se guardan en su forma
+	// canónica para que dos formas de la misma cuenta no creen saldos separados.
+	prefix := g.AddressPrefix
+	if prefix == "" {
+		prefix = wallet.DefaultAddressPrefix
+	}
+	alloc := make(map[string]int64, len(g.Alloc))
+	var allocated int64
+	for account, amount := range g.Alloc {
+		canonical, err := parseAddress(account, prefix, true)
+		if err != nil {
+			return fmt.Errorf("cuenta inválida en alloc %q: %w", account, err)
+		}
+		if _, ok := alloc[canonical]; ok {
+			return fmt.Errorf("la cuenta %s aparece más de una vez en alloc", canonical)
+		}
+		if amount < 0 {
+			return fmt.Errorf("saldo inicial negativo para %s", account)
+		}
+		alloc[canonical] = amount
+		allocated += amount
+	}
+	g.Alloc = alloc
+	if allocated != g.InitialSupply {
+		return fmt.Errorf("la suma de saldos iniciales (%d) no coincide con initial_supply (%d)", allocated, g.InitialSupply)
+	}
+
+	staking := g.Consensus.Staking
+	if staking.EpochLength <= 0 || staking.UnbondingPeriod < 0 || staking.MaxValidators <= 0 {
+		return errors.New("parámetros de staking inválidos")
+	}
+
+	ids := make(map[string]bool)
+	for _, contract := range g.Contracts {
+		if contract.ID == "" || len(contract.WASMCode) == 0 {
+			return errors.New("los contratos del génesis requieren id y código")
+		}
+		if ids[contract.ID] {
+			return fmt.Errorf("contrato duplicado en el génesis: %s", contract.ID)
+		}
+		ids[contract.ID] = true
+	}
+	return nil
+}
+
+// Hash calcula el hash del génesis a partir de su contenido canónico.
+func (g *Genesis) Hash() string {
+	// json.Marshal ordena las claves de los mapas, por lo que la serialización es determinista.
+	data, _ := json.Marshal(g)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Block construye el bloque génesis derivado del archivo.
+func (g *Genesis) Block() *Block {
+	contracts := make([]WASMContract, 0, len(g.Contracts))
+	for _, c := range g.Contracts {
+		contracts = append(contracts, WASMContract{ID: c.ID, Owner: c.Owner, WASMCode: c.WASMCode})
+	}
+
+	block := &Block{
+		Index:         0,
+		Timestamp:     g.Timestamp,
+		Transactions:  []Transaction{},
+		PrevHash:      "",
+		MetadataRef:   g.Hash(),
+		WASMContracts: contracts,
+	}
+	block.Hash = block.CalculateHash()
+	return block
+}
+
+// TokenSupply crea el suministro de tokens inicial descrito por el génesis.
+func (g *Genesis) TokenSupply() *TokenSupply {
+	balances := make(map[string]int64, len(g.Alloc))
+	for account, amount := range g.Alloc {
+		balances[account] = amount
+	}
+	return &TokenSupply{
+		TotalSupply: g.InitialSupply,
+		Balance:     balances,
+	}
+}
+
+// Apply escribe en la base de datos el estado inicial del génesis.
+func (g *Genesis) Apply(db *Database) error {
+	for account, amount := range g.Alloc {
+		if err := db.SaveBalance(account, amount); err != nil {
+			return fmt.Errorf("error asignando saldo inicial: %w", err)
+		}
+	}
+
+	for _, c := range g.Contracts {
+		if err := db.SaveWASMContract(WASMContract{ID: c.ID, Owner: c.Owner, WASMCode: c.WASMCode}); err != nil {
+			return fmt.Errorf("error desplegando contrato del génesis: %w", err)
+		}
+	}
+	return nil
+}
+
+// NewBlockchainFromGenesis crea una blockchain cuyo primer bloque deriva del génesis.
+func NewBlockchainFromGenesis(g *Genesis) *Blockchain {
+	return &Blockchain{
+		Blocks: []*Block{g.Block()},
+	}
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"blockchain-go/wallet"
+)
+
+func testGenesis(alloc map[string]int64) *Genesis {
+	var supply int64
+	for _, amount := range alloc {
+		supply += amount
+	}
+	return &Genesis{
+		ChainID:       "qubit-test",
+		Timestamp:     "2024-12-01T00:00:00Z",
+		InitialSupply: supply,
+		Alloc:         alloc,
+		Consensus:     ConsensusParams{Staking: DefaultStakingParams()},
+	}
+}
+
+// TestGenesisNormalizesAlloc comprueba que las cuentas de alloc se guarden en forma canónica y
+// que dos formas de la misma cuenta se rechacen.
+func TestGenesisNormalizesAlloc(t *testing.T) {
+	key, err := wallet.NewKey(wallet.KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := key.Address()
+	encoded, err := wallet.EncodeAddress(wallet.DefaultAddressPrefix, key.Type().AddressVersion(), legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := encoded[:len(encoded)-1] + "q"
+	if corrupted == encoded {
+		corrupted = encoded[:len(encoded)-1] + "p"
+	}
+	otherNetwork, err := wallet.EncodeAddress("tqbt", key.Type().AddressVersion(), legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := testGenesis(map[string]int64{encoded: 100})
+	if err := genesis.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if genesis.Alloc[legacy] != 100 || len(genesis.Alloc) != 1 {
+		t.Errorf("alloc normalizado: %v", genesis.Alloc)
+	}
+
+	tests := []struct {
+		name  string
+		alloc map[string]int64
+	}{
+		{"misma cuenta en dos formatos", map[string]int64{encoded: 100, legacy: 50}},
+		{"hexadecimal en mayúsculas repetido", map[string]int64{legacy: 100, strings.ToUpper(legacy): 50}},
+		{"checksum incorrecto", map[string]int64{corrupted: 100}},
+		{"prefijo de otra red", map[string]int64{otherNetwork: 100}},
+		{"texto libre", map[string]int64{"alice": 100}},
+	}
+	for _, tt := range tests {
+		if err := testGenesis(tt.alloc).Validate(); err == nil {
+			t.Errorf("%s: el génesis se validó", tt.name)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Estados de un contrato de bloqueo por hash y tiempo.
+const (
+	HTLCOpen     = "open"
+	HTLCClaimed  = "claimed"
+	HTLCRefunded = "refunded"
+)
+
+// maxPreimageSize limita el tamaño de la preimagen revelada al reclamar.
+const maxPreimageSize = 64
+
+// HTLCLockPayload contiene las condiciones de un HTLC: el hash SHA-256 de la preimagen que
+// permite reclamarlo y el vencimiento a partir del cual el emisor puede recuperar los fondos.
+type HTLCLockPayload struct {
+	Hashlock string        `json:"hashlock"`
+	Expiry   LockCondition `json:"expiry"`
+}
+
+// HTLCSettlePayload identifica el HTLC que se reclama (con su preimagen) o se reembolsa.
+type HTLCSettlePayload struct {
+	ID       string `json:"id"`
+	Preimage string `json:"preimage,omitempty"` // Hexadecimal; solo al reclamar
+}
+
+// HTLC es un contrato de bloqueo por hash y tiempo. Su identificador es el hash de la
+// transacción que lo creó.
+type HTLC struct {
+	ID            string `json:"id"`
+	Sender        string `json:"sender"`
+	Recipient     string `json:"recipient"`
+	Amount        int64  `json:"amount"`
+	Hashlock      string `json:"hashlock"`
+	ExpiryHeight  int    `json:"expiry_height,omitempty"`
+	ExpiryTime    int64  `json:"expiry_time,omitempty"`
+	Status        string `json:"status"`
+	Preimage      string `json:"preimage,omitempty"` // Pública tras el reclamo, para completar el intercambio
+	CreatedHeight int    `json:"created_height"`
+	SettledHeight int    `json:"settled_height,omitempty"`
+}
+
+// Expiry devuelve la condición de vencimiento del HTLC.
+func (h HTLC) Expiry() LockCondition {
+	return LockCondition{UnlockHeight: h.ExpiryHeight, UnlockTime: h.ExpiryTime}
+}
+
+// checkPreimage comprueba que la preimagen en hexadecimal corresponda al hashlock.
+func checkPreimage(hashlock, preimage string) error {
+	data, err := hex.DecodeString(preimage)
+	if err != nil || len(data) == 0 || len(data) > maxPreimageSize {
+		return fmt.Errorf("la preimagen debe tener entre 1 y %d bytes en hexadecimal", maxPreimageSize)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hashlock {
+		return errors.New("la preimagen no corresponde al hashlock")
+	}
+	return nil
+}
+
+// validate comprueba el formato del hashlock y que el vencimiento sea futuro.
+func (p HTLCLockPayload) validate(ctx BlockContext) error {
+	hash, err := hex.DecodeString(p.Hashlock)
+	if err != nil || len(hash) != sha256.Size {
+		return errors.New("el hashlock debe ser un hash SHA-256 en hexadecimal")
+	}
+	if err := p.Expiry.Validate(ctx); err != nil {
+		return fmt.Errorf("vencimiento inválido: %w", err)
+	}
+	return nil
+}
+
+// applyHTLCLock debita al emisor y retiene los fondos bajo el hashlock hasta el reclamo o el reembolso.
+func (d *Database) applyHTLCLock(tx Transaction, ctx BlockContext) error {
+	id, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+
+	var payload HTLCLockPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos de HTLC inválidos: %w", err)
+	}
+	payload.Hashlock = strings.ToLower(payload.Hashlock)
+	if err := payload.validate(ctx); err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			`INSERT INTO htlcs (id, sender, recipient, amount, hashlock, expiry_height, expiry_time, status, created_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			id, tx.From, tx.To, tx.Amount, payload.Hashlock,
+			payload.Expiry.UnlockHeight, payload.Expiry.UnlockTime, HTLCOpen, ctx.Height,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando HTLC: %w", err)
+		}
+
+		fmt.Printf("HTLC %s creado: %d de %s para %s\n", id, tx.Amount, tx.From, tx.To)
+		return nil
+	})
+}
+
+// applyHTLCClaim acredita los fondos al destinatario que revela la preimagen antes del vencimiento.
+func (d *Database) applyHTLCClaim(tx Transaction, ctx BlockContext) error {
+	htlc, payload, err := d.loadSettlement(tx)
+	if err != nil {
+		return err
+	}
+	if tx.From != htlc.Recipient {
+		return errors.New("solo el destinatario puede reclamar el HTLC")
+	}
+	if htlc.Expiry().Unlocked(ctx) {
+		return errors.New("el HTLC venció, solo puede reembolsarse")
+	}
+	if err := checkPreimage(htlc.Hashlock, payload.Preimage); err != nil {
+		return err
+	}
+
+	return d.settleHTLC(htlc, HTLCClaimed, htlc.Recipient, strings.ToLower(payload.Preimage), tx, ctx)
+}
+
+// applyHTLCRefund devuelve los fondos al emisor una vez vencido el HTLC.
+func (d *Database) applyHTLCRefund(tx Transaction, ctx BlockContext) error {
+	htlc, _, err := d.loadSettlement(tx)
+	if err != nil {
+		return err
+	}
+	if tx.From != htlc.Sender {
+		return errors.New("solo el emisor puede reembolsar el HTLC")
+	}
+	if !htlc.Expiry().Unlocked(ctx) {
+		return errors.New("el HTLC todavía no venció")
+	}
+
+	return d.settleHTLC(htlc, HTLCRefunded, htlc.Sender, "", tx, ctx)
+}
+
+// loadSettlement carga el HTLC abierto al que se refiere una transacción de reclamo o reembolso.
+func (d *Database) loadSettlement(tx Transaction) (*HTLC, HTLCSettlePayload, error) {
+	var payload HTLCSettlePayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return nil, payload, fmt.Errorf("datos de HTLC inválidos: %w", err)
+	}
+
+	htlc, err := d.GetHTLC(payload.ID)
+	if err != nil {
+		return nil, payload, err
+	}
+	if htlc == nil {
+		return nil, payload, fmt.Errorf("el HTLC %s no existe", payload.ID)
+	}
+	if htlc.Status != HTLCOpen {
+		return nil, payload, fmt.Errorf("el HTLC %s ya fue %s", htlc.ID, htlc.Status)
+	}
+	return htlc, payload, nil
+}
+
+// settleHTLC cierra un HTLC abierto y acredita sus fondos a la cuenta indicada.
+func (d *Database) settleHTLC(htlc *HTLC, status, beneficiary, preimage string, tx Transaction, ctx BlockContext) error {
+	return d.atomically(func(state sqlState) error {
+		result, err := state.q.Exec(
+			"UPDATE htlcs SET status = $2, preimage = $3, settled_height = $4 WHERE id = $1 AND status = $5",
+			htlc.ID, status, preimage, ctx.Height, HTLCOpen,
+		)
+		if err != nil {
+			return fmt.Errorf("error actualizando HTLC: %w", err)
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return fmt.Errorf("el HTLC %s ya no está abierto", htlc.ID)
+		}
+
+		if err := state.credit(beneficiary, htlc.Amount); err != nil {
+			return err
+		}
+
+		fmt.Printf("HTLC %s %s: %d para %s\n", htlc.ID, status, htlc.Amount, beneficiary)
+		return state.recordHistory(HistoryEntry{
+			From:      htlc.Sender,
+			To:        beneficiary,
+			Amount:    htlc.Amount,
+			Type:      tx.Type,
+			TxHash:    htlc.ID,
+			Timestamp: ctx.Time,
+		})
+	})
+}
+
+const selectHTLCQuery = `SELECT id, sender, recipient, amount, hashlock, expiry_height, expiry_time, status,
+	preimage, created_height, settled_height FROM htlcs`
+
+// scanHTLC lee un HTLC de una fila de selectHTLCQuery.
+func scanHTLC(row interface{ Scan(...interface{}) error }) (*HTLC, error) {
+	var h HTLC
+	err := row.Scan(&h.ID, &h.Sender, &h.Recipient, &h.Amount, &h.Hashlock, &h.ExpiryHeight, &h.ExpiryTime,
+		&h.Status, &h.Preimage, &h.CreatedHeight, &h.SettledHeight)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// GetHTLC obtiene un HTLC por su identificador, o nil si no existe.
+func (d *Database) GetHTLC(id string) (*HTLC, error) {
+	htlc, err := scanHTLC(d.Connection.QueryRow(selectHTLCQuery+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo HTLC: %w", err)
+	}
+	return htlc, nil
+}
+
+// GetHTLCs obtiene los HTLC con el estado dado en los que participa una cuenta (todas si es vacía).
+func (d *Database) GetHTLCs(account, status string) ([]HTLC, error) {
+	rows, err := d.Connection.Query(
+		selectHTLCQuery+` WHERE status = $1 AND ($2 = '' OR sender = $2 OR recipient = $2) ORDER BY created_height ASC`,
+		status, account,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo HTLCs: %w", err)
+	}
+	defer rows.Close()
+
+	htlcs := []HTLC{}
+	for rows.Next() {
+		htlc, err := scanHTLC(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error al escanear HTLC: %w", err)
+		}
+		htlcs = append(htlcs, *htlc)
+	}
+	return htlcs, nil
+}
+
+// nextBlock estima el contexto del próximo bloque para validar solicitudes antes de encolarlas.
+func (s *Server) nextBlock() BlockContext {
+	return BlockContext{Height: s.Blockchain.Height() + 1, Time: time.Now()}
+}
+
+// HTLCLockHandler maneja la creación de un HTLC. La transacción debe estar firmada; su hash,
+// devuelto como tx_hash, identifica el HTLC.
+func (s *Server) HTLCLockHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		HTLCLockPayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From, &payload.To) {
+		return
+	}
+	if payload.Signature == "" {
+		http.Error(w, "Los HTLC requieren una transacción firmada", http.StatusBadRequest)
+		return
+	}
+	if err := payload.HTLCLockPayload.validate(s.nextBlock()); err != nil {
+		http.Error(w, fmt.Sprintf("HTLC inválido: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	data, _ := json.Marshal(payload.HTLCLockPayload)
+	s.submitTransaction(w, Transaction{
+		From:      payload.From,
+		To:        payload.To,
+		Amount:    payload.Amount,
+		Type:      TxTypeHTLCLock,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// HTLCClaimHandler maneja el reclamo de un HTLC revelando su preimagen.
+func (s *Server) HTLCClaimHandler(w http.ResponseWriter, r *http.Request) {
+	s.settleHandler(w, r, TxTypeHTLCClaim)
+}
+
+// HTLCRefundHandler maneja el reembolso de un HTLC vencido.
+func (s *Server) HTLCRefundHandler(w http.ResponseWriter, r *http.Request) {
+	s.settleHandler(w, r, TxTypeHTLCRefund)
+}
+
+// settleHandler valida una solicitud de reclamo o reembolso contra el estado actual y la encola.
+func (s *Server) settleHandler(w http.ResponseWriter, r *http.Request, txType string) {
+	var payload struct {
+		From string `json:"from"`
+		HTLCSettlePayload
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From) {
+		return
+	}
+
+	htlc, err := s.DB.GetHTLC(payload.ID)
+	if err != nil {
+		http.Error(w, "Error obteniendo el HTLC", http.StatusInternalServerError)
+		return
+	}
+	if htlc == nil {
+		http.Error(w, "El HTLC no existe", http.StatusNotFound)
+		return
+	}
+	if htlc.Status != HTLCOpen {
+		http.Error(w, fmt.Sprintf("El HTLC ya fue %s", htlc.Status), http.StatusConflict)
+		return
+	}
+
+	expired := htlc.Expiry().Unlocked(s.nextBlock())
+	switch {
+	case txType == TxTypeHTLCClaim && payload.From != htlc.Recipient:
+		http.Error(w, "Solo el destinatario puede reclamar el HTLC", http.StatusForbidden)
+		return
+	case txType == TxTypeHTLCClaim && expired:
+		http.Error(w, "El HTLC venció, solo puede reembolsarse", http.StatusBadRequest)
+		return
+	case txType == TxTypeHTLCRefund && payload.From != htlc.Sender:
+		http.Error(w, "Solo el emisor puede reembolsar el HTLC", http.StatusForbidden)
+		return
+	case txType == TxTypeHTLCRefund && !expired:
+		http.Error(w, "El HTLC todavía no venció", http.StatusBadRequest)
+		return
+	}
+	if txType == TxTypeHTLCClaim {
+		if err := checkPreimage(htlc.Hashlock, strings.ToLower(payload.Preimage)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.queueSettlement(w, payload.From, txType, payload.HTLCSettlePayload, payload.TxAuth)
+}
+
+// GetHTLC maneja la consulta de un HTLC por su identificador.
+func (s *Server) GetHTLC(w http.ResponseWriter, r *http.Request) {
+	htlc, err := s.DB.GetHTLC(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Error obteniendo el HTLC", http.StatusInternalServerError)
+		return
+	}
+	if htlc == nil {
+		http.Error(w, "El HTLC no existe", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(htlc)
+}
+
+// GetHTLCs maneja la consulta de HTLC por cuenta y estado (por defecto, los abiertos).
+func (s *Server) GetHTLCs(w http.ResponseWriter, r *http.Request) {
+	s.listByAccount(w, r, HTLCOpen, "HTLCs", func(account, status string) (interface{}, error) {
+		return s.DB.GetHTLCs(account, status)
+	})
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// testDatabaseEnv es la variable de entorno con la URL de PostgreSQL de las pruebas. Cada red
+// de prueba usa su propio esquema en esa base de datos.
+const testDatabaseEnv = "QUBIT_TEST_DATABASE_URL"
+
+// testNetwork es una red de prueba en proceso, con su propia base de datos y su servidor HTTP.
+type testNetwork struct {
+	db     *Database
+	server *Server
+	url    string
+}
+
+// newTestNetwork crea una red en el esquema name con los saldos iniciales dados.
+func newTestNetwork(t *testing.T, name string, alloc map[string]int64) *testNetwork {
+	t.Helper()
+	base := os.Getenv(testDatabaseEnv)
+	if base == "" {
+		t.Skipf("defina %s para ejecutar las pruebas con PostgreSQL", testDatabaseEnv)
+	}
+
+	schema := "qubit_test_" + name
+	admin, err := sql.Open("postgres", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE; CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("error creando el esquema %s: %s", schema, err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	db, err := InitDB(withSearchPath(t, base, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Connection.Close() })
+
+	for account, amount := range alloc {
+		if err := db.SaveBalance(account, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bc := NewBlockchain("")
+	if err := db.SaveBlock(*bc.Blocks[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(db, bc, &TokenSupply{Balance: alloc})
+	router := mux.NewRouter()
+	server.RegisterRoutes(router)
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+
+	return &testNetwork{db: db, server: server, url: httpServer.URL}
+}
+
+// withSearchPath añade a la cadena de conexión el esquema en el que se crean las tablas.
+func withSearchPath(t *testing.T, connection, schema string) string {
+	t.Helper()
+	parsed, err := url.Parse(connection)
+	if err != nil || parsed.Scheme == "" {
+		return connection + " search_path=" + schema
+	}
+	query := parsed.Query()
+	query.Set("search_path", schema)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// mine aplica las transacciones pendientes en un bloque nuevo, como el bucle de minería.
+func (n *testNetwork) mine(t *testing.T) {
+	t.Helper()
+	pending, err := n.db.GetPendingTransactions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := n.server.Blockchain.NextBlock(pending, time.Now().UTC().Format(time.RFC3339))
+	if err := n.db.ApplyBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.db.SaveBlock(*block); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.server.Blockchain.AppendBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.db.ClearPendingTransactions(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// post envía una solicitud JSON a la red, comprueba el código de estado y decodifica la respuesta.
+func (n *testNetwork) post(t *testing.T, path string, body interface{}, status int) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(n.url+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("POST %s: estado %d, se esperaba %d: %s", path, resp.StatusCode, status, buf.String())
+	}
+	result := map[string]interface{}{}
+	json.Unmarshal(buf.Bytes(), &result)
+	return result
+}
+
+// htlc obtiene un HTLC por HTTP, como lo vería la contraparte del intercambio.
+func (n *testNetwork) htlc(t *testing.T, id string) HTLC {
+	t.Helper()
+	resp, err := http.Get(n.url + "/htlc/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /htlc/%s: estado %d", id, resp.StatusCode)
+	}
+
+	var htlc HTLC
+	if err := json.NewDecoder(resp.Body).Decode(&htlc); err != nil {
+		t.Fatal(err)
+	}
+	return htlc
+}
+
+// balance obtiene el saldo de una cuenta en la red.
+func (n *testNetwork) balance(t *testing.T, account string) int64 {
+	t.Helper()
+	balance, err := n.db.GetBalance(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+// lock crea un HTLC firmado por key y devuelve su identificador.
+func (n *testNetwork) lock(t *testing.T, key wallet.Key, nonce uint64, to string, amount int64, hashlock string, expiry int) string {
+	t.Helper()
+	payload := HTLCLockPayload{Hashlock: hashlock, Expiry: LockCondition{UnlockHeight: expiry}}
+	data, _ := json.Marshal(payload)
+	auth, err := wallet.Authorize(key, to, amount, TxTypeHTLCLock, data, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := n.post(t, "/htlc/lock", struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		HTLCLockPayload
+		wallet.TxAuth
+	}{key.Address(), to, amount, payload, auth}, http.StatusOK)
+	return result["tx_hash"].(string)
+}
+
+// settle envía un reclamo o reembolso firmado por key y comprueba el código de estado.
+func (n *testNetwork) settle(t *testing.T, key wallet.Key, nonce uint64, txType, id, preimage string, status int) {
+	t.Helper()
+	payload := HTLCSettlePayload{ID: id, Preimage: preimage}
+	data, _ := json.Marshal(payload)
+	auth, err := wallet.Authorize(key, key.Address(), 0, txType, data, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/htlc/claim"
+	if txType == TxTypeHTLCRefund {
+		path = "/htlc/refund"
+	}
+	n.post(t, path, struct {
+		From string `json:"from"`
+		HTLCSettlePayload
+		wallet.TxAuth
+	}{key.Address(), payload, auth}, status)
+}
+
+func newTestKey(t *testing.T) wallet.Key {
+	t.Helper()
+	key, err := wallet.NewKey(wallet.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestHTLCAtomicSwap intercambia fondos entre dos redes: quien revela la preimagen para
+// reclamar en una red permite a la contraparte reclamar en la otra.
+func TestHTLCAtomicSwap(t *testing.T) {
+	alice, bob := newTestKey(t), newTestKey(t)
+	chainA := newTestNetwork(t, "swap_a", map[string]int64{alice.Address(): 1000})
+	chainB := newTestNetwork(t, "swap_b", map[string]int64{bob.Address(): 1000})
+
+	preimage := make([]byte, 32)
+	rand.Read(preimage)
+	sum := sha256.Sum256(preimage)
+	hashlock := hex.EncodeToString(sum[:])
+
+	// Alice bloquea primero y con el vencimiento más largo, para que Bob tenga tiempo de
+	// reclamar después de que ella revele la preimagen.
+	idA := chainA.lock(t, alice, 1, bob.Address(), 100, hashlock, 20)
+	chainA.mine(t)
+	if htlc := chainA.htlc(t, idA); htlc.Status != HTLCOpen || htlc.Amount != 100 {
+		t.Fatalf("HTLC en la red A: %+v", htlc)
+	}
+
+	idB := chainB.lock(t, bob, 1, alice.Address(), 50, hashlock, 10)
+	chainB.mine(t)
+
+	// Alice reclama en la red B y con ello publica la preimagen.
+	chainB.settle(t, alice, 1, TxTypeHTLCClaim, idB, hex.EncodeToString(preimage), http.StatusOK)
+	chainB.mine(t)
+
+	revealed := chainB.htlc(t, idB)
+	if revealed.Status != HTLCClaimed || revealed.Preimage != hex.EncodeToString(preimage) {
+		t.Fatalf("HTLC en la red B tras el reclamo: %+v", revealed)
+	}
+
+	// Bob usa la preimagen publicada para reclamar en la red A.
+	chainA.settle(t, bob, 1, TxTypeHTLCClaim, idA, revealed.Preimage, http.StatusOK)
+	chainA.mine(t)
+	if htlc := chainA.htlc(t, idA); htlc.Status != HTLCClaimed {
+		t.Fatalf("HTLC en la red A tras el reclamo: %+v", htlc)
+	}
+
+	balances := []struct {
+		network *testNetwork
+		account string
+		want    int64
+	}{
+		{chainA, alice.Address(), 900},
+		{chainA, bob.Address(), 100},
+		{chainB, bob.Address(), 950},
+		{chainB, alice.Address(), 50},
+	}
+	for _, b := range balances {
+		if got := b.network.balance(t, b.account); got != b.want {
+			t.Errorf("saldo de %s: %d, se esperaba %d", b.account, got, b.want)
+		}
+	}
+}
+
+// TestHTLCRefundAfterExpiry comprueba que un HTLC no reclamado solo puede reembolsarse al emisor
+// una vez vencido.
+func TestHTLCRefundAfterExpiry(t *testing.T) {
+	alice, bob := newTestKey(t), newTestKey(t)
+	chain := newTestNetwork(t, "refund", map[string]int64{alice.Address(): 1000})
+
+	preimage := []byte("secreto")
+	sum := sha256.Sum256(preimage)
+	id := chain.lock(t, alice, 1, bob.Address(), 100, hex.EncodeToString(sum[:]), 3)
+	chain.mine(t)
+	if got := chain.balance(t, alice.Address()); got != 900 {
+		t.Fatalf("saldo de Alice tras bloquear: %d", got)
+	}
+
+	// Antes del vencimiento no se puede reembolsar.
+	chain.settle(t, alice, 2, TxTypeHTLCRefund, id, "", http.StatusBadRequest)
+	chain.mine(t)
+
+	// A partir de la altura de vencimiento el destinatario ya no puede reclamar.
+	chain.settle(t, bob, 1, TxTypeHTLCClaim, id, hex.EncodeToString(preimage), http.StatusBadRequest)
+	chain.settle(t, alice, 2, TxTypeHTLCRefund, id, "", http.StatusOK)
+	chain.mine(t)
+
+	if htlc := chain.htlc(t, id); htlc.Status != HTLCRefunded {
+		t.Fatalf("HTLC tras el reembolso: %+v", htlc)
+	}
+	if got := chain.balance(t, alice.Address()); got != 1000 {
+		t.Errorf("saldo de Alice tras el reembolso: %d, se esperaba 1000", got)
+	}
+	if got := chain.balance(t, bob.Address()); got != 0 {
+		t.Errorf("saldo de Bob: %d, se esperaba 0", got)
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Prefijos que separan las hojas de los nodos internos, para que una hoja no pueda hacerse
+// pasar por un nodo.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// EmptyMerkleRoot es la raíz de un conjunto vacío.
+var EmptyMerkleRoot = hex.EncodeToString(make([]byte, sha256.Size))
+
+// MerkleStep es un paso de una prueba de inclusión: el hash hermano y si va a la izquierda.
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"`
+}
+
+// merkleLeaf calcula el hash de una hoja clave-valor. La longitud de la clave separa clave y
+// valor sin ambigüedad.
+func merkleLeaf(key, value []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	binary.Write(h, binary.BigEndian, uint32(len(key)))
+	h.Write(key)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// merkleNode calcula el hash de un nodo interno.
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleRoot calcula la raíz de las hojas en el orden dado. Un nodo sin pareja sube sin
+// cambios al nivel siguiente.
+func merkleRoot(leaves [][]byte) string {
+	root, _ := merkleProof(leaves, -1)
+	return root
+}
+
+// merkleProof calcula la raíz y la prueba de inclusión de la hoja index (o ninguna si index
+// es negativo).
+func merkleProof(leaves [][]byte, index int) (string, []MerkleStep) {
+	if len(leaves) == 0 {
+		return EmptyMerkleRoot, nil
+	}
+
+	var proof []MerkleStep
+	level := leaves
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			switch index {
+			case i:
+				proof = append(proof, MerkleStep{Hash: hex.EncodeToString(level[i+1])})
+			case i + 1:
+				proof = append(proof, MerkleStep{Hash: hex.EncodeToString(level[i]), Left: true})
+			}
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+		if index >= 0 {
+			index /= 2
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0]), proof
+}
+
+// VerifyMerkleProof comprueba que el par clave-valor esté incluido en la raíz dada.
+func VerifyMerkleProof(root string, key, value []byte, proof []MerkleStep) bool {
+	hash := merkleLeaf(key, value)
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			hash = merkleNode(sibling, hash)
+		} else {
+			hash = merkleNode(hash, sibling)
+		}
+	}
+	expected, err := hex.DecodeString(root)
+	return err == nil && bytes.Equal(hash, expected)
+}
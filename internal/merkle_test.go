@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) (keys, values [][]byte, leaves [][]byte) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("clave-%02d", i))
+		value := []byte(fmt.Sprintf("valor-%d", i))
+		keys = append(keys, key)
+		values = append(values, value)
+		leaves = append(leaves, merkleLeaf(key, value))
+	}
+	return keys, values, leaves
+}
+
+// TestMerkleRootShape comprueba la raíz de conjuntos pequeños, incluido un nodo sin pareja que
+// sube sin cambios.
+func TestMerkleRootShape(t *testing.T) {
+	if root := merkleRoot(nil); root != EmptyMerkleRoot {
+		t.Errorf("raíz vacía: %s", root)
+	}
+
+	_, _, leaves := testLeaves(3)
+	if root := merkleRoot(leaves[:1]); root != hex.EncodeToString(leaves[0]) {
+		t.Errorf("raíz de una hoja: %s", root)
+	}
+	want := hex.EncodeToString(merkleNode(merkleNode(leaves[0], leaves[1]), leaves[2]))
+	if root := merkleRoot(leaves); root != want {
+		t.Errorf("raíz de tres hojas: %s, se esperaba %s", root, want)
+	}
+
+	// La longitud de la clave separa clave y valor.
+	if hex.EncodeToString(merkleLeaf([]byte("ab"), []byte("c"))) == hex.EncodeToString(merkleLeaf([]byte("a"), []byte("bc"))) {
+		t.Error("dos pares distintos producen la misma hoja")
+	}
+}
+
+// TestMerkleProofs comprueba que cada hoja de árboles de distintos tamaños tenga una prueba
+// válida contra la raíz, y que se rechacen las pruebas alteradas.
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		keys, values, leaves := testLeaves(n)
+		for i := range leaves {
+			root, proof := merkleProof(leaves, i)
+			if root != merkleRoot(leaves) {
+				t.Fatalf("%d hojas, hoja %d: la raíz de la prueba no coincide", n, i)
+			}
+			if !VerifyMerkleProof(root, keys[i], values[i], proof) {
+				t.Errorf("%d hojas, hoja %d: prueba rechazada", n, i)
+				continue
+			}
+
+			if VerifyMerkleProof(root, keys[i], []byte("otro valor"), proof) {
+				t.Errorf("%d hojas, hoja %d: se aceptó otro valor", n, i)
+			}
+			if VerifyMerkleProof(root, []byte("otra clave"), values[i], proof) {
+				t.Errorf("%d hojas, hoja %d: se aceptó otra clave", n, i)
+			}
+			if VerifyMerkleProof(EmptyMerkleRoot, keys[i], values[i], proof) {
+				t.Errorf("%d hojas, hoja %d: se aceptó otra raíz", n, i)
+			}
+			if len(proof) == 0 {
+				continue
+			}
+			if VerifyMerkleProof(root, keys[i], values[i], proof[:len(proof)-1]) {
+				t.Errorf("%d hojas, hoja %d: se aceptó una prueba incompleta", n, i)
+			}
+			swapped := append([]MerkleStep(nil), proof...)
+			swapped[0].Left = !swapped[0].Left
+			if VerifyMerkleProof(root, keys[i], values[i], swapped) {
+				t.Errorf("%d hojas, hoja %d: se aceptó un hermano del lado contrario", n, i)
+			}
+			malformed := append([]MerkleStep(nil), proof...)
+			malformed[0].Hash = malformed[0].Hash[:10]
+			if VerifyMerkleProof(root, keys[i], values[i], malformed) {
+				t.Errorf("%d hojas, hoja %d: se aceptó un hash truncado", n, i)
+			}
+		}
+	}
+}
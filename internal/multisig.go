@@ -0,0 +1,399 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Estados de una propuesta de transferencia multifirma.
+const (
+	ProposalPending = "pending" // Reuniendo firmas
+	ProposalQueued  = "queued"  // Alcanzó el umbral y entró en la cola de transacciones
+)
+
+// MultisigProposal es una transferencia de una cuenta multifirma que reúne firmas parciales
+// antes de entrar en la cola de transacciones pendientes.
+type MultisigProposal struct {
+	ID          int                `json:"id"`
+	Account     string             `json:"account"`
+	To          string             `json:"to"`
+	Amount      int64              `json:"amount"`
+	Nonce       uint64             `json:"nonce"`
+	Signatures  []wallet.Signature `json:"signatures"`
+	Status      string             `json:"status"`
+	SigningHash string             `json:"signing_hash"` // Mensaje que debe firmar cada titular
+}
+
+// Transaction construye la transacción de la propuesta con las firmas reunidas.
+func (p MultisigProposal) Transaction() Transaction {
+	return Transaction{
+		From:       p.Account,
+		To:         p.To,
+		Amount:     p.Amount,
+		Nonce:      p.Nonce,
+		Signatures: p.Signatures,
+	}
+}
+
+// ErrMultisigConflict indica que la dirección ya está registrada con otra política.
+var ErrMultisigConflict = errors.New("la dirección ya pertenece a otra cuenta multifirma")
+
+// SaveMultisigAccount registra una cuenta multifirma con saldo cero y devuelve su dirección.
+// Registrar de nuevo la misma política no tiene efecto.
+func (d *Database) SaveMultisigAccount(policy *wallet.MultisigPolicy) (string, error) {
+	address, err := policy.Address()
+	if err != nil {
+		return "", err
+	}
+	keys, err := json.Marshal(policy.PublicKeys)
+	if err != nil {
+		return "", fmt.Errorf("error serializando claves: %w", err)
+	}
+
+	_, err = d.Connection.Exec(
+		"INSERT INTO multisig_accounts (address, threshold, public_keys) VALUES ($1, $2, $3) ON CONFLICT (address) DO NOTHING",
+		address, policy.Threshold, string(keys),
+	)
+	if err != nil {
+		return "", fmt.Errorf("error guardando cuenta multifirma: %w", err)
+	}
+	stored, err := d.GetMultisigPolicy(address)
+	if err != nil {
+		return "", err
+	}
+	if !stored.Equal(policy) {
+		return "", fmt.Errorf("%w: %s", ErrMultisigConflict, address)
+	}
+
+	exists, err := d.AccountExists(address)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := d.SaveBalance(address, 0); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Printf("Cuenta multifirma %d de %d registrada: %s\n", policy.Threshold, len(policy.PublicKeys), address)
+	return address, nil
+}
+
+// GetMultisigPolicy obtiene la política de una cuenta multifirma, o nil si la cuenta no lo es.
+func (d *Database) GetMultisigPolicy(address string) (*wallet.MultisigPolicy, error) {
+	var policy wallet.MultisigPolicy
+	var keys string
+	err := d.Connection.QueryRow(
+		"SELECT threshold, public_keys FROM multisig_accounts WHERE address = $1", address,
+	).Scan(&policy.Threshold, &keys)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo cuenta multifirma: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(keys), &policy.PublicKeys); err != nil {
+		return nil, fmt.Errorf("error deserializando claves: %w", err)
+	}
+	return &policy, nil
+}
+
+// CreateMultisigProposal guarda una nueva propuesta de transferencia y devuelve su identificador.
+func (d *Database) CreateMultisigProposal(p MultisigProposal) (int, error) {
+	var id int
+	err := d.Connection.QueryRow(
+		`INSERT INTO multisig_proposals (account, to_account, amount, nonce, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		p.Account, p.To, p.Amount, p.Nonce, ProposalPending, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error guardando propuesta multifirma: %w", err)
+	}
+	return id, nil
+}
+
+// GetMultisigProposal obtiene una propuesta multifirma, o nil si no existe.
+func (d *Database) GetMultisigProposal(id int) (*MultisigProposal, error) {
+	var p MultisigProposal
+	var signatures string
+	err := d.Connection.QueryRow(
+		"SELECT id, account, to_account, amount, nonce, signatures, status FROM multisig_proposals WHERE id = $1", id,
+	).Scan(&p.ID, &p.Account, &p.To, &p.Amount, &p.Nonce, &signatures, &p.Status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error obteniendo propuesta multifirma: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(signatures), &p.Signatures); err != nil {
+		return nil, fmt.Errorf("error deserializando firmas: %w", err)
+	}
+	p.SigningHash = hex.EncodeToString(p.Transaction().SigningHash())
+	return &p, nil
+}
+
+// AddProposalSignature valida una firma parcial y la añade a una propuesta pendiente. Cuando la
+// propuesta alcanza el umbral de la cuenta, su transacción entra en la cola de pendientes.
+func (d *Database) AddProposalSignature(id int, sig wallet.Signature) (*MultisigProposal, error) {
+	proposal, err := d.GetMultisigProposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		return nil, fmt.Errorf("la propuesta %d no existe", id)
+	}
+	if proposal.Status != ProposalPending {
+		return nil, fmt.Errorf("la propuesta %d ya no acepta firmas", id)
+	}
+
+	policy, err := d.GetMultisigPolicy(proposal.Account)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("la cuenta %s no es multifirma", proposal.Account)
+	}
+
+	sig.PublicKey, err = wallet.NormalizePublicKey(sig.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.HasKey(sig.PublicKey) {
+		return nil, errors.New("la clave no pertenece a la cuenta multifirma")
+	}
+	for _, existing := range proposal.Signatures {
+		if existing.PublicKey == sig.PublicKey {
+			return nil, errors.New("la clave ya firmó esta propuesta")
+		}
+	}
+	if err := wallet.Verify(sig.PublicKey, proposal.Transaction().SigningHash(), sig.Signature); err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal([]wallet.Signature{sig})
+	_, err = d.Connection.Exec(
+		"UPDATE multisig_proposals SET signatures = signatures || $2::jsonb WHERE id = $1 AND status = $3",
+		id, string(data), ProposalPending,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error guardando firma: %w", err)
+	}
+
+	proposal, err = d.GetMultisigProposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.queueProposal(proposal, policy); err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+// queueProposal envía la transacción a la cola si la propuesta reúne las firmas requeridas.
+func (d *Database) queueProposal(p *MultisigProposal, policy *wallet.MultisigPolicy) error {
+	tx := p.Transaction()
+	if policy.VerifySignatures(tx.SigningHash(), tx.Signatures) != nil {
+		return nil
+	}
+
+	nonce, err := d.GetNonce(p.Account)
+	if err != nil {
+		return err
+	}
+	if p.Nonce <= nonce {
+		return fmt.Errorf("el nonce %d de la propuesta ya fue usado por la cuenta", p.Nonce)
+	}
+
+	// Solo una firma concurrente puede cambiar el estado y encolar la transacción.
+	result, err := d.Connection.Exec(
+		"UPDATE multisig_proposals SET status = $2 WHERE id = $1 AND status = $3",
+		p.ID, ProposalQueued, ProposalPending,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando propuesta: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil
+	}
+
+	p.Status = ProposalQueued
+	return d.AddPendingTx(tx)
+}
+
+// CreateMultisigAccountHandler maneja el registro de una cuenta multifirma M de N.
+func (s *Server) CreateMultisigAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Threshold  int      `json:"threshold"`
+		PublicKeys []string `json:"public_keys"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := wallet.NewMultisigPolicy(payload.Threshold, payload.PublicKeys)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Política multifirma inválida: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	address, err := s.DB.SaveMultisigAccount(policy)
+	if errors.Is(err, ErrMultisigConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error registrando la cuenta multifirma", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":        s.DB.FormatAddress(address),
+		"legacy_address": address,
+		"threshold":      policy.Threshold,
+		"public_keys":    policy.PublicKeys,
+	})
+}
+
+// GetMultisigAccount maneja la solicitud para consultar la política de una cuenta multifirma.
+func (s *Server) GetMultisigAccount(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !s.parseAddresses(w, &address) {
+		return
+	}
+
+	policy, err := s.DB.GetMultisigPolicy(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo la cuenta multifirma", http.StatusInternalServerError)
+		return
+	}
+	if policy == nil {
+		http.Error(w, "La cuenta no es multifirma", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":     s.DB.FormatAddress(address),
+		"threshold":   policy.Threshold,
+		"public_keys": policy.PublicKeys,
+	})
+}
+
+// ProposeMultisigTransfer maneja la creación de una transferencia multifirma pendiente de firmas.
+// Si no se indica nonce se usa el siguiente de la cuenta.
+func (s *Server) ProposeMultisigTransfer(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		Nonce  uint64 `json:"nonce"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From, &payload.To) {
+		return
+	}
+
+	policy, err := s.DB.GetMultisigPolicy(payload.From)
+	if err != nil || policy == nil {
+		http.Error(w, "La cuenta origen no es multifirma", http.StatusBadRequest)
+		return
+	}
+	if !s.hasFunds(w, payload.From, payload.Amount) {
+		return
+	}
+
+	nonce, err := s.DB.GetNonce(payload.From)
+	if err != nil {
+		http.Error(w, "Error obteniendo el nonce", http.StatusInternalServerError)
+		return
+	}
+	if payload.Nonce == 0 {
+		payload.Nonce = nonce + 1
+	} else if payload.Nonce <= nonce {
+		http.Error(w, fmt.Sprintf("El nonce debe ser mayor que %d", nonce), http.StatusBadRequest)
+		return
+	}
+
+	proposal := MultisigProposal{
+		Account: payload.From,
+		To:      payload.To,
+		Amount:  payload.Amount,
+		Nonce:   payload.Nonce,
+	}
+	proposal.ID, err = s.DB.CreateMultisigProposal(proposal)
+	if err != nil {
+		http.Error(w, "Error guardando la propuesta", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":           proposal.ID,
+		"nonce":        proposal.Nonce,
+		"signing_hash": hex.EncodeToString(proposal.Transaction().SigningHash()),
+		"threshold":    policy.Threshold,
+	})
+}
+
+// GetMultisigProposal maneja la consulta de una propuesta y sus firmas parciales.
+func (s *Server) GetMultisigProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Identificador de propuesta inválido", http.StatusBadRequest)
+		return
+	}
+
+	proposal, err := s.DB.GetMultisigProposal(id)
+	if err != nil {
+		http.Error(w, "Error obteniendo la propuesta", http.StatusInternalServerError)
+		return
+	}
+	if proposal == nil {
+		http.Error(w, "La propuesta no existe", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proposal)
+}
+
+// SignMultisigProposal maneja el envío de una firma parcial para una propuesta.
+func (s *Server) SignMultisigProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Identificador de propuesta inválido", http.StatusBadRequest)
+		return
+	}
+
+	var sig wallet.Signature
+	if err := json.NewDecoder(r.Body).Decode(&sig); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+
+	proposal, err := s.DB.AddProposalSignature(id, sig)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Firma rechazada: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proposal)
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeInfo identifica la red a la que pertenece un nodo.
+type NodeInfo struct {
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	Height      int    `json:"height"`
+}
+
+// PeerSet mantiene los nodos remotos con los que se estableció conexión.
+type PeerSet struct {
+	mu    sync.RWMutex
+	peers map[string]NodeInfo
+}
+
+// NewPeerSet crea un conjunto de pares vacío.
+func NewPeerSet() *PeerSet {
+	return &PeerSet{peers: make(map[string]NodeInfo)}
+}
+
+// Add registra un par después de verificar que comparte el mismo génesis.
+func (ps *PeerSet) Add(address string, local, remote NodeInfo) error {
+	if remote.ChainID != local.ChainID {
+		return fmt.Errorf("el par %s pertenece a la cadena %s, se esperaba %s", address, remote.ChainID, local.ChainID)
+	}
+	if remote.GenesisHash != local.GenesisHash {
+		return fmt.Errorf("el par %s tiene un génesis distinto (%s)", address, remote.GenesisHash)
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.peers[address] = remote
+	return nil
+}
+
+// List devuelve los pares conectados.
+func (ps *PeerSet) List() map[string]NodeInfo {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	peers := make(map[string]NodeInfo, len(ps.peers))
+	for address, info := range ps.peers {
+		peers[address] = info
+	}
+	return peers
+}
+
+// FetchNodeInfo consulta la identidad de un nodo remoto.
+func FetchNodeInfo(address string) (NodeInfo, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(strings.TrimRight(address, "/") + "/node-info")
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("error contactando al par: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return NodeInfo{}, fmt.Errorf("el par respondió con estado %d", resp.StatusCode)
+	}
+
+	var info NodeInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return NodeInfo{}, fmt.Errorf("respuesta inválida del par: %w", err)
+	}
+	return info, nil
+}
+
+// LocalNodeInfo devuelve la identidad de este nodo.
+func (s *Server) LocalNodeInfo() NodeInfo {
+	info := NodeInfo{Height: len(s.Blockchain.Blocks) - 1}
+	if s.Genesis != nil {
+		info.ChainID = s.Genesis.ChainID
+		info.GenesisHash = s.Genesis.Hash()
+	}
+	return info
+}
+
+// GetNodeInfo maneja la solicitud de identidad del nodo usada en el saludo entre pares.
+func (s *Server) GetNodeInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.LocalNodeInfo())
+}
+
+// AddPeer maneja la solicitud para conectar con un nodo remoto.
+func (s *Server) AddPeer(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Address == "" {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+
+	remote, err := FetchNodeInfo(payload.Address)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error contactando al par: %s", err), http.StatusBadGateway)
+		return
+	}
+
+	if err := s.Peers.Add(payload.Address, s.LocalNodeInfo(), remote); err != nil {
+		http.Error(w, fmt.Sprintf("Par rechazado: %s", err), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Par agregado",
+		"peer":    remote,
+	})
+}
+
+// GetPeers maneja la solicitud para listar los pares conectados.
+func (s *Server) GetPeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Peers.List())
+}
@@ -1,12 +1,14 @@
 package internal
 
 import (
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"blockchain-go/wallet"
+
 	"github.com/gorilla/mux"
 )
 
@@ -14,6 +16,9 @@ type Server struct {
 	DB          *Database
 	Blockchain  *Blockchain
 	TokenSupply *TokenSupply
+	Genesis     *Genesis // Génesis de la red, usado para rechazar pares de otras cadenas
+	Peers       *PeerSet
+	Faucet      *Faucet // nil si el grifo no está configurado
 }
 
 // NewServer inicializa un servidor con la base de datos, blockchain y token supply.
@@ -22,22 +27,14 @@ func NewServer(db *Database, bc *Blockchain, ts *TokenSupply) *Server {
 		DB:          db,
 		Blockchain:  bc,
 		TokenSupply: ts,
+		Peers:       NewPeerSet(),
 	}
 }
 
 // Start inicia el servidor HTTP y lanza el proceso de minería.
 func (s *Server) Start(port int) {
 	router := mux.NewRouter()
-
-	// Rutas de la API
-	router.HandleFunc("/blocks", s.GetBlocks).Methods("GET")
-	router.HandleFunc("/balances/{account}", s.GetBalance).Methods("GET")
-	router.HandleFunc("/transactions", s.GetTransactions).Methods("GET")
-	router.HandleFunc("/generate-address", s.GenerateAddress).Methods("GET")
-	router.HandleFunc("/transactions", s.AddTransaction).Methods("POST")
-	router.HandleFunc("/wasm-contracts", s.AddWASMContract).Methods("POST")
-	router.HandleFunc("/execute-wasm", s.ExecuteWASMContract).Methods("POST")
-	router.HandleFunc("/wasm-contracts", s.AddWASMContract).Methods("POST")
+	s.RegisterRoutes(router)
 
 	// Servir el archivo swagger.json
 	router.HandleFunc("/swagger.json", func(w http.ResponseWriter, r *http.Request) {
@@ -73,6 +70,81 @@ func (s *Server) Start(port int) {
 
 }
 
+// RegisterRoutes registra las rutas de la API en el router dado.
+func (s *Server) RegisterRoutes(router *mux.Router) {
+	// Rutas de la API
+	router.HandleFunc("/blocks", s.GetBlocks).Methods("GET")
+	router.HandleFunc("/balances/{account}", s.GetBalance).Methods("GET")
+	router.HandleFunc("/transactions", s.GetTransactions).Methods("GET")
+	router.HandleFunc("/addresses", s.RegisterAddressHandler).Methods("POST")
+	router.HandleFunc("/accounts/{address}", s.GetAccount).Methods("GET")
+	router.HandleFunc("/transactions", s.AddTransaction).Methods("POST")
+	router.HandleFunc("/transactions/batch", s.AddBatchTransaction).Methods("POST")
+	router.HandleFunc("/accounts/{address}/transactions", s.GetAccountHistory).Methods("GET")
+	router.HandleFunc("/accounts/{address}/balance", s.GetAccountBalance).Methods("GET")
+	router.HandleFunc("/transactions/timelock", s.TimelockHandler).Methods("POST")
+
+	// Contratos de bloqueo por hash y tiempo (HTLC)
+	router.HandleFunc("/htlc", s.GetHTLCs).Methods("GET")
+	router.HandleFunc("/htlc/lock", s.HTLCLockHandler).Methods("POST")
+	router.HandleFunc("/htlc/claim", s.HTLCClaimHandler).Methods("POST")
+	router.HandleFunc("/htlc/refund", s.HTLCRefundHandler).Methods("POST")
+	router.HandleFunc("/htlc/{id}", s.GetHTLC).Methods("GET")
+
+	// Depósitos en garantía con árbitro
+	router.HandleFunc("/escrow", s.GetEscrows).Methods("GET")
+	router.HandleFunc("/escrow/open", s.EscrowOpenHandler).Methods("POST")
+	router.HandleFunc("/escrow/approve", s.EscrowApproveHandler).Methods("POST")
+	router.HandleFunc("/escrow/cancel", s.EscrowCancelHandler).Methods("POST")
+	router.HandleFunc("/escrow/resolve", s.EscrowResolveHandler).Methods("POST")
+	router.HandleFunc("/escrow/{id}", s.GetEscrow).Methods("GET")
+
+	// Canales de pago unidireccionales
+	router.HandleFunc("/channels", s.GetChannels).Methods("GET")
+	router.HandleFunc("/channels/open", s.ChannelOpenHandler).Methods("POST")
+	router.HandleFunc("/channels/close", s.ChannelCloseHandler).Methods("POST")
+	router.HandleFunc("/channels/challenge", s.ChannelChallengeHandler).Methods("POST")
+	router.HandleFunc("/channels/{id}", s.GetChannel).Methods("GET")
+	router.HandleFunc("/channels/{id}/verify", s.VerifyChannelUpdate).Methods("POST")
+
+	router.HandleFunc("/wasm-contracts", s.DeployContractHandler).Methods("POST")
+	router.HandleFunc("/wasm-contracts/{id}/storage/{key}", s.GetContractStorage).Methods("GET")
+	router.HandleFunc("/wasm-contracts/{id}/query", s.QueryContractHandler).Methods("POST")
+	router.HandleFunc("/wasm-cache/stats", s.GetModuleCacheStats).Methods("GET")
+	router.HandleFunc("/execute-wasm", s.ExecuteWASMContract).Methods("POST")
+	router.HandleFunc("/contract-calls", s.ContractCallHandler).Methods("POST")
+	router.HandleFunc("/receipts/{hash}", s.GetReceipt).Methods("GET")
+	router.HandleFunc("/logs", s.GetLogs).Methods("GET")
+	router.HandleFunc("/logs/stream", s.StreamLogs).Methods("GET")
+
+	// Rutas de staking
+	router.HandleFunc("/staking/bond", s.BondHandler).Methods("POST")
+	router.HandleFunc("/staking/delegate", s.DelegateHandler).Methods("POST")
+	router.HandleFunc("/staking/unbond", s.UnbondHandler).Methods("POST")
+	router.HandleFunc("/staking/evidence", s.EvidenceHandler).Methods("POST")
+	router.HandleFunc("/staking/validators", s.GetValidators).Methods("GET")
+	router.HandleFunc("/staking/stakes/{account}", s.GetStakes).Methods("GET")
+
+	// Rutas de cuentas multifirma
+	router.HandleFunc("/multisig/accounts", s.CreateMultisigAccountHandler).Methods("POST")
+	router.HandleFunc("/multisig/accounts/{address}", s.GetMultisigAccount).Methods("GET")
+	router.HandleFunc("/multisig/proposals", s.ProposeMultisigTransfer).Methods("POST")
+	router.HandleFunc("/multisig/proposals/{id}", s.GetMultisigProposal).Methods("GET")
+	router.HandleFunc("/multisig/proposals/{id}/signatures", s.SignMultisigProposal).Methods("POST")
+
+	// Rutas de finalidad
+	router.HandleFunc("/finality", s.GetFinality).Methods("GET")
+	router.HandleFunc("/finality/checkpoints", s.AddCheckpoint).Methods("POST")
+
+	// Rutas de pares
+	router.HandleFunc("/node-info", s.GetNodeInfo).Methods("GET")
+	router.HandleFunc("/peers", s.GetPeers).Methods("GET")
+	router.HandleFunc("/peers", s.AddPeer).Methods("POST")
+
+	// Grifo de tokens de prueba
+	router.HandleFunc("/faucet", s.FaucetHandler).Methods("POST")
+}
+
 // StartMining procesa transacciones pendientes y genera bloques periódicamente.
 func (s *Server) StartMining() {
 	for {
@@ -88,19 +160,23 @@ func (s *Server) StartMining() {
 		}
 
 		// Crear un nuevo bloque con las transacciones pendientes
-		newBlock := s.Blockchain.AddBlock(pendingTxs, time.Now().UTC().Format(time.RFC3339))
+		newBlock := s.Blockchain.NextBlock(pendingTxs, time.Now().UTC().Format(time.RFC3339))
+
+		// Aplicar las transacciones del bloque al estado; esto fija la raíz de almacenamiento
+		// y el hash del bloque, así que debe ocurrir antes de guardarlo
+		if err := s.DB.ApplyBlock(newBlock); err != nil {
+			fmt.Printf("Error al aplicar el bloque: %s\n", err)
+			continue
+		}
+
 		err = s.DB.SaveBlock(*newBlock)
 		if err != nil {
 			fmt.Printf("Error al guardar el bloque: %s\n", err)
 			continue
 		}
-
-		// Actualizar saldos en la base de datos
-		for _, tx := range pendingTxs {
-			err = s.DB.UpdateBalances(tx.From, tx.To, tx.Amount)
-			if err != nil {
-				fmt.Printf("Error al actualizar saldos: %s\n", err)
-			}
+		if err := s.Blockchain.AppendBlock(newBlock); err != nil {
+			fmt.Printf("Error al agregar el bloque: %s\n", err)
+			continue
 		}
 
 		// Limpiar las transacciones pendientes
@@ -113,23 +189,6 @@ func (s *Server) StartMining() {
 	}
 }
 
-// GenerateAddress genera una nueva dirección y devuelve la clave privada asociada.
-func (s *Server) GenerateAddress(w http.ResponseWriter, r *http.Request) {
-	address, privKey, err := GetAddress(s.DB, 1000) // Saldo inicial configurable
-	if err != nil {
-		http.Error(w, "Error generando dirección", http.StatusInternalServerError)
-		return
-	}
-
-	privKeyHex := hex.EncodeToString(privKey.D.Bytes())
-	response := map[string]string{
-		"address":     address,
-		"private_key": privKeyHex,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
 // GetBlocks maneja la solicitud para obtener todos los bloques.
 func (s *Server) GetBlocks(w http.ResponseWriter, r *http.Request) {
 	blocks, err := s.DB.LoadBlocks()
@@ -146,6 +205,9 @@ func (s *Server) GetBlocks(w http.ResponseWriter, r *http.Request) {
 func (s *Server) GetBalance(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	account := vars["account"]
+	if !s.parseAddresses(w, &account) {
+		return
+	}
 
 	balance, err := s.DB.GetBalance(account)
 	if err != nil {
@@ -155,11 +217,51 @@ func (s *Server) GetBalance(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"account": account,
+		"account": s.DB.FormatAddress(account),
 		"balance": balance,
 	})
 }
 
+// GetAccount maneja la solicitud para obtener el saldo y el nonce de una cuenta.
+func (s *Server) GetAccount(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !s.parseAddresses(w, &address) {
+		return
+	}
+
+	exists, err := s.DB.AccountExists(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo la cuenta", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := s.DB.GetBalance(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo el saldo", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := s.DB.GetNonce(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo el nonce", http.StatusInternalServerError)
+		return
+	}
+	pendingNonce, err := s.DB.GetPendingNonce(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo el nonce", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":       s.DB.FormatAddress(address),
+		"exists":        exists,
+		"balance":       balance,
+		"nonce":         nonce,
+		"pending_nonce": pendingNonce,
+	})
+}
+
 // GetTransactions maneja una solicitud para obtener todas las transacciones.
 func (s *Server) GetTransactions(w http.ResponseWriter, r *http.Request) {
 	transactions, err := s.DB.LoadTransactions()
@@ -175,15 +277,34 @@ func (s *Server) GetTransactions(w http.ResponseWriter, r *http.Request) {
 // AddTransaction maneja una solicitud para registrar una nueva transacción.
 func (s *Server) AddTransaction(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		From   string `json:"from"`
-		To     string `json:"to"`
-		Amount int64  `json:"amount"`
+		From      string `json:"from"`
+		To        string `json:"to"`
+		Amount    int64  `json:"amount"`
+		Nonce     uint64 `json:"nonce"`
+		PublicKey string `json:"public_key"`
+		Signature string `json:"signature"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
 		return
 	}
+	if !s.parseAddresses(w, &payload.From, &payload.To) {
+		return
+	}
+
+	tx := Transaction{
+		From:      payload.From,
+		To:        payload.To,
+		Amount:    payload.Amount,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	}
+	if err := s.DB.VerifyPendingTransactionAuth(tx); err != nil {
+		http.Error(w, fmt.Sprintf("Transacción no autorizada: %s", err), http.StatusUnauthorized)
+		return
+	}
 
 	// Validar que las cuentas existan
 	fromExists, err := s.DB.AccountExists(payload.From)
@@ -198,7 +319,7 @@ func (s *Server) AddTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.DB.AddPendingTransaction(payload.From, payload.To, payload.Amount)
+	err = s.DB.AddPendingTx(tx)
 	if err != nil {
 		http.Error(w, "Error añadiendo transacción pendiente", http.StatusInternalServerError)
 		return
@@ -210,63 +331,155 @@ func (s *Server) AddTransaction(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// AddWASMContract maneja la solicitud para registrar un nuevo contrato WASM.
-func (s *Server) AddWASMContract(w http.ResponseWriter, r *http.Request) {
+// ExecuteWASMContract simula la ejecución de un contrato WASM con un límite de gas opcional. Los
+// cambios de estado se descartan; para persistirlos se envía una llamada a contrato.
+func (s *Server) ExecuteWASMContract(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		ID       string `json:"id"`
-		Owner    string `json:"owner"`
-		WASMCode []byte `json:"wasm_code"`
+		Method   string `json:"method"`
+		Caller   string `json:"caller"`
+		Input    []byte `json:"input"`
+		GasLimit uint64 `json:"gas_limit"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
 		return
 	}
+	if payload.Caller != "" && !s.parseAddresses(w, &payload.Caller) {
+		return
+	}
+	if payload.Method == "" {
+		payload.Method = DefaultContractMethod
+	}
+	if payload.GasLimit == 0 {
+		payload.GasLimit = DefaultGasLimit
+	}
+	if err := validateContractCall(payload.Method, payload.GasLimit); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	wasmContract := WASMContract{
-		ID:       payload.ID,
-		Owner:    payload.Owner,
-		WASMCode: payload.WASMCode,
+	contract, err := s.DB.LoadWASMContract(s.contractID(payload.ID))
+	if err != nil || contract == nil {
+		http.Error(w, "Contrato WASM no encontrado", http.StatusNotFound)
+		return
 	}
 
-	err := s.DB.SaveWASMContract(wasmContract)
+	// La simulación se ejecuta en una transacción que siempre se revierte.
+	dbTx, err := s.DB.Connection.Begin()
+	if err != nil {
+		http.Error(w, "Error iniciando la simulación", http.StatusInternalServerError)
+		return
+	}
+	defer dbTx.Rollback()
+
+	env := ExecutionEnv{Caller: payload.Caller, Block: s.nextBlock(), State: newStorageBuffer(sqlState{dbTx})}
+	result, err := contract.Execute(env, payload.Method, payload.Input, payload.GasLimit)
+	if errors.Is(err, ErrOutOfGas) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    err.Error(),
+			"gas_used": result.GasUsed,
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error guardando contrato WASM", http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error ejecutando contrato WASM: %s", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Contrato WASM registrado exitosamente",
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"result":   result.Output,
+		"gas_used": result.GasUsed,
+		"events":   result.Events,
 	})
 }
 
-// ExecuteWASMContract maneja la ejecución de un contrato WASM.
-func (s *Server) ExecuteWASMContract(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		ID    string `json:"id"`
-		Input []byte `json:"input"`
+// hasFunds verifica que la cuenta tenga saldo suficiente y responde con error si no.
+func (s *Server) hasFunds(w http.ResponseWriter, account string, amount int64) bool {
+	if amount <= 0 {
+		http.Error(w, "El monto debe ser mayor que cero", http.StatusBadRequest)
+		return false
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+	balance, err := s.DB.GetBalance(account)
+	if err != nil || balance < amount {
+		http.Error(w, "Saldo insuficiente", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// submitTransaction valida la autorización y los fondos del emisor y encola la transacción.
+func (s *Server) submitTransaction(w http.ResponseWriter, tx Transaction) {
+	if !s.authorized(w, tx) || !s.hasFunds(w, tx.From, tx.Amount) {
 		return
 	}
+	s.queueTransaction(w, tx)
+}
+
+// queueSettlement encola la transacción sin monto con la que la cuenta from reclama, aprueba o
+// cierra un objeto creado antes; el payload lo identifica.
+func (s *Server) queueSettlement(w http.ResponseWriter, from, txType string, payload interface{}, auth wallet.TxAuth) {
+	data, _ := json.Marshal(payload)
+	tx := Transaction{
+		From:      from,
+		To:        from,
+		Type:      txType,
+		Payload:   data,
+		Nonce:     auth.Nonce,
+		PublicKey: auth.PublicKey,
+		Signature: auth.Signature,
+	}
+	if s.authorized(w, tx) {
+		s.queueTransaction(w, tx)
+	}
+}
 
-	contract, err := s.DB.LoadWASMContract(payload.ID)
-	if err != nil || contract == nil {
-		http.Error(w, "Contrato WASM no encontrado", http.StatusNotFound)
+// listByAccount responde con los objetos que devuelve list para la cuenta y el estado de la
+// consulta: sin account, los de todas las cuentas; sin status, los de defaultStatus.
+func (s *Server) listByAccount(w http.ResponseWriter, r *http.Request, defaultStatus, name string, list func(account, status string) (interface{}, error)) {
+	account := r.URL.Query().Get("account")
+	if account != "" && !s.parseAddresses(w, &account) {
 		return
 	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = defaultStatus
+	}
 
-	result, err := contract.Execute(payload.Input)
+	items, err := list(account, status)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error ejecutando contrato WASM: %s", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error obteniendo %s", name), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"result": result,
+	json.NewEncoder(w).Encode(items)
+}
+
+// authorized verifica la firma y el nonce de la transacción y responde con error si no son válidos.
+func (s *Server) authorized(w http.ResponseWriter, tx Transaction) bool {
+	if err := s.DB.VerifyPendingTransactionAuth(tx); err != nil {
+		http.Error(w, fmt.Sprintf("Transacción no autorizada: %s", err), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// queueTransaction añade una transacción a la cola de pendientes y responde al cliente.
+func (s *Server) queueTransaction(w http.ResponseWriter, tx Transaction) {
+	if err := s.DB.AddPendingTx(tx); err != nil {
+		http.Error(w, "Error añadiendo transacción pendiente", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Transacción añadida a la cola",
+		"tx_hash": tx.Hash(),
 	})
 }
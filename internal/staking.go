@@ -0,0 +1,715 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// Tipos de transacción reconocidos por la cadena.
+const (
+	TxTypeTransfer         = ""
+	TxTypeBond             = "bond"
+	TxTypeDelegate         = "delegate"
+	TxTypeUnbond           = "unbond"
+	TxTypeEvidence         = "evidence"
+	TxTypeBatch            = wallet.TxTypeBatch
+	TxTypeTimelock         = "timelock"
+	TxTypeHTLCLock         = "htlc_lock"
+	TxTypeHTLCClaim        = "htlc_claim"
+	TxTypeHTLCRefund       = "htlc_refund"
+	TxTypeEscrowOpen       = "escrow_open"
+	TxTypeEscrowApprove    = "escrow_approve"
+	TxTypeEscrowCancel     = "escrow_cancel"
+	TxTypeEscrowResolve    = "escrow_resolve"
+	TxTypeChannelOpen      = "channel_open"
+	TxTypeChannelClose     = "channel_close"
+	TxTypeChannelChallenge = "channel_challenge"
+	TxTypeContractDeploy   = wallet.TxTypeContractDeploy
+	TxTypeContractCall     = "contract_call"
+)
+
+// StakingParams agrupa los parámetros del mecanismo de prueba de participación.
+type StakingParams struct {
+	EpochLength       int   `json:"epoch_length"`        // Bloques por época
+	UnbondingPeriod   int   `json:"unbonding_period"`    // Bloques hasta liberar fondos retirados
+	MaxValidators     int   `json:"max_validators"`      // Tamaño máximo del conjunto de validadores
+	MinValidatorStake int64 `json:"min_validator_stake"` // Participación mínima para entrar al conjunto
+	EpochReward       int64 `json:"epoch_reward"`        // Recompensa repartida al final de cada época
+	SlashPercent      int64 `json:"slash_percent"`       // Porcentaje recortado por doble firma
+}
+
+// DefaultStakingParams devuelve los parámetros de staking por defecto.
+func DefaultStakingParams() StakingParams {
+	return StakingParams{
+		EpochLength:       10,
+		UnbondingPeriod:   20,
+		MaxValidators:     21,
+		MinValidatorStake: 100,
+		EpochReward:       100,
+		SlashPercent:      5,
+	}
+}
+
+// BondPayload contiene los datos de registro de un validador.
+type BondPayload struct {
+	PublicKey string `json:"public_key"`
+}
+
+// BlockVote es la firma de un validador sobre un bloque a cierta altura.
+type BlockVote struct {
+	Height    int    `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Signature string `json:"signature"`
+}
+
+// DoubleSignEvidence prueba que un validador firmó dos bloques distintos a la misma altura.
+type DoubleSignEvidence struct {
+	Validator string    `json:"validator"`
+	VoteA     BlockVote `json:"vote_a"`
+	VoteB     BlockVote `json:"vote_b"`
+}
+
+// Stake representa la participación de un delegador en un validador.
+type Stake struct {
+	Delegator string `json:"delegator"`
+	Validator string `json:"validator"`
+	Amount    int64  `json:"amount"`
+}
+
+// Unbonding representa fondos retirados pendientes de liberación.
+type Unbonding struct {
+	Delegator     string `json:"delegator"`
+	Validator     string `json:"validator"`
+	Amount        int64  `json:"amount"`
+	ReleaseHeight int    `json:"release_height"`
+}
+
+// ValidatorPower representa a un validador del conjunto activo y su peso.
+type ValidatorPower struct {
+	Address string `json:"address"`
+	Power   int64  `json:"power"`
+}
+
+// voteDigest calcula el mensaje firmado por un validador para un bloque.
+func voteDigest(height int, blockHash string) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", height, blockHash)))
+	return digest[:]
+}
+
+// SignBlockVote firma un bloque con la clave de un validador, de cualquier esquema de firma.
+func SignBlockVote(key wallet.Key, height int, blockHash string) (BlockVote, error) {
+	signature, err := key.Sign(voteDigest(height, blockHash))
+	if err != nil {
+		return BlockVote{}, fmt.Errorf("error firmando el bloque: %w", err)
+	}
+
+	return BlockVote{
+		Height:    height,
+		BlockHash: blockHash,
+		Signature: signature,
+	}, nil
+}
+
+// Verify comprueba la firma del voto con la clave pública codificada del validador.
+func (v BlockVote) Verify(publicKey string) bool {
+	return wallet.Verify(publicKey, voteDigest(v.Height, v.BlockHash), v.Signature) == nil
+}
+
+// applyBond registra al emisor como validador y bloquea su participación propia.
+func (d *Database) applyBond(tx Transaction) error {
+	if !tx.IsSigned() {
+		return errors.New("el registro de un validador debe estar firmado")
+	}
+	var payload BondPayload
+	if err := json.Unmarshal(tx.Payload, &payload); err != nil {
+		return fmt.Errorf("datos de bond inválidos: %w", err)
+	}
+
+	publicKey, err := wallet.NormalizePublicKey(payload.PublicKey)
+	if err != nil {
+		return err
+	}
+	if address, _, _ := wallet.AddressFromPublicKey(publicKey); address != tx.From {
+		return errors.New("la clave pública no corresponde a la cuenta del validador")
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			"INSERT INTO validators (address, public_key) VALUES ($1, $2) ON CONFLICT (address) DO NOTHING",
+			tx.From, publicKey,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando validador: %w", err)
+		}
+
+		return state.addStake(tx.From, tx.From, tx.Amount)
+	})
+}
+
+// applyDelegate bloquea fondos del emisor delegados al validador destino.
+func (d *Database) applyDelegate(tx Transaction) error {
+	if !tx.IsSigned() {
+		return errors.New("la delegación debe estar firmada")
+	}
+	if _, err := d.GetValidatorPublicKey(tx.To); err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+		return state.addStake(tx.From, tx.To, tx.Amount)
+	})
+}
+
+// applyUnbond retira participación y la deja pendiente durante el periodo de desvinculación.
+func (d *Database) applyUnbond(tx Transaction, height int) error {
+	if !tx.IsSigned() {
+		return errors.New("el retiro de participación debe estar firmado")
+	}
+	if tx.Amount <= 0 {
+		return errors.New("el monto a retirar debe ser mayor que cero")
+	}
+
+	staked, err := d.GetStake(tx.From, tx.To)
+	if err != nil {
+		return err
+	}
+	if staked < tx.Amount {
+		return fmt.Errorf("participación insuficiente de %s en el validador %s", tx.From, tx.To)
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.addStake(tx.From, tx.To, -tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			"INSERT INTO unbondings (delegator, validator, amount, release_height) VALUES ($1, $2, $3, $4)",
+			tx.From, tx.To, tx.Amount, height+d.Staking.UnbondingPeriod,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando retiro de participación: %w", err)
+		}
+		return nil
+	})
+}
+
+// applyEvidence verifica una prueba de doble firma y penaliza al validador.
+func (d *Database) applyEvidence(tx Transaction, height int) error {
+	var evidence DoubleSignEvidence
+	if err := json.Unmarshal(tx.Payload, &evidence); err != nil {
+		return fmt.Errorf("evidencia inválida: %w", err)
+	}
+
+	if evidence.VoteA.Height != evidence.VoteB.Height {
+		return errors.New("los votos de la evidencia tienen alturas distintas")
+	}
+	if evidence.VoteA.BlockHash == evidence.VoteB.BlockHash {
+		return errors.New("los votos de la evidencia firman el mismo bloque")
+	}
+
+	publicKey, err := d.GetValidatorPublicKey(evidence.Validator)
+	if err != nil {
+		return err
+	}
+	if !evidence.VoteA.Verify(publicKey) || !evidence.VoteB.Verify(publicKey) {
+		return errors.New("las firmas de la evidencia no son válidas")
+	}
+
+	return d.slash(evidence.Validator, evidence.VoteA.Height, height, tx.From)
+}
+
+// slash recorta la participación delegada a un validador y lo excluye del conjunto activo.
+func (d *Database) slash(validator string, infractionHeight, height int, reporter string) error {
+	return d.atomically(func(state sqlState) error {
+		var exists bool
+		err := state.q.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM slashings WHERE validator = $1 AND height = $2)",
+			validator, infractionHeight,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error verificando penalizaciones previas: %w", err)
+		}
+		if exists {
+			return fmt.Errorf("el validador %s ya fue penalizado a la altura %d", validator, infractionHeight)
+		}
+
+		var slashed int64
+		err = state.q.QueryRow(
+			"SELECT COALESCE(SUM(amount * $2 / 100), 0) FROM stakes WHERE validator = $1",
+			validator, d.Staking.SlashPercent,
+		).Scan(&slashed)
+		if err != nil {
+			return fmt.Errorf("error calculando la penalización: %w", err)
+		}
+
+		_, err = state.q.Exec(
+			"UPDATE stakes SET amount = amount - amount * $2 / 100 WHERE validator = $1",
+			validator, d.Staking.SlashPercent,
+		)
+		if err != nil {
+			return fmt.Errorf("error recortando participación: %w", err)
+		}
+
+		// Los fondos aún en periodo de desvinculación también son penalizables.
+		_, err = state.q.Exec(
+			"UPDATE unbondings SET amount = amount - amount * $2 / 100 WHERE validator = $1 AND release_height > $3",
+			validator, d.Staking.SlashPercent, height,
+		)
+		if err != nil {
+			return fmt.Errorf("error recortando retiros pendientes: %w", err)
+		}
+
+		if _, err := state.q.Exec("UPDATE validators SET jailed = TRUE WHERE address = $1", validator); err != nil {
+			return fmt.Errorf("error excluyendo al validador: %w", err)
+		}
+
+		_, err = state.q.Exec(
+			"INSERT INTO slashings (validator, height, amount, reporter) VALUES ($1, $2, $3, $4)",
+			validator, infractionHeight, slashed, reporter,
+		)
+		if err != nil {
+			return fmt.Errorf("error registrando penalización: %w", err)
+		}
+
+		fmt.Printf("Validador %s penalizado por doble firma a la altura %d\n", validator, infractionHeight)
+		return nil
+	})
+}
+
+// addStake suma (o resta, si delta es negativo) participación de un delegador en un validador.
+func (s sqlState) addStake(delegator, validator string, delta int64) error {
+	_, err := s.q.Exec(
+		`INSERT INTO stakes (delegator, validator, amount) VALUES ($1, $2, $3)
+		ON CONFLICT (delegator, validator) DO UPDATE SET amount = stakes.amount + $3`,
+		delegator, validator, delta,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando participación: %w", err)
+	}
+
+	_, err = s.q.Exec("DELETE FROM stakes WHERE delegator = $1 AND validator = $2 AND amount <= 0", delegator, validator)
+	if err != nil {
+		return fmt.Errorf("error limpiando participación: %w", err)
+	}
+	return nil
+}
+
+// releaseUnbondings devuelve a sus dueños los retiros cuyo periodo ya venció.
+func (d *Database) releaseUnbondings(height int) error {
+	return d.atomically(func(state sqlState) error {
+		rows, err := state.q.Query(
+			"DELETE FROM unbondings WHERE release_height <= $1 RETURNING delegator, amount",
+			height,
+		)
+		if err != nil {
+			return fmt.Errorf("error liberando retiros vencidos: %w", err)
+		}
+
+		type release struct {
+			delegator string
+			amount    int64
+		}
+		var releases []release
+		for rows.Next() {
+			var r release
+			if err := rows.Scan(&r.delegator, &r.amount); err != nil {
+				rows.Close()
+				return fmt.Errorf("error al escanear retiro: %w", err)
+			}
+			releases = append(releases, r)
+		}
+		rows.Close()
+
+		for _, r := range releases {
+			if err := state.credit(r.delegator, r.amount); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// distributeRewards reparte la recompensa de la época entre los delegadores del conjunto activo
+// en proporción a su participación.
+func (d *Database) distributeRewards(epoch int) error {
+	validators, err := d.GetValidatorSet(epoch)
+	if err != nil {
+		return err
+	}
+	if len(validators) == 0 || d.Staking.EpochReward <= 0 {
+		return nil
+	}
+
+	stakes, err := d.loadActiveStakes(epoch)
+	if err != nil {
+		return err
+	}
+
+	total := big.NewInt(0)
+	for _, st := range stakes {
+		total.Add(total, big.NewInt(st.Amount))
+	}
+	if total.Sign() == 0 {
+		return nil
+	}
+
+	reward := big.NewInt(d.Staking.EpochReward)
+	err = d.atomically(func(state sqlState) error {
+		for _, st := range stakes {
+			share := new(big.Int).Mul(reward, big.NewInt(st.Amount))
+			share.Div(share, total)
+			if share.Sign() == 0 {
+				continue
+			}
+			if err := state.credit(st.Delegator, share.Int64()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recompensas de la época %d distribuidas entre %d participaciones\n", epoch, len(stakes))
+	return nil
+}
+
+// loadActiveStakes carga las participaciones delegadas a validadores del conjunto de la época.
+func (d *Database) loadActiveStakes(epoch int) ([]Stake, error) {
+	rows, err := d.Connection.Query(
+		`SELECT s.delegator, s.validator, s.amount FROM stakes s
+		JOIN validator_set vs ON vs.validator = s.validator AND vs.epoch = $1
+		JOIN validators v ON v.address = s.validator AND NOT v.jailed
+		ORDER BY s.delegator, s.validator`,
+		epoch,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo participaciones activas: %w", err)
+	}
+	defer rows.Close()
+
+	var stakes []Stake
+	for rows.Next() {
+		var st Stake
+		if err := rows.Scan(&st.Delegator, &st.Validator, &st.Amount); err != nil {
+			return nil, fmt.Errorf("error al escanear participación: %w", err)
+		}
+		stakes = append(stakes, st)
+	}
+	return stakes, nil
+}
+
+// RecomputeValidatorSet calcula el conjunto de validadores de una época ponderado por participación.
+func (d *Database) RecomputeValidatorSet(epoch int) error {
+	_, err := d.Connection.Exec(
+		`INSERT INTO validator_set (epoch, validator, power)
+		SELECT $1::INTEGER, s.validator, SUM(s.amount) FROM stakes s
+		JOIN validators v ON v.address = s.validator
+		WHERE NOT v.jailed
+		GROUP BY s.validator
+		HAVING SUM(s.amount) >= $2
+		ORDER BY SUM(s.amount) DESC, s.validator ASC
+		LIMIT $3
+		ON CONFLICT (epoch, validator) DO NOTHING`,
+		epoch, d.Staking.MinValidatorStake, d.Staking.MaxValidators,
+	)
+	if err != nil {
+		return fmt.Errorf("error calculando el conjunto de validadores: %w", err)
+	}
+
+	fmt.Printf("Conjunto de validadores de la época %d calculado\n", epoch)
+	return nil
+}
+
+// GetValidatorSet obtiene el conjunto de validadores de una época ordenado por peso.
+func (d *Database) GetValidatorSet(epoch int) ([]ValidatorPower, error) {
+	rows, err := d.Connection.Query(
+		"SELECT validator, power FROM validator_set WHERE epoch = $1 ORDER BY power DESC, validator ASC",
+		epoch,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo el conjunto de validadores: %w", err)
+	}
+	defer rows.Close()
+
+	validators := []ValidatorPower{}
+	for rows.Next() {
+		var v ValidatorPower
+		if err := rows.Scan(&v.Address, &v.Power); err != nil {
+			return nil, fmt.Errorf("error al escanear validador: %w", err)
+		}
+		validators = append(validators, v)
+	}
+	return validators, nil
+}
+
+// GetLatestValidatorSet obtiene el conjunto de validadores de la época más reciente.
+func (d *Database) GetLatestValidatorSet() (int, []ValidatorPower, error) {
+	var epoch int
+	err := d.Connection.QueryRow("SELECT COALESCE(MAX(epoch), 0) FROM validator_set").Scan(&epoch)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error obteniendo la época actual: %w", err)
+	}
+
+	validators, err := d.GetValidatorSet(epoch)
+	return epoch, validators, err
+}
+
+// GetValidatorPublicKey obtiene la clave pública registrada de un validador.
+func (d *Database) GetValidatorPublicKey(address string) (string, error) {
+	var publicKey string
+	err := d.Connection.QueryRow("SELECT public_key FROM validators WHERE address = $1", address).Scan(&publicKey)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("el validador %s no está registrado", address)
+	} else if err != nil {
+		return "", fmt.Errorf("error obteniendo validador: %w", err)
+	}
+	return publicKey, nil
+}
+
+// GetStake obtiene la participación de un delegador en un validador.
+func (d *Database) GetStake(delegator, validator string) (int64, error) {
+	var amount int64
+	err := d.Connection.QueryRow(
+		"SELECT amount FROM stakes WHERE delegator = $1 AND validator = $2",
+		delegator, validator,
+	).Scan(&amount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return amount, err
+}
+
+// GetStakes obtiene las participaciones y retiros pendientes de una cuenta.
+func (d *Database) GetStakes(account string) ([]Stake, []Unbonding, error) {
+	rows, err := d.Connection.Query(
+		"SELECT delegator, validator, amount FROM stakes WHERE delegator = $1 ORDER BY validator",
+		account,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error obteniendo participaciones: %w", err)
+	}
+	defer rows.Close()
+
+	stakes := []Stake{}
+	for rows.Next() {
+		var st Stake
+		if err := rows.Scan(&st.Delegator, &st.Validator, &st.Amount); err != nil {
+			return nil, nil, fmt.Errorf("error al escanear participación: %w", err)
+		}
+		stakes = append(stakes, st)
+	}
+
+	unbondRows, err := d.Connection.Query(
+		"SELECT delegator, validator, amount, release_height FROM unbondings WHERE delegator = $1 ORDER BY id",
+		account,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error obteniendo retiros pendientes: %w", err)
+	}
+	defer unbondRows.Close()
+
+	unbondings := []Unbonding{}
+	for unbondRows.Next() {
+		var u Unbonding
+		if err := unbondRows.Scan(&u.Delegator, &u.Validator, &u.Amount, &u.ReleaseHeight); err != nil {
+			return nil, nil, fmt.Errorf("error al escanear retiro: %w", err)
+		}
+		unbondings = append(unbondings, u)
+	}
+	return stakes, unbondings, nil
+}
+
+// BondHandler maneja la solicitud para registrarse como validador.
+func (s *Server) BondHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Validator string `json:"validator"`
+		Amount    int64  `json:"amount"`
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.Validator) {
+		return
+	}
+
+	// La clave que firma la transacción es la que queda registrada para firmar bloques.
+	if address, _, err := wallet.AddressFromPublicKey(payload.PublicKey); err != nil || address != payload.Validator {
+		http.Error(w, "La clave pública no corresponde al validador", http.StatusBadRequest)
+		return
+	}
+
+	data, _ := json.Marshal(BondPayload{PublicKey: payload.PublicKey})
+	s.submitTransaction(w, Transaction{
+		From:      payload.Validator,
+		To:        payload.Validator,
+		Amount:    payload.Amount,
+		Type:      TxTypeBond,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// DelegateHandler maneja la solicitud para delegar participación a un validador.
+func (s *Server) DelegateHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Delegator string `json:"delegator"`
+		Validator string `json:"validator"`
+		Amount    int64  `json:"amount"`
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.Delegator, &payload.Validator) {
+		return
+	}
+
+	if _, err := s.DB.GetValidatorPublicKey(payload.Validator); err != nil {
+		http.Error(w, "El validador no existe", http.StatusBadRequest)
+		return
+	}
+
+	s.submitTransaction(w, Transaction{
+		From:      payload.Delegator,
+		To:        payload.Validator,
+		Amount:    payload.Amount,
+		Type:      TxTypeDelegate,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// UnbondHandler maneja la solicitud para retirar participación de un validador.
+func (s *Server) UnbondHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Delegator string `json:"delegator"`
+		Validator string `json:"validator"`
+		Amount    int64  `json:"amount"`
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.Delegator, &payload.Validator) {
+		return
+	}
+
+	if payload.Amount <= 0 {
+		http.Error(w, "El monto debe ser mayor que cero", http.StatusBadRequest)
+		return
+	}
+
+	staked, err := s.DB.GetStake(payload.Delegator, payload.Validator)
+	if err != nil || staked < payload.Amount {
+		http.Error(w, "Participación insuficiente", http.StatusBadRequest)
+		return
+	}
+
+	tx := Transaction{
+		From:      payload.Delegator,
+		To:        payload.Validator,
+		Amount:    payload.Amount,
+		Type:      TxTypeUnbond,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	}
+	if s.authorized(w, tx) {
+		s.queueTransaction(w, tx)
+	}
+}
+
+// EvidenceHandler maneja el envío de evidencia de doble firma de un validador.
+func (s *Server) EvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Reporter string             `json:"reporter"`
+		Evidence DoubleSignEvidence `json:"evidence"`
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.Reporter, &payload.Evidence.Validator) {
+		return
+	}
+
+	data, _ := json.Marshal(payload.Evidence)
+	tx := Transaction{
+		From:      payload.Reporter,
+		To:        payload.Evidence.Validator,
+		Type:      TxTypeEvidence,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	}
+	if s.authorized(w, tx) {
+		s.queueTransaction(w, tx)
+	}
+}
+
+// GetValidators maneja la solicitud para obtener el conjunto de validadores actual.
+func (s *Server) GetValidators(w http.ResponseWriter, r *http.Request) {
+	epoch, validators, err := s.DB.GetLatestValidatorSet()
+	if err != nil {
+		http.Error(w, "Error obteniendo validadores", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"epoch":      epoch,
+		"validators": validators,
+	})
+}
+
+// GetStakes maneja la solicitud para obtener las participaciones de una cuenta.
+func (s *Server) GetStakes(w http.ResponseWriter, r *http.Request) {
+	account := mux.Vars(r)["account"]
+	if !s.parseAddresses(w, &account) {
+		return
+	}
+
+	stakes, unbondings, err := s.DB.GetStakes(account)
+	if err != nil {
+		http.Error(w, "Error obteniendo participaciones", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"account":    s.DB.FormatAddress(account),
+		"stakes":     stakes,
+		"unbondings": unbondings,
+	})
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// BlockContext describe el bloque en el que se aplican las transacciones. Las condiciones
+// temporales se evalúan contra la marca de tiempo del bloque, no contra el reloj del nodo,
+// para que todos los nodos obtengan el mismo estado.
+type BlockContext struct {
+	Height int
+	Time   time.Time
+}
+
+// ApplyBlock aplica sobre el estado todas las transacciones de un bloque nuevo y lo sella: fija
+// su raíz de almacenamiento y recalcula su hash, que la incluye. El bloque se guarda después.
+func (d *Database) ApplyBlock(block *Block) error {
+	ctx := BlockContext{Height: block.Index, Time: block.Time()}
+	for _, tx := range block.Transactions {
+		if err := d.ApplyTransaction(tx, ctx); err != nil {
+			fmt.Printf("Error al aplicar transacción de %s en el bloque %d: %s\n", tx.From, block.Index, err)
+			continue
+		}
+		if tx.Type == TxTypeContractDeploy {
+			if err := d.recordDeployment(block, tx.To); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := d.EndBlock(ctx); err != nil {
+		return fmt.Errorf("error al finalizar el bloque %d: %w", block.Index, err)
+	}
+
+	root, err := d.StorageRoot()
+	if err != nil {
+		return err
+	}
+	block.StorageRoot = root
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+// ApplyTransaction aplica una transacción según su tipo.
+func (d *Database) ApplyTransaction(tx Transaction, ctx BlockContext) error {
+	if err := d.VerifyTransactionAuth(tx); err != nil {
+		return err
+	}
+
+	// El nonce se consume aunque la transacción falle, para que no pueda repetirse.
+	if tx.IsSigned() {
+		if err := d.incrementNonce(tx.From); err != nil {
+			return err
+		}
+		if tx.PublicKey != "" {
+			if err := d.SaveAccountKey(tx.From, tx.PublicKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch tx.Type {
+	case TxTypeTransfer:
+		return d.UpdateBalances(tx.From, tx.To, tx.Amount)
+	case TxTypeBond:
+		return d.applyBond(tx)
+	case TxTypeDelegate:
+		return d.applyDelegate(tx)
+	case TxTypeUnbond:
+		return d.applyUnbond(tx, ctx.Height)
+	case TxTypeEvidence:
+		return d.applyEvidence(tx, ctx.Height)
+	case TxTypeBatch:
+		return d.applyBatch(tx, ctx)
+	case TxTypeTimelock:
+		return d.applyTimelock(tx, ctx)
+	case TxTypeHTLCLock:
+		return d.applyHTLCLock(tx, ctx)
+	case TxTypeHTLCClaim:
+		return d.applyHTLCClaim(tx, ctx)
+	case TxTypeHTLCRefund:
+		return d.applyHTLCRefund(tx, ctx)
+	case TxTypeEscrowOpen:
+		return d.applyEscrowOpen(tx, ctx)
+	case TxTypeEscrowApprove, TxTypeEscrowCancel, TxTypeEscrowResolve:
+		return d.applyEscrowSettlement(tx, ctx)
+	case TxTypeChannelOpen:
+		return d.applyChannelOpen(tx, ctx)
+	case TxTypeChannelClose, TxTypeChannelChallenge:
+		return d.applyChannelClose(tx, ctx)
+	case TxTypeContractDeploy:
+		return d.applyContractDeploy(tx)
+	case TxTypeContractCall:
+		return d.applyContractCall(tx, ctx)
+	default:
+		return fmt.Errorf("tipo de transacción desconocido: %s", tx.Type)
+	}
+}
+
+// EndBlock ejecuta las tareas periódicas asociadas a la altura del bloque.
+func (d *Database) EndBlock(ctx BlockContext) error {
+	height := ctx.Height
+	if err := d.releaseUnbondings(height); err != nil {
+		return err
+	}
+	if err := d.releaseLockedFunds(ctx); err != nil {
+		return err
+	}
+	if err := d.expireEscrows(ctx); err != nil {
+		return err
+	}
+	if err := d.settleChallengedChannels(ctx); err != nil {
+		return err
+	}
+
+	if height > 0 && height%d.Staking.EpochLength == 0 {
+		epoch := height / d.Staking.EpochLength
+		if err := d.distributeRewards(epoch - 1); err != nil {
+			return err
+		}
+		if err := d.RecomputeValidatorSet(epoch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// atomically ejecuta fn sobre una transacción SQL y confirma sus efectos solo si termina sin
+// error, como applyBatch y applyContractCall.
+func (d *Database) atomically(fn func(state sqlState) error) error {
+	dbTx, err := d.Connection.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción de base de datos: %w", err)
+	}
+	defer dbTx.Rollback()
+
+	if err := fn(sqlState{dbTx}); err != nil {
+		return err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return fmt.Errorf("error confirmando transacción de base de datos: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"blockchain-go/wallet"
+
+	"github.com/gorilla/mux"
+)
+
+// LockCondition indica cuándo se liberan los fondos de una transferencia bloqueada: al
+// alcanzar una altura de bloque o una marca de tiempo (segundos Unix), exactamente una de ellas.
+type LockCondition struct {
+	UnlockHeight int   `json:"unlock_height,omitempty"`
+	UnlockTime   int64 `json:"unlock_time,omitempty"`
+}
+
+// LockedFunds son fondos debitados al emisor y retenidos hasta cumplirse su condición.
+type LockedFunds struct {
+	TxHash       string `json:"tx_hash"`
+	Sender       string `json:"sender"`
+	Beneficiary  string `json:"beneficiary"`
+	Amount       int64  `json:"amount"`
+	UnlockHeight int    `json:"unlock_height,omitempty"`
+	UnlockTime   int64  `json:"unlock_time,omitempty"`
+	LockedHeight int    `json:"locked_height"`
+}
+
+// Validate comprueba que la condición sea única y todavía no se cumpla en el bloque dado.
+func (c LockCondition) Validate(ctx BlockContext) error {
+	if (c.UnlockHeight > 0) == (c.UnlockTime > 0) {
+		return errors.New("indique exactamente una condición: unlock_height o unlock_time")
+	}
+	if c.Unlocked(ctx) {
+		return errors.New("la condición de desbloqueo ya se cumplió")
+	}
+	return nil
+}
+
+// Unlocked indica si la condición se cumple en el bloque dado.
+func (c LockCondition) Unlocked(ctx BlockContext) bool {
+	if c.UnlockHeight > 0 {
+		return ctx.Height >= c.UnlockHeight
+	}
+	return ctx.Time.Unix() >= c.UnlockTime
+}
+
+// applyTimelock debita al emisor y retiene los fondos para el destinatario hasta el desbloqueo.
+func (d *Database) applyTimelock(tx Transaction, ctx BlockContext) error {
+	hash, err := signedTxID(tx)
+	if err != nil {
+		return err
+	}
+	var lock LockCondition
+	if err := json.Unmarshal(tx.Payload, &lock); err != nil {
+		return fmt.Errorf("condición de bloqueo inválida: %w", err)
+	}
+	if err := lock.Validate(ctx); err != nil {
+		return err
+	}
+
+	return d.atomically(func(state sqlState) error {
+		if err := state.debit(tx.From, tx.Amount); err != nil {
+			return err
+		}
+
+		_, err := state.q.Exec(
+			`INSERT INTO locked_funds (tx_hash, sender, beneficiary, amount, unlock_height, unlock_time, locked_height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			hash, tx.From, tx.To, tx.Amount, lock.UnlockHeight, lock.UnlockTime, ctx.Height,
+		)
+		if err != nil {
+			return fmt.Errorf("error guardando fondos bloqueados: %w", err)
+		}
+
+		fmt.Printf("Fondos bloqueados: %d de %s para %s\n", tx.Amount, tx.From, tx.To)
+		return nil
+	})
+}
+
+// releaseLockedFunds acredita a sus destinatarios los fondos cuya condición se cumple en el
+// bloque. Los fondos solo dejan de estar retenidos si todos los créditos se aplican.
+func (d *Database) releaseLockedFunds(ctx BlockContext) error {
+	return d.atomically(func(state sqlState) error {
+		rows, err := state.q.Query(
+			`DELETE FROM locked_funds
+			WHERE (unlock_height > 0 AND unlock_height <= $1) OR (unlock_time > 0 AND unlock_time <= $2)
+			RETURNING tx_hash, sender, beneficiary, amount`,
+			ctx.Height, ctx.Time.Unix(),
+		)
+		if err != nil {
+			return fmt.Errorf("error liberando fondos bloqueados: %w", err)
+		}
+
+		var released []LockedFunds
+		for rows.Next() {
+			var lock LockedFunds
+			if err := rows.Scan(&lock.TxHash, &lock.Sender, &lock.Beneficiary, &lock.Amount); err != nil {
+				rows.Close()
+				return fmt.Errorf("error al escanear fondos bloqueados: %w", err)
+			}
+			released = append(released, lock)
+		}
+		rows.Close()
+
+		for _, lock := range released {
+			if err := state.credit(lock.Beneficiary, lock.Amount); err != nil {
+				return err
+			}
+			err := state.recordHistory(HistoryEntry{
+				From:      lock.Sender,
+				To:        lock.Beneficiary,
+				Amount:    lock.Amount,
+				Type:      TxTypeTimelock,
+				TxHash:    lock.TxHash,
+				Timestamp: ctx.Time,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Fondos liberados: %d para %s\n", lock.Amount, lock.Beneficiary)
+		}
+		return nil
+	})
+}
+
+// GetLockedFunds obtiene los fondos bloqueados que recibirá una cuenta.
+func (d *Database) GetLockedFunds(account string) ([]LockedFunds, error) {
+	rows, err := d.Connection.Query(
+		`SELECT tx_hash, sender, beneficiary, amount, unlock_height, unlock_time, locked_height
+		FROM locked_funds WHERE beneficiary = $1 ORDER BY id ASC`,
+		account,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo fondos bloqueados: %w", err)
+	}
+	defer rows.Close()
+
+	locks := []LockedFunds{}
+	for rows.Next() {
+		var lock LockedFunds
+		if err := rows.Scan(&lock.TxHash, &lock.Sender, &lock.Beneficiary, &lock.Amount,
+			&lock.UnlockHeight, &lock.UnlockTime, &lock.LockedHeight); err != nil {
+			return nil, fmt.Errorf("error al escanear fondos bloqueados: %w", err)
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// TimelockHandler maneja el envío de una transferencia que se libera en una altura o fecha futura.
+func (s *Server) TimelockHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Amount int64  `json:"amount"`
+		LockCondition
+		wallet.TxAuth
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Solicitud inválida", http.StatusBadRequest)
+		return
+	}
+	if !s.parseAddresses(w, &payload.From, &payload.To) {
+		return
+	}
+
+	// El bloque que incluya la transferencia tendrá al menos la siguiente altura.
+	if err := payload.LockCondition.Validate(s.nextBlock()); err != nil {
+		http.Error(w, fmt.Sprintf("Condición de bloqueo inválida: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	data, _ := json.Marshal(payload.LockCondition)
+	s.submitTransaction(w, Transaction{
+		From:      payload.From,
+		To:        payload.To,
+		Amount:    payload.Amount,
+		Type:      TxTypeTimelock,
+		Payload:   data,
+		Nonce:     payload.Nonce,
+		PublicKey: payload.PublicKey,
+		Signature: payload.Signature,
+	})
+}
+
+// GetAccountBalance maneja la consulta del saldo disponible y bloqueado de una cuenta.
+func (s *Server) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	if !s.parseAddresses(w, &address) {
+		return
+	}
+
+	spendable, err := s.DB.GetBalance(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo el saldo", http.StatusInternalServerError)
+		return
+	}
+
+	locks, err := s.DB.GetLockedFunds(address)
+	if err != nil {
+		http.Error(w, "Error obteniendo fondos bloqueados", http.StatusInternalServerError)
+		return
+	}
+
+	var locked int64
+	for _, lock := range locks {
+		locked += lock.Amount
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":   s.DB.FormatAddress(address),
+		"spendable": spendable,
+		"locked":    locked,
+		"total":     spendable + locked,
+		"locks":     locks,
+	})
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"blockchain-go/wallet"
+)
+
+// canonicalPayload normaliza los datos de una transacción antes de calcular hashes.
+func canonicalPayload(payload json.RawMessage) json.RawMessage {
+	return wallet.CanonicalPayload(payload)
+}
+
+// SigningHash calcula el mensaje que firma el emisor de la transacción.
+func (tx Transaction) SigningHash() []byte {
+	return wallet.SigningHash(tx.From, tx.To, tx.Amount, tx.Type, tx.Payload, tx.Nonce)
+}
+
+// Hash calcula el identificador de la transacción, incluyendo su firma.
+func (tx Transaction) Hash() string {
+	h := sha256.New()
+	h.Write(tx.SigningHash())
+	h.Write([]byte(tx.PublicKey + tx.Signature))
+	for _, sig := range tx.Signatures {
+		h.Write([]byte(sig.PublicKey + sig.Signature))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsSigned indica si la transacción incluye firma.
+func (tx Transaction) IsSigned() bool {
+	return tx.Signature != "" || len(tx.Signatures) > 0
+}
+
+// signedTxID devuelve el hash de una transacción firmada, que identifica el objeto que crea
+// (un HTLC, un depósito, un canal o un recibo). La firma y el nonce garantizan que ese hash sea
+// único; dos transacciones sin firma con los mismos datos tendrían el mismo.
+func signedTxID(tx Transaction) (string, error) {
+	if !tx.IsSigned() {
+		return "", fmt.Errorf("las transacciones %s requieren firma", tx.Type)
+	}
+	return tx.Hash(), nil
+}
+
+// SignTransaction firma la transacción con la clave privada del emisor (P-256 o Ed25519).
+func SignTransaction(tx *Transaction, key wallet.Key) error {
+	if key.Address() != tx.From {
+		return errors.New("la clave privada no corresponde a la cuenta emisora")
+	}
+
+	signature, err := key.Sign(tx.SigningHash())
+	if err != nil {
+		return fmt.Errorf("error firmando la transacción: %w", err)
+	}
+
+	tx.PublicKey = key.PublicKey()
+	tx.Signature = signature
+	return nil
+}
+
+// VerifySignature comprueba que la transacción fue firmada por la cuenta emisora, con el
+// esquema de firma que indica la etiqueta de su clave pública.
+func (tx Transaction) VerifySignature() error {
+	address, _, err := wallet.AddressFromPublicKey(tx.PublicKey)
+	if err != nil {
+		return err
+	}
+	if address != tx.From {
+		return errors.New("la clave pública no corresponde a la cuenta emisora")
+	}
+
+	if err := wallet.Verify(tx.PublicKey, tx.SigningHash(), tx.Signature); err != nil {
+		return fmt.Errorf("firma de la transacción inválida: %w", err)
+	}
+	return nil
+}
+
+// VerifyTransactionAuth valida la firma y el nonce de una transacción contra el estado actual,
+// al aplicarla: el nonce debe ser exactamente el siguiente de la cuenta. Las transacciones sin
+// firma solo se aceptan mientras la red no exija firmas y la cuenta emisora nunca haya enviado
+// una transacción firmada. Las cuentas multifirma siempre requieren las firmas que exige su
+// política.
+func (d *Database) VerifyTransactionAuth(tx Transaction) error {
+	nonce, err := d.GetNonce(tx.From)
+	if err != nil {
+		return err
+	}
+	return d.verifyAuth(tx, nonce, nonce+1)
+}
+
+// VerifyPendingTransactionAuth valida una transacción al recibirla. Su nonce puede superar al
+// de la cuenta en la cadena si las anteriores siguen pendientes, pero debe ser el siguiente de
+// la cola para que el bloque pueda aplicarlas en orden.
+func (d *Database) VerifyPendingTransactionAuth(tx Transaction) error {
+	nonce, err := d.GetNonce(tx.From)
+	if err != nil {
+		return err
+	}
+	pending, err := d.GetPendingNonce(tx.From)
+	if err != nil {
+		return err
+	}
+	return d.verifyAuth(tx, nonce, pending+1)
+}
+
+// verifyAuth comprueba las firmas de la transacción y que use el nonce esperado; nonce es el
+// de la cuenta en la cadena.
+func (d *Database) verifyAuth(tx Transaction, nonce, expected uint64) error {
+	policy, err := d.GetMultisigPolicy(tx.From)
+	if err != nil {
+		return err
+	}
+	if policy != nil {
+		if err := policy.VerifySignatures(tx.SigningHash(), tx.Signatures); err != nil {
+			return err
+		}
+		return checkNonce(tx, expected)
+	}
+	if len(tx.Signatures) > 0 {
+		return fmt.Errorf("la cuenta %s no es multifirma", tx.From)
+	}
+
+	// Sin firma solo se aceptan, si el nodo lo permite, transferencias simples de cuentas que
+	// nunca firmaron.
+	if !tx.IsSigned() {
+		if !d.AllowUnsigned || nonce > 0 || tx.Type != TxTypeTransfer {
+			return fmt.Errorf("la cuenta %s requiere transacciones firmadas", tx.From)
+		}
+		return nil
+	}
+
+	if err := tx.VerifySignature(); err != nil {
+		return err
+	}
+	return checkNonce(tx, expected)
+}
+
+// checkNonce comprueba que la transacción use el nonce esperado.
+func checkNonce(tx Transaction, expected uint64) error {
+	if tx.Nonce != expected {
+		return fmt.Errorf("nonce inválido para %s: se esperaba %d, se recibió %d", tx.From, expected, tx.Nonce)
+	}
+	return nil
+}
+
+// GetNonce obtiene el número de transacciones firmadas aplicadas para una cuenta.
+func (d *Database) GetNonce(account string) (uint64, error) {
+	var nonce uint64
+	err := d.Connection.QueryRow("SELECT COALESCE(MAX(nonce), 0) FROM balances WHERE account = $1", account).Scan(&nonce)
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo nonce de %s: %w", account, err)
+	}
+	return nonce, nil
+}
+
+// GetPendingNonce obtiene el último nonce de la cuenta contando las transacciones pendientes.
+func (d *Database) GetPendingNonce(account string) (uint64, error) {
+	var nonce uint64
+	err := d.Connection.QueryRow(
+		`SELECT GREATEST(
+			(SELECT COALESCE(MAX(nonce), 0) FROM balances WHERE account = $1),
+			(SELECT COALESCE(MAX(nonce), 0) FROM pending_transactions WHERE from_account = $1))`,
+		account,
+	).Scan(&nonce)
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo nonce pendiente de %s: %w", account, err)
+	}
+	return nonce, nil
+}
+
+// incrementNonce registra que la cuenta aplicó una nueva transacción firmada.
+func (d *Database) incrementNonce(account string) error {
+	_, err := d.Connection.Exec(
+		"INSERT INTO balances (account, balance, nonce) VALUES ($1, 0, 1) ON CONFLICT (account) DO UPDATE SET nonce = balances.nonce + 1",
+		account,
+	)
+	if err != nil {
+		return fmt.Errorf("error actualizando nonce de %s: %w", account, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// DefaultModuleCacheSize es el número de módulos compilados que se conservan en memoria.
+const DefaultModuleCacheSize = 64
+
+// moduleArtifactVersion forma parte del nombre de los artefactos en disco. Debe incrementarse
+// al cambiar la instrumentación de gas, para no cargar módulos compilados con otros costos.
+const moduleArtifactVersion = 3
+
+// ModuleCacheConfig configura el caché de módulos WASM compilados.
+type ModuleCacheConfig struct {
+	Size        int    `yaml:"module_cache_size"` // Módulos compilados conservados en memoria
+	ArtifactDir string `yaml:"artifact_dir"`      // Directorio de módulos compilados en disco; vacío para desactivar
+}
+
+// ModuleCache conserva los módulos WASM ya instrumentados y compilados, indexados por el hash
+// del código, con desalojo del menos usado recientemente. Todos los módulos comparten el motor
+// y el store del nodo. Si se indica un directorio, los módulos compilados también se guardan en
+// disco y sobreviven a un reinicio; ese directorio debe ser de confianza, porque un artefacto
+// se carga como código nativo sin volver a validarse.
+type ModuleCache struct {
+	store       *wasmer.Store
+	capacity    int
+	artifactDir string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Frente: usado más recientemente
+	stats   ModuleCacheStats
+}
+
+// cachedModule es una entrada del caché.
+type cachedModule struct {
+	hash   string
+	module *wasmer.Module
+}
+
+// ModuleCacheStats son las métricas del caché de módulos.
+type ModuleCacheStats struct {
+	Hits         uint64  `json:"hits"`          // Módulos encontrados en memoria
+	DiskHits     uint64  `json:"disk_hits"`     // Módulos cargados de un artefacto en disco
+	Misses       uint64  `json:"misses"`        // Módulos que hubo que compilar
+	Evictions    uint64  `json:"evictions"`     // Módulos desalojados de la memoria
+	DiskFailures uint64  `json:"disk_failures"` // Artefactos que no se pudieron leer o escribir
+	Size         int     `json:"size"`
+	Capacity     int     `json:"capacity"`
+	HitRate      float64 `json:"hit_rate"` // Fracción de búsquedas resueltas sin compilar
+}
+
+// NewModuleCache crea un caché con la capacidad dada y, si artifactDir no está vacío, con
+// persistencia de los módulos compilados en ese directorio.
+func NewModuleCache(capacity int, artifactDir string) (*ModuleCache, error) {
+	if capacity <= 0 {
+		capacity = DefaultModuleCacheSize
+	}
+	if artifactDir != "" {
+		if err := os.MkdirAll(artifactDir, 0o700); err != nil {
+			return nil, fmt.Errorf("error creando el directorio de artefactos WASM: %w", err)
+		}
+	}
+
+	return &ModuleCache{
+		store:       wasmer.NewStore(wasmer.NewEngine()),
+		capacity:    capacity,
+		artifactDir: artifactDir,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}, nil
+}
+
+// contractModules es el caché de módulos del nodo. main lo reemplaza con ConfigureModuleCache
+// según la configuración.
+var contractModules, _ = NewModuleCache(DefaultModuleCacheSize, "")
+
+// ConfigureModuleCache reemplaza el caché de módulos del nodo. Debe llamarse al iniciar, antes
+// de ejecutar contratos.
+func ConfigureModuleCache(config ModuleCacheConfig) error {
+	cache, err := NewModuleCache(config.Size, config.ArtifactDir)
+	if err != nil {
+		return err
+	}
+	contractModules = cache
+	return nil
+}
+
+// ContractModuleStats devuelve las métricas del caché de módulos del nodo.
+func ContractModuleStats() ModuleCacheStats {
+	return contractModules.Stats()
+}
+
+// Load devuelve el módulo compilado del código dado, junto con el store en el que debe
+// instanciarse. Busca primero en memoria, luego en disco, y si no lo encuentra instrumenta y
+// compila el código.
+func (c *ModuleCache) Load(code []byte) (*wasmer.Module, *wasmer.Store, error) {
+	sum := sha256.Sum256(code)
+	hash := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	if element, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(element)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return element.Value.(*cachedModule).module, c.store, nil
+	}
+	c.mu.Unlock()
+
+	// La compilación ocurre fuera del candado; si dos llamadas compilan el mismo módulo a la
+	// vez, se conserva el primero que termine.
+	module, fromDisk := c.loadArtifact(hash)
+	if module == nil {
+		// El módulo original debe ser válido por sí mismo: la instrumentación agrega globales
+		// que un módulo inválido podría estar referenciando.
+		if err := wasmer.ValidateModule(c.store, code); err != nil {
+			return nil, nil, fmt.Errorf("módulo WASM inválido: %w", err)
+		}
+		instrumented, err := InstrumentGas(code)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error al instrumentar el contrato WASM: %w", err)
+		}
+		module, err = wasmer.NewModule(c.store, instrumented)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error al compilar el contrato WASM: %w", err)
+		}
+		c.saveArtifact(hash, module)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fromDisk {
+		c.stats.DiskHits++
+	} else {
+		c.stats.Misses++
+	}
+	if element, ok := c.entries[hash]; ok {
+		c.lru.MoveToFront(element)
+		return element.Value.(*cachedModule).module, c.store, nil
+	}
+	c.entries[hash] = c.lru.PushFront(&cachedModule{hash: hash, module: module})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedModule).hash)
+		c.stats.Evictions++
+	}
+	return module, c.store, nil
+}
+
+// artifactPath devuelve la ruta del artefacto en disco de un módulo.
+func (c *ModuleCache) artifactPath(hash string) string {
+	return filepath.Join(c.artifactDir, fmt.Sprintf("%s.v%d.wasmu", hash, moduleArtifactVersion))
+}
+
+// loadArtifact carga un módulo compilado desde disco, si la persistencia está activa y existe.
+func (c *ModuleCache) loadArtifact(hash string) (*wasmer.Module, bool) {
+	if c.artifactDir == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.artifactPath(hash))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.diskFailure("Error leyendo el artefacto WASM %s: %s\n", hash, err)
+		}
+		return nil, false
+	}
+	module, err := wasmer.DeserializeModule(c.store, data)
+	if err != nil {
+		// Por ejemplo, un artefacto de otra versión del motor: se recompila y se reemplaza.
+		c.diskFailure("Error cargando el artefacto WASM %s: %s\n", hash, err)
+		return nil, false
+	}
+	return module, true
+}
+
+// saveArtifact guarda un módulo compilado en disco, si la persistencia está activa. Se escribe
+// en un archivo temporal y se renombra para no dejar artefactos a medio escribir.
+func (c *ModuleCache) saveArtifact(hash string, module *wasmer.Module) {
+	if c.artifactDir == "" {
+		return
+	}
+	data, err := module.Serialize()
+	if err != nil {
+		c.diskFailure("Error serializando el módulo WASM %s: %s\n", hash, err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(c.artifactDir, hash+".*.tmp")
+	if err != nil {
+		c.diskFailure("Error guardando el artefacto WASM %s: %s\n", hash, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.artifactPath(hash))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		c.diskFailure("Error guardando el artefacto WASM %s: %s\n", hash, err)
+	}
+}
+
+// diskFailure registra un error de persistencia. Los artefactos son solo una optimización, así
+// que el error no interrumpe la ejecución.
+func (c *ModuleCache) diskFailure(format string, args ...interface{}) {
+	fmt.Printf(format, args...)
+	c.mu.Lock()
+	c.stats.DiskFailures++
+	c.mu.Unlock()
+}
+
+// Stats devuelve una copia de las métricas del caché.
+func (c *ModuleCache) Stats() ModuleCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	stats.Capacity = c.capacity
+	if lookups := stats.Hits + stats.DiskHits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits+stats.DiskHits) / float64(lookups)
+	}
+	return stats
+}
+
+// GetModuleCacheStats maneja la consulta de las métricas del caché de módulos WASM.
+func (s *Server) GetModuleCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ContractModuleStats())
+}
@@ -1,9 +1,7 @@
 package internal
 
 import (
-	"errors"
 	"fmt"
-	"time"
 
 	"github.com/wasmerio/wasmer-go/wasmer"
 )
@@ -31,71 +29,140 @@ func (c *WASMContract) Log(message string) {
 	c.Logs = append(c.Logs, message)
 }
 
-// Execute ejecuta el contrato WASM con los parámetros dados.
-func (c *WASMContract) Execute(input []byte) ([]byte, error) {
+// Convención de llamada de los contratos.
+const (
+	DefaultContractMethod = "execute"  // Método que se ejecuta si no se indica otro
+	ContractAllocExport   = "alloc"    // alloc(size i32) -> i32: reserva un búfer en la memoria del contrato
+	MaxContractIOSize     = 256 * 1024 // Tamaño máximo de la entrada y de la salida de una llamada
+)
+
+// ExecutionResult es el resultado de una ejecución de contrato.
+type ExecutionResult struct {
+	Output  []byte          `json:"output"`
+	GasUsed uint64          `json:"gas_used"`
+	Events  []ContractEvent `json:"events,omitempty"` // Eventos emitidos, solo si la ejecución termina bien
+}
+
+// Execute ejecuta un método del contrato WASM con los parámetros dados y un límite de gas, en
+// el entorno indicado. Si el gas se agota devuelve ErrOutOfGas junto con el resultado, que
+// informa el gas consumido.
+func (c *WASMContract) Execute(env ExecutionEnv, method string, input []byte, gasLimit uint64) (*ExecutionResult, error) {
 	c.Log("Iniciando la ejecución del contrato WASM.")
 
-	// Crear un nuevo motor WASM y un store.
-	engine := wasmer.NewEngine()
-	store := wasmer.NewStore(engine)
+	if gasLimit == 0 || gasLimit > MaxGasLimit {
+		return nil, fmt.Errorf("el límite de gas debe estar entre 1 y %d", MaxGasLimit)
+	}
 
-	// Compilar el código WASM.
-	module, err := wasmer.NewModule(store, c.WASMCode)
+	if len(env.stack) >= MaxCallDepth {
+		return nil, ErrCallDepth
+	}
+
+	// Obtener el módulo instrumentado y compilado, del caché si ya se usó.
+	module, store, err := contractModules.Load(c.WASMCode)
 	if err != nil {
-		c.Log(fmt.Sprintf("Error al compilar el contrato WASM: %s", err))
-		return nil, fmt.Errorf("error al compilar el contrato WASM: %w", err)
+		c.Log(fmt.Sprintf("Error al cargar el contrato WASM: %s", err))
+		return nil, err
+	}
+	if env.running(c.ID) && reentrancyGuarded(module) {
+		return nil, ErrReentrancy
 	}
+	env.stack = append(env.stack[:len(env.stack):len(env.stack)], c.ID)
 
-	// Crear un nuevo ambiente WASM.
-	importObject := wasmer.NewImportObject()
-	instance, err := wasmer.NewInstance(module, importObject)
+	// Crear el ambiente WASM con las funciones del host.
+	host := &hostEnv{contract: c, exec: env}
+	instance, err := wasmer.NewInstance(module, host.hostImports(store))
 	if err != nil {
 		c.Log(fmt.Sprintf("Error al crear la instancia WASM: %s", err))
 		return nil, fmt.Errorf("error al crear la instancia WASM: %w", err)
 	}
 
-	// Buscar la función `execute` en el contrato.
-	executeFunc, err := instance.Exports.GetFunction("execute")
+	// Asignar el gas disponible antes de la llamada.
+	gas, err := instance.Exports.GetGlobal(GasGlobalExport)
 	if err != nil {
-		c.Log("La función 'execute' no está definida en el contrato WASM.")
-		return nil, errors.New("la función 'execute' no está definida en el contrato WASM")
+		return nil, fmt.Errorf("error al obtener el contador de gas: %w", err)
+	}
+	if err := gas.Set(int64(gasLimit), wasmer.I64); err != nil {
+		return nil, fmt.Errorf("error al asignar el gas: %w", err)
+	}
+	host.gas = gas
+	if host.memory, err = instance.Exports.GetMemory("memory"); err != nil {
+		return nil, fmt.Errorf("el contrato debe exportar su memoria como 'memory': %w", err)
 	}
 
-	// Ejecutar con un límite de tiempo.
-	resultChan := make(chan []byte)
-	errorChan := make(chan error)
+	// Llamar al método con la entrada proporcionada.
+	output, callErr := callEntry(instance, host, method, input)
 
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				errorChan <- fmt.Errorf("pánico durante la ejecución del contrato WASM: %v", r)
-			}
-		}()
+	remaining, err := gas.Get()
+	if err != nil {
+		return nil, fmt.Errorf("error al leer el contador de gas: %w", err)
+	}
+	result := &ExecutionResult{GasUsed: gasLimit}
+	if left := remaining.(int64); left >= 0 {
+		result.GasUsed = gasLimit - uint64(left)
+	} else {
+		c.Log("Ejecución del contrato WASM terminada por gas agotado.")
+		return result, ErrOutOfGas
+	}
 
-		// Llamar a la función `execute` con la entrada proporcionada.
-		result, err := executeFunc(input)
-		if err != nil {
-			errorChan <- fmt.Errorf("error al ejecutar el contrato WASM: %w", err)
-			return
+	if callErr != nil {
+		if host.failure != nil {
+			callErr = host.failure
 		}
+		c.Log(fmt.Sprintf("Error durante la ejecución del contrato WASM: %s", callErr))
+		return result, fmt.Errorf("error al ejecutar el contrato WASM: %w", callErr)
+	}
+
+	c.Log("Contrato WASM ejecutado exitosamente.")
+	result.Output = output
+	result.Events = host.events
+	return result, nil
+}
+
+// callEntry llama a un punto de entrada del contrato según la convención de llamada: la
+// entrada se copia en un búfer reservado con la función exportada `alloc`, el punto de
+// entrada recibe (puntero, longitud) y devuelve un i64 con el puntero de la salida en los 32
+// bits altos y su longitud en los bajos.
+func callEntry(instance *wasmer.Instance, host *hostEnv, name string, input []byte) ([]byte, error) {
+	if len(input) > MaxContractIOSize {
+		return nil, fmt.Errorf("la entrada no puede superar %d bytes", MaxContractIOSize)
+	}
 
-		// Validar el tipo del resultado antes de convertirlo.
-		if byteResult, ok := result.([]byte); ok {
-			resultChan <- byteResult
-		} else {
-			errorChan <- fmt.Errorf("resultado inesperado al ejecutar el contrato WASM")
+	entry, err := instance.Exports.GetFunction(name)
+	if err != nil {
+		return nil, fmt.Errorf("la función '%s' no está definida en el contrato WASM", name)
+	}
+
+	var ptr int32
+	if len(input) > 0 {
+		alloc, err := instance.Exports.GetFunction(ContractAllocExport)
+		if err != nil {
+			return nil, fmt.Errorf("el contrato no exporta '%s' para recibir la entrada", ContractAllocExport)
+		}
+		allocated, err := alloc(int32(len(input)))
+		if err != nil {
+			return nil, fmt.Errorf("error al reservar memoria para la entrada: %w", err)
 		}
-	}()
-
-	select {
-	case result := <-resultChan:
-		c.Log("Contrato WASM ejecutado exitosamente.")
-		return result, nil
-	case err := <-errorChan:
-		c.Log(fmt.Sprintf("Error durante la ejecución del contrato WASM: %s", err))
+		ptr, _ = allocated.(int32)
+		if err := host.write(ptr, input); err != nil {
+			return nil, err
+		}
+	}
+
+	returned, err := entry(ptr, int32(len(input)))
+	if err != nil {
 		return nil, err
-	case <-time.After(5 * time.Second): // Límite de tiempo de 5 segundos
-		c.Log("Ejecución del contrato WASM terminada por tiempo excedido.")
-		return nil, errors.New("la ejecución del contrato WASM excedió el tiempo permitido")
 	}
+	packed, ok := returned.(int64)
+	if !ok {
+		return nil, fmt.Errorf("la función '%s' debe devolver un i64 con puntero y longitud", name)
+	}
+
+	length := int32(uint32(packed))
+	if length == 0 {
+		return []byte{}, nil
+	}
+	if length < 0 || length > MaxContractIOSize {
+		return nil, fmt.Errorf("la salida no puede superar %d bytes", MaxContractIOSize)
+	}
+	return host.read(int32(uint32(uint64(packed)>>32)), length)
 }
@@ -0,0 +1,606 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// La medición de gas se hace instrumentando el bytecode antes de compilarlo: el módulo recibe
+// un global i64 mutable con el gas restante, exportado como GasGlobalExport, y cada tramo de
+// código lineal descuenta su costo al comenzar. Las instrucciones cuyo trabajo depende de un
+// operando (memory.grow y las operaciones masivas) descuentan además, justo antes de
+// ejecutarse, un costo proporcional a ese operando. Si el gas queda negativo la ejecución se
+// detiene con `unreachable`. El resultado es determinista: no depende de la velocidad del nodo.
+
+// GasGlobalExport es el nombre con el que se exporta el contador de gas restante.
+const GasGlobalExport = "qubit_gas"
+
+// Límites de gas de una ejecución.
+const (
+	DefaultGasLimit uint64 = 1_000_000
+	MaxGasLimit     uint64 = 50_000_000
+)
+
+// Costos de gas por instrucción. El resto de las instrucciones cuesta gasCostDefault.
+const (
+	gasCostDefault    = 1
+	gasCostCall       = 5  // call, call_indirect
+	gasCostBulkMemory = 10 // memory.grow y operaciones masivas de memoria y tablas, además del costo por unidad
+)
+
+// Costos de gas por unidad del operando de longitud, cobrados al ejecutar la instrucción.
+const (
+	gasCostMemoryPage   = 1000 // memory.grow, por página de 64 KiB
+	gasCostMemoryByte   = 1    // memory.copy, memory.fill, memory.init, por byte
+	gasCostTableElement = 10   // table.grow, table.fill, table.copy, table.init, por elemento
+)
+
+// ErrOutOfGas indica que la ejecución agotó el gas disponible.
+var ErrOutOfGas = errors.New("gas agotado")
+
+var errWASMTruncated = errors.New("módulo WASM truncado")
+
+// Identificadores de sección del formato binario WASM.
+const (
+	sectionCustom = 0
+	sectionImport = 2
+	sectionGlobal = 6
+	sectionExport = 7
+	sectionStart  = 8
+	sectionCode   = 10
+)
+
+// sectionOrder es la posición obligatoria de cada sección no personalizada en el módulo.
+var sectionOrder = map[byte]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 5, 6: 6, 7: 7, 8: 8, 9: 9, 12: 10, 10: 11, 11: 12}
+
+// wasmSection es una sección del módulo con su contenido sin decodificar.
+type wasmSection struct {
+	id      byte
+	payload []byte
+}
+
+// InstrumentGas devuelve una copia del módulo con medición de gas. Los módulos con función
+// de inicio se rechazan porque se ejecutarían antes de poder asignarles gas.
+func InstrumentGas(code []byte) ([]byte, error) {
+	if len(code) < 8 || !bytes.Equal(code[:8], []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}) {
+		return nil, errors.New("el código no es un módulo WASM versión 1")
+	}
+
+	var sections []wasmSection
+	r := &wasmReader{data: code, pos: 8}
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sectionOrder[id]; !ok && id != sectionCustom {
+			return nil, fmt.Errorf("sección WASM desconocida: %d", id)
+		}
+		if id == sectionStart {
+			return nil, errors.New("los contratos no pueden declarar una función de inicio")
+		}
+		sections = append(sections, wasmSection{id, payload})
+	}
+
+	// El contador se agrega al final del espacio de globales para no desplazar ningún índice.
+	var gasIndex uint32
+	for _, section := range sections {
+		switch section.id {
+		case sectionImport:
+			imported, err := countImportedGlobals(section.payload)
+			if err != nil {
+				return nil, err
+			}
+			gasIndex += imported
+		case sectionGlobal:
+			defined, err := (&wasmReader{data: section.payload}).u32()
+			if err != nil {
+				return nil, err
+			}
+			gasIndex += defined
+		}
+	}
+
+	// i64 mutable inicializado en 0; el nodo asigna el límite antes de cada llamada. Le sigue
+	// un i32 mutable sin exportar donde el cobro por unidad guarda el operando de longitud.
+	sections, err := appendSectionEntry(sections, sectionGlobal, []byte{0x7E, 0x01, 0x42, 0x00, 0x0B}, nil)
+	if err != nil {
+		return nil, err
+	}
+	sections, err = appendSectionEntry(sections, sectionGlobal, []byte{0x7F, 0x01, 0x41, 0x00, 0x0B}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	export := appendU32(nil, uint32(len(GasGlobalExport)))
+	export = append(export, GasGlobalExport...)
+	export = append(export, 0x03)
+	export = appendU32(export, gasIndex)
+	sections, err = appendSectionEntry(sections, sectionExport, export, checkExportNames)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, code[:8]...)
+	for _, section := range sections {
+		payload := section.payload
+		if section.id == sectionCode {
+			if payload, err = instrumentCodeSection(payload, gasIndex); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, section.id)
+		out = appendU32(out, uint32(len(payload)))
+		out = append(out, payload...)
+	}
+	return out, nil
+}
+
+// appendSectionEntry agrega una entrada a una sección vectorial, creándola en su posición si
+// no existe. check, si no es nil, valida las entradas existentes.
+func appendSectionEntry(sections []wasmSection, id byte, entry []byte, check func([]byte, uint32) error) ([]wasmSection, error) {
+	for i, section := range sections {
+		if section.id != id {
+			continue
+		}
+		r := &wasmReader{data: section.payload}
+		count, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		entries := section.payload[r.pos:]
+		if check != nil {
+			if err := check(entries, count); err != nil {
+				return nil, err
+			}
+		}
+		payload := appendU32(nil, count+1)
+		payload = append(payload, entries...)
+		sections[i].payload = append(payload, entry...)
+		return sections, nil
+	}
+
+	position := len(sections)
+	for i, section := range sections {
+		if section.id != sectionCustom && sectionOrder[section.id] > sectionOrder[id] {
+			position = i
+			break
+		}
+	}
+	created := wasmSection{id: id, payload: append(appendU32(nil, 1), entry...)}
+	sections = append(sections[:position], append([]wasmSection{created}, sections[position:]...)...)
+	return sections, nil
+}
+
+// countImportedGlobals cuenta los globales importados, que preceden a los definidos en el módulo.
+func countImportedGlobals(payload []byte) (uint32, error) {
+	r := &wasmReader{data: payload}
+	count, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+
+	var globals uint32
+	for i := uint32(0); i < count; i++ {
+		for j := 0; j < 2; j++ { // módulo y nombre
+			if _, err := r.name(); err != nil {
+				return 0, err
+			}
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		switch kind {
+		case 0x00: // función
+			_, err = r.u32()
+		case 0x01: // tabla
+			if _, err = r.byte(); err == nil {
+				err = r.limits()
+			}
+		case 0x02: // memoria
+			err = r.limits()
+		case 0x03: // global
+			_, err = r.bytes(2)
+			globals++
+		default:
+			err = fmt.Errorf("tipo de importación WASM desconocido: %d", kind)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return globals, nil
+}
+
+// checkExportNames rechaza los módulos que ya exportan el nombre reservado para el gas.
+func checkExportNames(entries []byte, count uint32) error {
+	r := &wasmReader{data: entries}
+	for i := uint32(0); i < count; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		if string(name) == GasGlobalExport {
+			return fmt.Errorf("el nombre de exportación %s está reservado", GasGlobalExport)
+		}
+		if _, err := r.bytes(1); err != nil {
+			return err
+		}
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instrumentCodeSection instrumenta el cuerpo de cada función de la sección de código.
+func instrumentCodeSection(payload []byte, gasIndex uint32) ([]byte, error) {
+	r := &wasmReader{data: payload}
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	out := appendU32(nil, count)
+	for i := uint32(0); i < count; i++ {
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		instrumented, err := instrumentFunction(body, gasIndex)
+		if err != nil {
+			return nil, fmt.Errorf("función %d: %w", i, err)
+		}
+		out = appendU32(out, uint32(len(instrumented)))
+		out = append(out, instrumented...)
+	}
+	return out, nil
+}
+
+// instrumentFunction divide el cuerpo de una función en tramos lineales que terminan en cada
+// instrucción de control y antepone a cada tramo el descuento de su costo. Un tramo nunca se
+// ejecuta a medias salvo por una trampa, de modo que el gas cobrado no depende del camino.
+func instrumentFunction(body []byte, gasIndex uint32) ([]byte, error) {
+	r := &wasmReader{data: body}
+
+	// Las declaraciones de variables locales se copian sin cambios.
+	groups, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < groups; i++ {
+		if _, err := r.u32(); err != nil {
+			return nil, err
+		}
+		if _, err := r.byte(); err != nil {
+			return nil, err
+		}
+	}
+	out := append([]byte{}, body[:r.pos]...)
+
+	var segment []byte
+	cost := uint64(0)
+	for !r.done() {
+		start := r.pos
+		op, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		control, opCost, err := r.skipImmediates(op)
+		if err != nil {
+			return nil, err
+		}
+		instruction := body[start:r.pos]
+		if op == 0x23 || op == 0x24 { // global.get, global.set
+			// Los globales de la medición no existen en el módulo original: acceder a ellos
+			// permitiría al contrato asignarse su propio gas.
+			if index, _ := (&wasmReader{data: instruction[1:]}).u32(); index >= gasIndex {
+				return nil, fmt.Errorf("global %d fuera de rango", index)
+			}
+		}
+		if unitCost := unitGasCost(instruction); unitCost > 0 {
+			segment = appendUnitGasCharge(segment, gasIndex, unitCost)
+		}
+		segment = append(segment, instruction...)
+		cost += opCost
+		if control {
+			out = appendGasCharge(out, gasIndex, cost)
+			out = append(out, segment...)
+			segment, cost = segment[:0], 0
+		}
+	}
+	if len(segment) > 0 {
+		return nil, errors.New("el cuerpo de la función no termina en end")
+	}
+	return out, nil
+}
+
+// unitGasCost devuelve el costo por unidad de una instrucción cuyo trabajo depende de su último
+// operando (páginas, bytes o elementos), o 0 si su costo es fijo.
+func unitGasCost(instruction []byte) uint64 {
+	switch instruction[0] {
+	case 0x40: // memory.grow
+		return gasCostMemoryPage
+	case 0xFC:
+		sub, _ := (&wasmReader{data: instruction[1:]}).u32()
+		switch sub {
+		case 8, 10, 11: // memory.init, memory.copy, memory.fill
+			return gasCostMemoryByte
+		case 12, 14, 15, 17: // table.init, table.copy, table.grow, table.fill
+			return gasCostTableElement
+		}
+	}
+	return 0
+}
+
+// appendGasCharge agrega el código que descuenta cost del contador y detiene la ejecución si se agota:
+//
+//	global.get $gas; i64.const cost; i64.sub; global.set $gas
+//	global.get $gas; i64.const 0; i64.lt_s; if; unreachable; end
+func appendGasCharge(out []byte, gasIndex uint32, cost uint64) []byte {
+	out = append(out, 0x23)
+	out = appendU32(out, gasIndex)
+	out = append(out, 0x42)
+	out = appendS64(out, int64(cost))
+	out = append(out, 0x7D, 0x24)
+	out = appendU32(out, gasIndex)
+	out = append(out, 0x23)
+	out = appendU32(out, gasIndex)
+	return append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0B)
+}
+
+// appendUnitGasCharge agrega, antes de una instrucción cuyo último operando i32 es una longitud,
+// el código que descuenta unitCost por unidad de esa longitud sin quitarla de la pila. El
+// operando se guarda en el global que sigue al contador de gas:
+//
+//	global.set $len; global.get $gas; global.get $len; i64.extend_i32_u; i64.const unitCost
+//	i64.mul; i64.sub; global.set $gas
+//	global.get $gas; i64.const 0; i64.lt_s; if; unreachable; end; global.get $len
+func appendUnitGasCharge(out []byte, gasIndex uint32, unitCost uint64) []byte {
+	lengthIndex := gasIndex + 1
+	out = append(out, 0x24)
+	out = appendU32(out, lengthIndex)
+	out = append(out, 0x23)
+	out = appendU32(out, gasIndex)
+	out = append(out, 0x23)
+	out = appendU32(out, lengthIndex)
+	out = append(out, 0xAD, 0x42)
+	out = appendS64(out, int64(unitCost))
+	out = append(out, 0x7E, 0x7D, 0x24)
+	out = appendU32(out, gasIndex)
+	out = append(out, 0x23)
+	out = appendU32(out, gasIndex)
+	out = append(out, 0x42, 0x00, 0x53, 0x04, 0x40, 0x00, 0x0B, 0x23)
+	return appendU32(out, lengthIndex)
+}
+
+// wasmReader decodifica los tipos básicos del formato binario WASM.
+type wasmReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *wasmReader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *wasmReader) byte() (byte, error) {
+	if r.done() {
+		return 0, errWASMTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *wasmReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, errWASMTruncated
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// u32 decodifica un entero sin signo LEB128 de hasta 32 bits.
+func (r *wasmReader) u32() (uint32, error) {
+	var value uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, errors.New("entero LEB128 demasiado largo")
+}
+
+// skipLEB salta un entero LEB128 con o sin signo de hasta 64 bits.
+func (r *wasmReader) skipLEB() error {
+	for i := 0; i < 10; i++ {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if b&0x80 == 0 {
+			return nil
+		}
+	}
+	return errors.New("entero LEB128 demasiado largo")
+}
+
+func (r *wasmReader) name() ([]byte, error) {
+	size, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	return r.bytes(int(size))
+}
+
+func (r *wasmReader) limits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if _, err := r.u32(); err != nil {
+		return err
+	}
+	if flags&0x01 != 0 {
+		_, err = r.u32()
+	}
+	return err
+}
+
+// skipU32s salta n enteros LEB128.
+func (r *wasmReader) skipU32s(n int) error {
+	for i := 0; i < n; i++ {
+		if err := r.skipLEB(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockType salta el tipo de un bloque: vacío, un tipo de valor o un índice de tipo s33.
+func (r *wasmReader) blockType() error {
+	if r.done() {
+		return errWASMTruncated
+	}
+	switch r.data[r.pos] {
+	case 0x40, 0x7F, 0x7E, 0x7D, 0x7C, 0x7B, 0x70, 0x6F:
+		r.pos++
+		return nil
+	}
+	return r.skipLEB()
+}
+
+// skipImmediates salta los operandos inmediatos de una instrucción e indica si es una
+// instrucción de control, que cierra un tramo lineal, y su costo de gas.
+func (r *wasmReader) skipImmediates(op byte) (bool, uint64, error) {
+	switch {
+	case op == 0x00 || op == 0x0F: // unreachable, return
+		return true, gasCostDefault, nil
+	case op == 0x01 || op == 0x1A || op == 0x1B || op == 0xD1: // nop, drop, select, ref.is_null
+		return false, gasCostDefault, nil
+	case op == 0x02: // block
+		return false, gasCostDefault, r.blockType()
+	case op == 0x03 || op == 0x04: // loop, if
+		return true, gasCostDefault, r.blockType()
+	case op == 0x05 || op == 0x0B: // else, end
+		return true, gasCostDefault, nil
+	case op == 0x0C || op == 0x0D: // br, br_if
+		return true, gasCostDefault, r.skipU32s(1)
+	case op == 0x0E: // br_table
+		targets, err := r.u32()
+		if err != nil {
+			return false, 0, err
+		}
+		return true, gasCostDefault, r.skipU32s(int(targets) + 1)
+	case op == 0x10: // call
+		return false, gasCostCall, r.skipU32s(1)
+	case op == 0x11: // call_indirect
+		return false, gasCostCall, r.skipU32s(2)
+	case op == 0x1C: // select con tipos
+		types, err := r.u32()
+		if err != nil {
+			return false, 0, err
+		}
+		_, err = r.bytes(int(types))
+		return false, gasCostDefault, err
+	case op >= 0x20 && op <= 0x26: // variables locales y globales, table.get, table.set
+		return false, gasCostDefault, r.skipU32s(1)
+	case op >= 0x28 && op <= 0x3E: // accesos a memoria: alineación y desplazamiento
+		return false, gasCostDefault, r.skipU32s(2)
+	case op == 0x3F: // memory.size
+		return false, gasCostDefault, r.skipU32s(1)
+	case op == 0x40: // memory.grow
+		return false, gasCostBulkMemory, r.skipU32s(1)
+	case op == 0x41 || op == 0x42: // i32.const, i64.const
+		return false, gasCostDefault, r.skipLEB()
+	case op == 0x43: // f32.const
+		_, err := r.bytes(4)
+		return false, gasCostDefault, err
+	case op == 0x44: // f64.const
+		_, err := r.bytes(8)
+		return false, gasCostDefault, err
+	case op >= 0x45 && op <= 0xC4: // operaciones numéricas
+		return false, gasCostDefault, nil
+	case op == 0xD0: // ref.null
+		_, err := r.bytes(1)
+		return false, gasCostDefault, err
+	case op == 0xD2: // ref.func
+		return false, gasCostDefault, r.skipU32s(1)
+	case op == 0xFC:
+		return r.skipPrefixed()
+	}
+	return false, 0, fmt.Errorf("instrucción WASM no soportada: 0x%02x", op)
+}
+
+// skipPrefixed salta una instrucción con prefijo 0xFC: conversiones saturadas y operaciones
+// masivas de memoria y tablas.
+func (r *wasmReader) skipPrefixed() (bool, uint64, error) {
+	sub, err := r.u32()
+	if err != nil {
+		return false, 0, err
+	}
+	switch {
+	case sub <= 7: // trunc_sat
+		return false, gasCostDefault, nil
+	case sub == 8: // memory.init
+		return false, gasCostBulkMemory, r.skipU32s(2)
+	case sub == 9 || sub == 13: // data.drop, elem.drop
+		return false, gasCostDefault, r.skipU32s(1)
+	case sub == 10: // memory.copy
+		return false, gasCostBulkMemory, r.skipU32s(2)
+	case sub == 11: // memory.fill
+		return false, gasCostBulkMemory, r.skipU32s(1)
+	case sub == 12 || sub == 14: // table.init, table.copy
+		return false, gasCostBulkMemory, r.skipU32s(2)
+	case sub == 15 || sub == 17: // table.grow, table.fill
+		return false, gasCostBulkMemory, r.skipU32s(1)
+	case sub == 16: // table.size
+		return false, gasCostDefault, r.skipU32s(1)
+	}
+	return false, 0, fmt.Errorf("instrucción WASM no soportada: 0xfc %d", sub)
+}
+
+// appendU32 codifica un entero sin signo en LEB128.
+func appendU32(out []byte, value uint32) []byte {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if value == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// appendS64 codifica un entero con signo en LEB128.
+func appendS64(out []byte, value int64) []byte {
+	for {
+		b := byte(value & 0x7F)
+		value >>= 7
+		if (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// instrumentedInstance instrumenta el módulo en formato de texto y lo instancia sin funciones
+// del host.
+func instrumentedInstance(t *testing.T, wat string) (*wasmer.Instance, *wasmer.Global) {
+	t.Helper()
+	code, err := wasmer.Wat2Wasm(wat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instrumented, err := InstrumentGas(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := wasmer.NewStore(wasmer.NewEngine())
+	module, err := wasmer.NewModule(store, instrumented)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := wasmer.NewInstance(module, wasmer.NewImportObject())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gas, err := instance.Exports.GetGlobal(GasGlobalExport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance, gas
+}
+
+// runWithGas llama a la función name con el límite dado y devuelve el gas consumido.
+func runWithGas(t *testing.T, instance *wasmer.Instance, gas *wasmer.Global, name string, limit int64, args ...interface{}) (int64, error) {
+	t.Helper()
+	if err := gas.Set(limit, wasmer.I64); err != nil {
+		t.Fatal(err)
+	}
+	run, err := instance.Exports.GetFunction(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, callErr := run(args...)
+	left, err := gas.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limit - left.(int64), callErr
+}
+
+// TestInstrumentGasRejectsMeteringGlobals comprueba que un contrato no pueda asignarse gas
+// escribiendo en los globales que agrega la instrumentación.
+func TestInstrumentGasRejectsMeteringGlobals(t *testing.T) {
+	cases := map[string]string{
+		"global.set": `(module (global $g (mut i32) (i32.const 0))
+			(func (export "run") (global.set 1 (i64.const 1099511627776))))`,
+		"global.get": `(module (func (export "run") (result i32) (global.get 1)))`,
+	}
+	for name, wat := range cases {
+		code, err := wasmer.Wat2Wasm(wat)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := InstrumentGas(code); err == nil {
+			t.Errorf("%s: se instrumentó un módulo que accede a los globales de la medición", name)
+		}
+	}
+}
+
+// TestInstrumentGasOutOfGas comprueba que un bucle infinito se detenga al agotar el gas.
+func TestInstrumentGasOutOfGas(t *testing.T) {
+	instance, gas := instrumentedInstance(t, `(module
+		(func (export "run") (loop $l (br $l))))`)
+
+	used, err := runWithGas(t, instance, gas, "run", 1000)
+	if err == nil {
+		t.Fatal("el bucle infinito terminó sin agotar el gas")
+	}
+	if used <= 1000 {
+		t.Errorf("gas consumido: %d, se esperaba más que el límite", used)
+	}
+}
+
+// TestInstrumentGasMemoryGrow comprueba que memory.grow cobre cada página solicitada y que el
+// cobro ocurra antes de crecer la memoria.
+func TestInstrumentGasMemoryGrow(t *testing.T) {
+	instance, gas := instrumentedInstance(t, `(module
+		(memory (export "memory") 1 100)
+		(func (export "grow") (param i32) (result i32) (memory.grow (local.get 0))))`)
+	memory, err := instance.Exports.GetMemory("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := runWithGas(t, instance, gas, "grow", 1_000_000, int32(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	five, err := runWithGas(t, instance, gas, "grow", 1_000_000, int32(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if five-one != 4*gasCostMemoryPage {
+		t.Errorf("crecer 5 páginas costó %d más que crecer 1, se esperaba %d", five-one, 4*gasCostMemoryPage)
+	}
+
+	pages := memory.Size()
+	if _, err := runWithGas(t, instance, gas, "grow", 10*gasCostMemoryPage, int32(10)); err == nil {
+		t.Fatal("memory.grow de 10 páginas no agotó el gas")
+	}
+	if memory.Size() != pages {
+		t.Errorf("la memoria creció de %d a %d páginas sin gas suficiente", pages, memory.Size())
+	}
+}
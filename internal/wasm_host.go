@@ -0,0 +1,479 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"blockchain-go/wallet"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// Límites de los datos que un contrato puede guardar.
+const (
+	MaxStorageKeySize   = 256
+	MaxStorageValueSize = 64 * 1024
+)
+
+// Costos de gas de las funciones del host, además del costo de la instrucción call.
+const (
+	gasCostHostCall     = 50   // Costo base de cualquier función del host
+	gasCostStorageRead  = 200  // Más un punto por byte leído
+	gasCostStorageWrite = 1000 // Más un punto por byte escrito
+	gasCostTransfer     = 2000
+	gasCostEvent        = 375 // Más 375 por tema y un punto por byte de datos
+	gasCostEventTopic   = 375
+	gasCostContractCall = 700 // Más un punto por byte de entrada y el gas que consuma el contrato llamado
+)
+
+// ContractState es el estado de la cadena al que acceden los contratos durante su ejecución.
+type ContractState interface {
+	// GetStorage devuelve el valor de una clave del almacenamiento de un contrato y si existe.
+	GetStorage(contract string, key []byte) ([]byte, bool, error)
+	SetStorage(contract string, key, value []byte) error
+	DeleteStorage(contract string, key []byte) error
+	GetBalance(account string) (int64, error)
+	// ContractTransfer transfiere fondos desde la cuenta del contrato.
+	ContractTransfer(contract, to string, amount int64, ctx BlockContext) error
+	// LoadContract obtiene un contrato para que otro lo llame, o nil si no existe.
+	LoadContract(id string) (*WASMContract, error)
+}
+
+// ErrReadOnly indica que un contrato intentó modificar el estado durante una consulta.
+var ErrReadOnly = errors.New("la ejecución es de solo lectura")
+
+// ExecutionEnv es el contexto de una ejecución de contrato: quién lo llama, en qué bloque y
+// sobre qué estado.
+type ExecutionEnv struct {
+	Caller   string
+	Block    BlockContext
+	State    ContractState
+	ReadOnly bool // Las funciones del host que modifican el estado fallan con ErrReadOnly
+
+	stack []string // Contratos en ejecución, desde la llamada inicial hasta el actual
+}
+
+// hostEnv enlaza las funciones del host con la instancia en ejecución.
+type hostEnv struct {
+	contract *WASMContract
+	exec     ExecutionEnv
+	memory   *wasmer.Memory // Memoria exportada por el contrato, si la hay
+	gas      *wasmer.Global
+	failure  error           // Primer error devuelto por una función del host, que wasmer convierte en trap
+	events   []ContractEvent // Eventos emitidos, que solo se conservan si la ejecución termina bien
+
+	returnData []byte // Salida de la última llamada a otro contrato, o el motivo de su fallo
+}
+
+// hostImports construye el espacio de importación `env` con las funciones del host. Los
+// datos se intercambian por puntero y longitud en la memoria lineal del contrato.
+func (h *hostEnv) hostImports(store *wasmer.Store) *wasmer.ImportObject {
+	i32, i64 := wasmer.I32, wasmer.I64
+	function := func(params, results []wasmer.ValueKind, fn func([]wasmer.Value) ([]wasmer.Value, error)) wasmer.IntoExtern {
+		ty := wasmer.NewFunctionType(wasmer.NewValueTypes(params...), wasmer.NewValueTypes(results...))
+		return wasmer.NewFunction(store, ty, func(args []wasmer.Value) ([]wasmer.Value, error) {
+			if err := h.charge(gasCostHostCall); err != nil {
+				return nil, err
+			}
+			results, err := fn(args)
+			if err != nil && h.failure == nil {
+				h.failure = err
+			}
+			return results, err
+		})
+	}
+
+	imports := wasmer.NewImportObject()
+	imports.Register("env", map[string]wasmer.IntoExtern{
+		// storage_read(key_ptr, key_len, out_ptr, out_cap) -> longitud del valor, o -1 si no existe.
+		// Si el valor no entra en out_cap no se copia nada.
+		"storage_read": function([]wasmer.ValueKind{i32, i32, i32, i32}, []wasmer.ValueKind{i32}, h.storageRead),
+		// storage_write(key_ptr, key_len, value_ptr, value_len)
+		"storage_write": function([]wasmer.ValueKind{i32, i32, i32, i32}, nil, h.storageWrite),
+		// storage_remove(key_ptr, key_len)
+		"storage_remove": function([]wasmer.ValueKind{i32, i32}, nil, h.storageRemove),
+		// balance(address_ptr, address_len) -> saldo
+		"balance": function([]wasmer.ValueKind{i32, i32}, []wasmer.ValueKind{i64}, h.balance),
+		// transfer(to_ptr, to_len, amount) -> 0 si se transfirió, 1 si el saldo no alcanza
+		"transfer": function([]wasmer.ValueKind{i32, i32, i64}, []wasmer.ValueKind{i32}, h.transfer),
+		// caller(out_ptr, out_cap) -> longitud de la dirección de quien llama
+		"caller": function([]wasmer.ValueKind{i32, i32}, []wasmer.ValueKind{i32}, func(args []wasmer.Value) ([]wasmer.Value, error) {
+			return h.writeString(args, h.exec.Caller)
+		}),
+		// contract_address(out_ptr, out_cap) -> longitud de la dirección del propio contrato
+		"contract_address": function([]wasmer.ValueKind{i32, i32}, []wasmer.ValueKind{i32}, func(args []wasmer.Value) ([]wasmer.Value, error) {
+			return h.writeString(args, h.contract.ID)
+		}),
+		"block_height": function(nil, []wasmer.ValueKind{i64}, func([]wasmer.Value) ([]wasmer.Value, error) {
+			return []wasmer.Value{wasmer.NewI64(int64(h.exec.Block.Height))}, nil
+		}),
+		// block_timestamp() -> marca de tiempo del bloque en segundos Unix
+		"block_timestamp": function(nil, []wasmer.ValueKind{i64}, func([]wasmer.Value) ([]wasmer.Value, error) {
+			return []wasmer.Value{wasmer.NewI64(h.exec.Block.Time.Unix())}, nil
+		}),
+		// log(message_ptr, message_len)
+		"log": function([]wasmer.ValueKind{i32, i32}, nil, h.log),
+		// emit_event(topics_ptr, topics_count, data_ptr, data_len): los temas son palabras
+		// consecutivas de 32 bytes.
+		"emit_event": function([]wasmer.ValueKind{i32, i32, i32, i32}, nil, h.emitEvent),
+		// call_contract(contract_ptr, contract_len, method_ptr, method_len, args_ptr, args_len, value, gas)
+		// -> 0 si la llamada terminó bien, 1 si falló. gas es la asignación máxima; 0 asigna todo
+		// el gas restante salvo 1/64.
+		"call_contract": function([]wasmer.ValueKind{i32, i32, i32, i32, i32, i32, i64, i64}, []wasmer.ValueKind{i32}, h.callContract),
+		// call_output(out_ptr, out_cap) -> longitud de la salida de la última llamada a otro
+		// contrato, o del motivo de su fallo
+		"call_output": function([]wasmer.ValueKind{i32, i32}, []wasmer.ValueKind{i32}, h.callOutput),
+	})
+	return imports
+}
+
+// charge descuenta gas del contador del contrato por el trabajo hecho en el host.
+func (h *hostEnv) charge(cost uint64) error {
+	value, err := h.gas.Get()
+	if err != nil {
+		return fmt.Errorf("error al leer el contador de gas: %w", err)
+	}
+	left := value.(int64) - int64(cost)
+	if err := h.gas.Set(left, wasmer.I64); err != nil {
+		return fmt.Errorf("error al actualizar el contador de gas: %w", err)
+	}
+	if left < 0 {
+		return ErrOutOfGas
+	}
+	return nil
+}
+
+// read copia length bytes de la memoria del contrato a partir de ptr.
+func (h *hostEnv) read(ptr, length int32) ([]byte, error) {
+	if h.memory == nil {
+		return nil, errors.New("el contrato no exporta su memoria")
+	}
+	data := h.memory.Data()
+	if ptr < 0 || length < 0 || int(ptr)+int(length) > len(data) {
+		return nil, errors.New("acceso fuera de la memoria del contrato")
+	}
+	return append([]byte{}, data[ptr:ptr+length]...), nil
+}
+
+// write copia data en la memoria del contrato a partir de ptr.
+func (h *hostEnv) write(ptr int32, data []byte) error {
+	if h.memory == nil {
+		return errors.New("el contrato no exporta su memoria")
+	}
+	memory := h.memory.Data()
+	if ptr < 0 || int(ptr)+len(data) > len(memory) {
+		return errors.New("acceso fuera de la memoria del contrato")
+	}
+	copy(memory[ptr:], data)
+	return nil
+}
+
+// writeString copia value en el búfer (out_ptr, out_cap) de los argumentos si entra y devuelve su longitud.
+func (h *hostEnv) writeString(args []wasmer.Value, value string) ([]wasmer.Value, error) {
+	if int(args[1].I32()) >= len(value) {
+		if err := h.write(args[0].I32(), []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+	return []wasmer.Value{wasmer.NewI32(int32(len(value)))}, nil
+}
+
+// readKey lee una clave de almacenamiento validando su tamaño.
+func (h *hostEnv) readKey(ptr, length int32) ([]byte, error) {
+	if length <= 0 || length > MaxStorageKeySize {
+		return nil, fmt.Errorf("la clave debe tener entre 1 y %d bytes", MaxStorageKeySize)
+	}
+	return h.read(ptr, length)
+}
+
+func (h *hostEnv) storageRead(args []wasmer.Value) ([]wasmer.Value, error) {
+	key, err := h.readKey(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	value, found, err := h.exec.State.GetStorage(h.contract.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []wasmer.Value{wasmer.NewI32(-1)}, nil
+	}
+	if err := h.charge(gasCostStorageRead + uint64(len(value))); err != nil {
+		return nil, err
+	}
+	if int(args[3].I32()) >= len(value) {
+		if err := h.write(args[2].I32(), value); err != nil {
+			return nil, err
+		}
+	}
+	return []wasmer.Value{wasmer.NewI32(int32(len(value)))}, nil
+}
+
+func (h *hostEnv) storageWrite(args []wasmer.Value) ([]wasmer.Value, error) {
+	if h.exec.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	key, err := h.readKey(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	if args[3].I32() > MaxStorageValueSize {
+		return nil, fmt.Errorf("el valor no puede superar %d bytes", MaxStorageValueSize)
+	}
+	value, err := h.read(args[2].I32(), args[3].I32())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.charge(gasCostStorageWrite + uint64(len(key)+len(value))); err != nil {
+		return nil, err
+	}
+	return nil, h.exec.State.SetStorage(h.contract.ID, key, value)
+}
+
+func (h *hostEnv) storageRemove(args []wasmer.Value) ([]wasmer.Value, error) {
+	if h.exec.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	key, err := h.readKey(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.charge(gasCostStorageWrite); err != nil {
+		return nil, err
+	}
+	return nil, h.exec.State.DeleteStorage(h.contract.ID, key)
+}
+
+// readAddress lee una dirección de la memoria del contrato, en formato qbt1... o hexadecimal.
+func (h *hostEnv) readAddress(ptr, length int32) (string, error) {
+	data, err := h.read(ptr, length)
+	if err != nil {
+		return "", err
+	}
+	address, err := wallet.ParseAddress(string(data))
+	if err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+func (h *hostEnv) balance(args []wasmer.Value) ([]wasmer.Value, error) {
+	address, err := h.readAddress(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	balance, err := h.exec.State.GetBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	return []wasmer.Value{wasmer.NewI64(balance)}, nil
+}
+
+func (h *hostEnv) transfer(args []wasmer.Value) ([]wasmer.Value, error) {
+	if h.exec.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	to, err := h.readAddress(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	amount := args[2].I64()
+	if amount <= 0 {
+		return nil, errors.New("el monto debe ser mayor que cero")
+	}
+	if err := h.charge(gasCostTransfer); err != nil {
+		return nil, err
+	}
+
+	balance, err := h.exec.State.GetBalance(h.contract.ID)
+	if err != nil {
+		return nil, err
+	}
+	if balance < amount {
+		return []wasmer.Value{wasmer.NewI32(1)}, nil
+	}
+	if err := h.exec.State.ContractTransfer(h.contract.ID, to, amount, h.exec.Block); err != nil {
+		return nil, err
+	}
+	return []wasmer.Value{wasmer.NewI32(0)}, nil
+}
+
+func (h *hostEnv) log(args []wasmer.Value) ([]wasmer.Value, error) {
+	message, err := h.read(args[0].I32(), args[1].I32())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.charge(uint64(len(message))); err != nil {
+		return nil, err
+	}
+	h.contract.Log(string(message))
+	return nil, nil
+}
+
+func (h *hostEnv) emitEvent(args []wasmer.Value) ([]wasmer.Value, error) {
+	count := args[1].I32()
+	if count < 0 || count > MaxEventTopics {
+		return nil, fmt.Errorf("un evento puede tener hasta %d temas", MaxEventTopics)
+	}
+	if args[3].I32() > MaxEventDataSize {
+		return nil, fmt.Errorf("los datos de un evento no pueden superar %d bytes", MaxEventDataSize)
+	}
+	raw, err := h.read(args[0].I32(), count*EventTopicSize)
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.read(args[2].I32(), args[3].I32())
+	if err != nil {
+		return nil, err
+	}
+	if err := h.charge(gasCostEvent + gasCostEventTopic*uint64(count) + uint64(len(data))); err != nil {
+		return nil, err
+	}
+
+	topics := make([]string, count)
+	for i := range topics {
+		topics[i] = hex.EncodeToString(raw[i*EventTopicSize : (i+1)*EventTopicSize])
+	}
+	h.events = append(h.events, ContractEvent{Contract: h.contract.ID, Topics: topics, Data: data})
+	return nil, nil
+}
+
+// querier es la parte común de *sql.DB y *sql.Tx que usa el estado de los contratos.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlState implementa ContractState sobre la base de datos o sobre una transacción SQL, para
+// que los efectos de una llamada se confirmen o descarten juntos.
+type sqlState struct {
+	q querier
+}
+
+// GetStorage obtiene el valor de una clave del almacenamiento de un contrato.
+func (s sqlState) GetStorage(contract string, key []byte) ([]byte, bool, error) {
+	var value []byte
+	err := s.q.QueryRow(
+		"SELECT value FROM contract_storage WHERE contract_id = $1 AND key = $2",
+		contract, key,
+	).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error leyendo almacenamiento del contrato: %w", err)
+	}
+	return value, true, nil
+}
+
+// SetStorage guarda el valor de una clave del almacenamiento de un contrato.
+func (s sqlState) SetStorage(contract string, key, value []byte) error {
+	_, err := s.q.Exec(
+		`INSERT INTO contract_storage (contract_id, key, value) VALUES ($1, $2, $3)
+		ON CONFLICT (contract_id, key) DO UPDATE SET value = $3`,
+		contract, key, value,
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando almacenamiento del contrato: %w", err)
+	}
+	return nil
+}
+
+// DeleteStorage elimina una clave del almacenamiento de un contrato.
+func (s sqlState) DeleteStorage(contract string, key []byte) error {
+	_, err := s.q.Exec(
+		"DELETE FROM contract_storage WHERE contract_id = $1 AND key = $2",
+		contract, key,
+	)
+	if err != nil {
+		return fmt.Errorf("error eliminando almacenamiento del contrato: %w", err)
+	}
+	return nil
+}
+
+// GetBalance obtiene el saldo de una cuenta.
+func (s sqlState) GetBalance(account string) (int64, error) {
+	var balance int64
+	err := s.q.QueryRow("SELECT balance FROM balances WHERE account = $1", account).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// LoadContract obtiene un contrato por su ID, o nil si no existe.
+func (s sqlState) LoadContract(id string) (*WASMContract, error) {
+	var contract WASMContract
+	err := s.q.QueryRow(
+		"SELECT id, owner, wasm_code FROM wasm_contracts WHERE id = $1",
+		id,
+	).Scan(&contract.ID, &contract.Owner, &contract.WASMCode)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error cargando contrato WASM: %w", err)
+	}
+	return &contract, nil
+}
+
+// contractTransferType identifica en el historial las transferencias hechas por contratos.
+const contractTransferType = "contract_transfer"
+
+// ContractTransfer transfiere fondos desde la cuenta de un contrato.
+func (s sqlState) ContractTransfer(contract, to string, amount int64, ctx BlockContext) error {
+	return s.transfer(contract, to, amount, contractTransferType, "", ctx)
+}
+
+// transfer mueve fondos entre cuentas y los registra en el historial.
+func (s sqlState) transfer(from, to string, amount int64, txType, txHash string, ctx BlockContext) error {
+	if err := s.debit(from, amount); err != nil {
+		return err
+	}
+	if err := s.credit(to, amount); err != nil {
+		return err
+	}
+	return s.recordHistory(HistoryEntry{From: from, To: to, Amount: amount, Type: txType, TxHash: txHash, Timestamp: ctx.Time})
+}
+
+// debit descuenta un monto del saldo de una cuenta verificando que alcance.
+func (s sqlState) debit(account string, amount int64) error {
+	if amount <= 0 {
+		return fmt.Errorf("el monto debe ser mayor que cero")
+	}
+
+	result, err := s.q.Exec(
+		"UPDATE balances SET balance = balance - $2 WHERE account = $1 AND balance >= $2",
+		account, amount,
+	)
+	if err != nil {
+		return fmt.Errorf("error debitando a %s: %w", account, err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("saldo insuficiente en la cuenta %s", account)
+	}
+	return nil
+}
+
+// credit acredita un monto al saldo de una cuenta.
+func (s sqlState) credit(account string, amount int64) error {
+	_, err := s.q.Exec(
+		`INSERT INTO balances (account, balance) VALUES ($1, $2)
+		ON CONFLICT (account) DO UPDATE SET balance = balances.balance + $2`,
+		account, amount,
+	)
+	if err != nil {
+		return fmt.Errorf("error acreditando a %s: %w", account, err)
+	}
+	return nil
+}
+
+// recordHistory registra un movimiento aplicado en el historial de cuentas.
+func (s sqlState) recordHistory(entry HistoryEntry) error {
+	_, err := s.q.Exec(insertHistoryQuery, entry.From, entry.To, entry.Amount, entry.Timestamp, entry.TxHash, entry.Type)
+	if err != nil {
+		return fmt.Errorf("error registrando historial: %w", err)
+	}
+	return nil
+}
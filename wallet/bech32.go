@@ -0,0 +1,182 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Versiones de dirección: indican cómo se derivó el hash de 32 bytes.
+const (
+	AddressVersionP256     byte = 0 // Blake2b de una clave pública P-256
+	AddressVersionMultisig byte = 1 // Blake2b de una política multifirma M de N
+	AddressVersionEd25519  byte = 2 // Blake2b de una clave pública Ed25519 etiquetada
+	AddressVersionContract byte = 3 // Blake2b del emisor y el nonce de un despliegue de contrato
+)
+
+// DefaultAddressPrefix es el prefijo de red de las direcciones de Qubit.
+const DefaultAddressPrefix = "qbt"
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst  = 0x2bc830a3
+	hashLength    = 32
+)
+
+// ErrInvalidAddress indica una dirección mal formada o con checksum incorrecto.
+var ErrInvalidAddress = errors.New("dirección inválida")
+
+// EncodeAddress codifica el hash hexadecimal de una cuenta con prefijo de red, versión y
+// checksum Bech32m, por ejemplo qbt1q....
+func EncodeAddress(prefix string, version byte, hashHex string) (string, error) {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil || len(hash) != hashLength {
+		return "", fmt.Errorf("%w: se esperaba un hash de %d bytes", ErrInvalidAddress, hashLength)
+	}
+	if version > 31 {
+		return "", fmt.Errorf("%w: versión %d fuera de rango", ErrInvalidAddress, version)
+	}
+
+	data := append([]byte{version}, convertBits(hash, 8, 5, true)...)
+	checksum := bech32Checksum(prefix, data)
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteByte('1')
+	for _, b := range append(data, checksum...) {
+		sb.WriteByte(bech32Charset[b])
+	}
+	return sb.String(), nil
+}
+
+// DecodeAddress decodifica una dirección Bech32m y devuelve su prefijo, versión y hash hexadecimal.
+func DecodeAddress(address string) (string, byte, string, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return "", 0, "", fmt.Errorf("%w: mezcla mayúsculas y minúsculas", ErrInvalidAddress)
+	}
+	address = strings.ToLower(address)
+
+	sep := strings.LastIndexByte(address, '1')
+	if sep < 1 || sep+8 > len(address) {
+		return "", 0, "", fmt.Errorf("%w: formato incorrecto", ErrInvalidAddress)
+	}
+	prefix := address[:sep]
+
+	data := make([]byte, 0, len(address)-sep-1)
+	for _, c := range address[sep+1:] {
+		index := strings.IndexRune(bech32Charset, c)
+		if index < 0 {
+			return "", 0, "", fmt.Errorf("%w: carácter %q no permitido", ErrInvalidAddress, c)
+		}
+		data = append(data, byte(index))
+	}
+
+	if bech32Polymod(append(expandPrefix(prefix), data...)) != bech32mConst {
+		return "", 0, "", fmt.Errorf("%w: checksum incorrecto", ErrInvalidAddress)
+	}
+
+	data = data[:len(data)-6]
+	hash, err := convertBitsStrict(data[1:])
+	if err != nil || len(hash) != hashLength {
+		return "", 0, "", fmt.Errorf("%w: longitud incorrecta", ErrInvalidAddress)
+	}
+	return prefix, data[0], hex.EncodeToString(hash), nil
+}
+
+// IsLegacyAddress indica si la cadena es una dirección heredada (64 caracteres hexadecimales).
+func IsLegacyAddress(address string) bool {
+	if len(address) != hashLength*2 {
+		return false
+	}
+	_, err := hex.DecodeString(address)
+	return err == nil
+}
+
+// ParseAddress acepta una dirección Bech32m o heredada y devuelve el hash hexadecimal usado
+// internamente por el nodo (y cubierto por las firmas).
+func ParseAddress(address string) (string, error) {
+	if IsLegacyAddress(address) {
+		return strings.ToLower(address), nil
+	}
+	_, _, hash, err := DecodeAddress(address)
+	return hash, err
+}
+
+// bech32Polymod calcula el polinomio de checksum de Bech32.
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// expandPrefix expande el prefijo legible para el cálculo del checksum.
+func expandPrefix(prefix string) []byte {
+	result := make([]byte, 0, len(prefix)*2+1)
+	for _, c := range prefix {
+		result = append(result, byte(c>>5))
+	}
+	result = append(result, 0)
+	for _, c := range prefix {
+		result = append(result, byte(c&31))
+	}
+	return result
+}
+
+// bech32Checksum calcula los 6 símbolos de checksum Bech32m.
+func bech32Checksum(prefix string, data []byte) []byte {
+	values := append(expandPrefix(prefix), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ bech32mConst
+
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte((polymod >> uint(5*(5-i))) & 31)
+	}
+	return checksum
+}
+
+// convertBits reagrupa bits entre bases (por ejemplo de 8 a 5 bits por símbolo).
+func convertBits(data []byte, from, to uint, pad bool) []byte {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	result := make([]byte, 0, len(data)*int(from)/int(to)+1)
+	for _, value := range data {
+		acc = acc<<from | uint(value)
+		bits += from
+		for bits >= to {
+			bits -= to
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+	if pad && bits > 0 {
+		result = append(result, byte(acc<<(to-bits)&maxv))
+	}
+	return result
+}
+
+// convertBitsStrict convierte de 5 a 8 bits rechazando relleno no nulo.
+func convertBitsStrict(data []byte) ([]byte, error) {
+	var acc, bits uint
+	result := make([]byte, 0, len(data)*5/8)
+	for _, value := range data {
+		acc = acc<<5 | uint(value)
+		bits += 5
+		if bits >= 8 {
+			bits -= 8
+			result = append(result, byte(acc>>bits&0xff))
+		}
+	}
+	if bits >= 5 || acc&(1<<bits-1) != 0 {
+		return nil, errors.New("relleno inválido")
+	}
+	return result, nil
+}
@@ -0,0 +1,142 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+)
+
+// bech32mValid indica si la cadena tiene un checksum Bech32m correcto, sin comprobar la longitud
+// de los datos.
+func bech32mValid(s string) bool {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return false
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return false
+	}
+
+	var data []byte
+	for _, c := range s[sep+1:] {
+		index := strings.IndexRune(bech32Charset, c)
+		if index < 0 {
+			return false
+		}
+		data = append(data, byte(index))
+	}
+	return bech32Polymod(append(expandPrefix(s[:sep]), data...)) == bech32mConst
+}
+
+// TestBech32mChecksumVectors comprueba el checksum con los vectores de BIP-350.
+func TestBech32mChecksumVectors(t *testing.T) {
+	valid := []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11" + strings.Repeat("l", 83) + "udsr8", // 90 caracteres, el máximo de Bech32m
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	}
+	for _, s := range valid {
+		if !bech32mValid(s) {
+			t.Errorf("%s: checksum rechazado", s)
+		}
+	}
+
+	invalid := []string{
+		"in1muywd",  // Checksum demasiado corto
+		"mm1crxm3i", // Carácter inválido en el checksum
+		"au1s5cgom", // Carácter inválido en el checksum
+		"M1VUXWEZ",  // Checksum calculado con el prefijo en mayúsculas
+		"16plkw9",   // Prefijo vacío
+		"1p2gdwpf",  // Prefijo vacío
+		"a12uel5l",  // Checksum Bech32 (BIP-173), no Bech32m
+		"A1lqfn3a",  // Mezcla mayúsculas y minúsculas
+		"a1lqfn3q",  // Checksum alterado
+	}
+	for _, s := range invalid {
+		if bech32mValid(s) {
+			t.Errorf("%s: checksum aceptado", s)
+		}
+	}
+}
+
+// TestAddressRoundTrip comprueba que cada versión de dirección se codifique y decodifique sin
+// cambios, también en mayúsculas.
+func TestAddressRoundTrip(t *testing.T) {
+	hash := strings.Repeat("0123456789abcdef", 4)
+	versions := []byte{AddressVersionP256, AddressVersionMultisig, AddressVersionEd25519, AddressVersionContract}
+	for _, version := range versions {
+		address, err := EncodeAddress(DefaultAddressPrefix, version, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(address, DefaultAddressPrefix+"1") {
+			t.Errorf("versión %d: %s no empieza por %s1", version, address, DefaultAddressPrefix)
+		}
+		if got := address[len(DefaultAddressPrefix)+1]; got != bech32Charset[version] {
+			t.Errorf("versión %d: símbolo de versión %q", version, got)
+		}
+
+		for _, encoded := range []string{address, strings.ToUpper(address)} {
+			prefix, decodedVersion, decodedHash, err := DecodeAddress(encoded)
+			if err != nil {
+				t.Fatalf("%s: %s", encoded, err)
+			}
+			if prefix != DefaultAddressPrefix || decodedVersion != version || decodedHash != hash {
+				t.Errorf("%s: %s, %d, %s", encoded, prefix, decodedVersion, decodedHash)
+			}
+		}
+
+		parsed, err := ParseAddress(address)
+		if err != nil || parsed != hash {
+			t.Errorf("ParseAddress(%s) = %s, %v", address, parsed, err)
+		}
+	}
+}
+
+// TestAddressErrors comprueba que se rechacen las direcciones mal formadas.
+func TestAddressErrors(t *testing.T) {
+	hash := strings.Repeat("ab", hashLength)
+	address, err := EncodeAddress(DefaultAddressPrefix, AddressVersionP256, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Un hash de 20 bytes tiene un checksum válido pero una longitud incorrecta.
+	data := append([]byte{AddressVersionP256}, convertBits(make([]byte, 20), 8, 5, true)...)
+	short := DefaultAddressPrefix + "1"
+	for _, b := range append(data, bech32Checksum(DefaultAddressPrefix, data)...) {
+		short += string(bech32Charset[b])
+	}
+
+	flipped := []byte(address)
+	last := strings.IndexByte(bech32Charset, flipped[len(flipped)-1])
+	flipped[len(flipped)-1] = bech32Charset[(last+1)%32]
+
+	tests := map[string]string{
+		"checksum alterado":           string(flipped),
+		"mayúsculas y minúsculas":     strings.ToUpper(address[:5]) + address[5:],
+		"sin separador":               strings.Replace(address, "1", "", 1),
+		"carácter fuera del alfabeto": address[:10] + "b" + address[11:],
+		"hash de 20 bytes":            short,
+	}
+	for name, encoded := range tests {
+		if _, _, _, err := DecodeAddress(encoded); err == nil {
+			t.Errorf("%s: se aceptó %s", name, encoded)
+		}
+	}
+
+	if _, err := EncodeAddress(DefaultAddressPrefix, 32, hash); err == nil {
+		t.Error("se codificó una versión mayor que 31")
+	}
+	if _, err := EncodeAddress(DefaultAddressPrefix, 0, hash[:10]); err == nil {
+		t.Error("se codificó un hash de longitud incorrecta")
+	}
+
+	legacy, err := ParseAddress(strings.ToUpper(hash))
+	if err != nil || legacy != hash {
+		t.Errorf("dirección heredada en mayúsculas: %s, %v", legacy, err)
+	}
+}
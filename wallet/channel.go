@@ -0,0 +1,43 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// channelTag separa los mensajes de canal de pago de las firmas de transacciones.
+const channelTag = "qubit-channel"
+
+// ChannelUpdate es un estado de un canal de pago unidireccional: el monto acumulado que el
+// pagador cede al receptor. Se intercambia fuera de la cadena; cada actualización reemplaza a
+// las anteriores y solo la última (la de mayor monto) interesa al receptor.
+type ChannelUpdate struct {
+	Channel   string `json:"channel"`
+	Amount    int64  `json:"amount"`
+	Signature string `json:"signature"`
+}
+
+// ChannelUpdateHash calcula el mensaje que firma el pagador para ceder amount en el canal.
+func ChannelUpdateHash(channel string, amount int64) []byte {
+	data := make([]byte, 0, len(channelTag)+len(channel)+8)
+	data = append(data, channelTag...)
+	data = append(data, channel...)
+	data = binary.BigEndian.AppendUint64(data, uint64(amount))
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// SignChannelUpdate firma una actualización del canal con la clave del pagador (o, al cerrar
+// de forma cooperativa, con la del receptor).
+func SignChannelUpdate(key Key, channel string, amount int64) (ChannelUpdate, error) {
+	signature, err := key.Sign(ChannelUpdateHash(channel, amount))
+	if err != nil {
+		return ChannelUpdate{}, err
+	}
+	return ChannelUpdate{Channel: channel, Amount: amount, Signature: signature}, nil
+}
+
+// Verify comprueba la firma de la actualización con la clave pública dada.
+func (u ChannelUpdate) Verify(publicKey string) error {
+	return Verify(publicKey, ChannelUpdateHash(u.Channel, u.Amount), u.Signature)
+}
@@ -0,0 +1,65 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// contractTag separa la derivación de direcciones de contrato de la de otras cuentas.
+const contractTag = "qubit-contract"
+
+// TxTypeContractDeploy es el tipo de las transacciones que despliegan un contrato WASM.
+const TxTypeContractDeploy = "contract_deploy"
+
+// Deployment es el despliegue firmado de un contrato, listo para enviarse a POST /wasm-contracts.
+type Deployment struct {
+	From      string `json:"from"`
+	Code      []byte `json:"code"`
+	Nonce     uint64 `json:"nonce"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// ContractAddress deriva la dirección del contrato que despliega deployer con el nonce dado:
+// Blake2b de la etiqueta, la dirección canónica del emisor y el nonce. Como cada nonce se usa
+// una sola vez, el emisor conoce la dirección antes de que se incluya la transacción.
+func ContractAddress(deployer string, nonce uint64) string {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(contractTag))
+	h.Write([]byte(deployer))
+	binary.Write(h, binary.BigEndian, nonce)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DeployPayload construye los datos de una transacción de despliegue.
+func DeployPayload(code []byte) (json.RawMessage, error) {
+	return json.Marshal(struct {
+		Code []byte `json:"code"`
+	}{code})
+}
+
+// NewDeployment construye y firma el despliegue de un contrato desde la cuenta de la clave. La
+// firma cubre como destino la dirección derivada del contrato.
+func NewDeployment(key Key, code []byte, nonce uint64) (*Deployment, error) {
+	payload, err := DeployPayload(code)
+	if err != nil {
+		return nil, err
+	}
+
+	from := key.Address()
+	signature, err := key.Sign(SigningHash(from, ContractAddress(from, nonce), 0, TxTypeContractDeploy, payload, nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Deployment{
+		From:      from,
+		Code:      code,
+		Nonce:     nonce,
+		PublicKey: key.PublicKey(),
+		Signature: signature,
+	}, nil
+}
@@ -0,0 +1,191 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Parámetros de derivación jerárquica (SLIP-0010 sobre NIST P-256).
+const (
+	HardenedOffset  uint32 = 0x80000000
+	CoinType        uint32 = 7331 // Tipo de moneda de Qubit en las rutas BIP-44
+	DefaultGapLimit        = 20   // Direcciones vacías consecutivas antes de detener el escaneo
+	masterSeedKey          = "Nist256p1 seed"
+	mnemonicEntropy        = 256
+)
+
+// NewMnemonic genera una frase semilla BIP-39 de 24 palabras.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropy)
+	if err != nil {
+		return "", fmt.Errorf("error generando entropía: %w", err)
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// AccountPath devuelve la ruta de derivación de la cuenta con el índice dado.
+func AccountPath(index uint32) string {
+	return fmt.Sprintf("m/44'/%d'/0'/0/%d", CoinType, index)
+}
+
+// extendedKey es una clave privada junto con su código de cadena.
+type extendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// HDWallet deriva claves de forma determinista a partir de una frase semilla.
+type HDWallet struct {
+	master extendedKey
+}
+
+// NewHDWallet crea una cartera jerárquica a partir de una frase semilla y una contraseña opcional.
+func NewHDWallet(mnemonic, passphrase string) (*HDWallet, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(strings.Join(strings.Fields(mnemonic), " "), passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("frase semilla inválida: %w", err)
+	}
+	return &HDWallet{master: masterKey(seed)}, nil
+}
+
+// Account deriva la clave de la cuenta con el índice dado.
+func (w *HDWallet) Account(index uint32) (*ecdsa.PrivateKey, error) {
+	return w.DeriveKey(AccountPath(index))
+}
+
+// DeriveKey deriva la clave privada de una ruta como m/44'/7331'/0'/0/0.
+func (w *HDWallet) DeriveKey(path string) (*ecdsa.PrivateKey, error) {
+	indexes, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := w.master
+	for _, index := range indexes {
+		key = key.child(index)
+	}
+	return privateKeyFromScalar(key.key)
+}
+
+// ScannedAccount es una cuenta encontrada al escanear la cadena.
+type ScannedAccount struct {
+	Index   uint32
+	Path    string
+	Address string
+	Key     *ecdsa.PrivateKey
+}
+
+// Scan recorre las cuentas en orden y devuelve las usadas, deteniéndose tras gapLimit
+// direcciones consecutivas sin uso. isUsed consulta la cadena por una dirección.
+func (w *HDWallet) Scan(isUsed func(address string) (bool, error), gapLimit int) ([]ScannedAccount, error) {
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+
+	var accounts []ScannedAccount
+	gap := 0
+	for index := uint32(0); gap < gapLimit; index++ {
+		key, err := w.Account(index)
+		if err != nil {
+			return nil, err
+		}
+
+		address := Address(&key.PublicKey)
+		used, err := isUsed(address)
+		if err != nil {
+			return nil, fmt.Errorf("error consultando %s: %w", address, err)
+		}
+		if !used {
+			gap++
+			continue
+		}
+
+		gap = 0
+		accounts = append(accounts, ScannedAccount{Index: index, Path: AccountPath(index), Address: address, Key: key})
+	}
+	return accounts, nil
+}
+
+// masterKey calcula la clave maestra a partir de la semilla.
+func masterKey(seed []byte) extendedKey {
+	n := elliptic.P256().Params().N
+	data := seed
+	for {
+		mac := hmac.New(sha512.New, []byte(masterSeedKey))
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		// Si la clave resultante es inválida se repite sobre el resultado anterior.
+		key := new(big.Int).SetBytes(sum[:32])
+		if key.Sign() != 0 && key.Cmp(n) < 0 {
+			return extendedKey{key: sum[:32], chainCode: sum[32:]}
+		}
+		data = sum
+	}
+}
+
+// child deriva la clave hija con el índice dado.
+func (k extendedKey) child(index uint32) extendedKey {
+	curve := elliptic.P256()
+	n := curve.Params().N
+
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		mac := hmac.New(sha512.New, k.chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		child := new(big.Int).Add(tweak, new(big.Int).SetBytes(k.key))
+		child.Mod(child, n)
+		if tweak.Cmp(n) < 0 && child.Sign() != 0 {
+			return extendedKey{key: child.FillBytes(make([]byte, 32)), chainCode: sum[32:]}
+		}
+
+		// Caso improbable de clave inválida: se reintenta según SLIP-0010.
+		data = append([]byte{0x01}, sum[32:]...)
+		data = binary.BigEndian.AppendUint32(data, index)
+	}
+}
+
+// parsePath interpreta una ruta de derivación; los índices con ' son reforzados.
+func parsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, errors.New("la ruta de derivación debe comenzar con m")
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		value, err := strconv.ParseUint(strings.TrimRight(part, "'h"), 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("índice inválido en la ruta: %s", part)
+		}
+
+		index := uint32(value)
+		if hardened {
+			index += HardenedOffset
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
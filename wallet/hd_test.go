@@ -0,0 +1,184 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestSLIP10Vectors comprueba la derivación con los vectores de SLIP-0010 para NIST P-256,
+// incluidos los casos en que se repite el cálculo de la semilla o de un hijo.
+func TestSLIP10Vectors(t *testing.T) {
+	tests := []struct {
+		seed      string
+		path      []uint32
+		chainCode string
+		key       string
+	}{
+		// Vector 1
+		{"000102030405060708090a0b0c0d0e0f", nil,
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset},
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset, 1},
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset, 1, HardenedOffset + 2},
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset, 1, HardenedOffset + 2, 2},
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset, 1, HardenedOffset + 2, 2, 1000000000},
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119"},
+		// Vector 2
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", nil,
+			"96cd4465a9644e31528eda3592aa35eb39a9527769ce1855beafc1b81055e75d",
+			"eaa31c2e46ca2962227cf21d73a7ef0ce8b31c756897521eb6c7b39796633357"},
+		// Repetición en la derivación de un hijo
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset + 28578},
+			"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669"},
+		{"000102030405060708090a0b0c0d0e0f", []uint32{HardenedOffset + 28578, 33941},
+			"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+			"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a"},
+		// Repetición en la derivación de la clave maestra
+		{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", nil,
+			"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f"},
+	}
+	for _, tt := range tests {
+		key := masterKey(decodeHex(t, tt.seed))
+		for _, index := range tt.path {
+			key = key.child(index)
+		}
+		if got := hex.EncodeToString(key.chainCode); got != tt.chainCode {
+			t.Errorf("%s %v: código de cadena %s, se esperaba %s", tt.seed[:8], tt.path, got, tt.chainCode)
+		}
+		if got := hex.EncodeToString(key.key); got != tt.key {
+			t.Errorf("%s %v: clave %s, se esperaba %s", tt.seed[:8], tt.path, got, tt.key)
+		}
+	}
+}
+
+// TestHDWalletMnemonicSeed comprueba que la frase semilla se convierta en la semilla del
+// vector de BIP-39 con contraseña "TREZOR", y que se rechacen frases con checksum incorrecto.
+func TestHDWalletMnemonicSeed(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+
+	// Los espacios de más no cambian la frase.
+	w, err := NewHDWallet("  "+mnemonic+"\n", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := masterKey(decodeHex(t, seed))
+	if hex.EncodeToString(w.master.key) != hex.EncodeToString(want.key) {
+		t.Error("la clave maestra no corresponde a la semilla BIP-39")
+	}
+
+	invalid := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"
+	if _, err := NewHDWallet(invalid, ""); err == nil {
+		t.Error("se aceptó una frase semilla con checksum incorrecto")
+	}
+}
+
+// TestHDWalletAccounts comprueba que las cuentas se deriven de la ruta BIP-44 de Qubit y que el
+// escaneo se detenga tras gapLimit cuentas sin uso.
+func TestHDWalletAccounts(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewHDWallet(mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path := AccountPath(3); path != "m/44'/7331'/0'/0/3" {
+		t.Errorf("ruta de la cuenta 3: %s", path)
+	}
+	account, err := w.Account(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := w.DeriveKey("m/44h/7331h/0h/0/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.Equal(derived) {
+		t.Error("la cuenta 3 no coincide con su ruta")
+	}
+
+	used := map[string]bool{}
+	var keys []*ecdsa.PrivateKey
+	for _, index := range []uint32{0, 2, 5} {
+		key, err := w.Account(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		used[Address(&key.PublicKey)] = true
+		keys = append(keys, key)
+	}
+	found, err := w.Scan(func(address string) (bool, error) { return used[address], nil }, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 || found[2].Index != 5 || !found[2].Key.Equal(keys[2]) {
+		t.Errorf("cuentas encontradas: %+v", found)
+	}
+
+	// Con un margen de 2, la cuenta 5 queda tras tres cuentas vacías y no se encuentra.
+	found, err = w.Scan(func(address string) (bool, error) { return used[address], nil }, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("con margen 2 se encontraron %d cuentas, se esperaban 2", len(found))
+	}
+}
+
+// TestParsePath comprueba la interpretación de las rutas de derivación.
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []uint32
+		ok   bool
+	}{
+		{"m", []uint32{}, true},
+		{"m/0'/1", []uint32{HardenedOffset, 1}, true},
+		{"m/44h/7331'/0", []uint32{HardenedOffset + 44, HardenedOffset + 7331, 0}, true},
+		{"44'/0", nil, false},
+		{"m/x", nil, false},
+		{"m/2147483648", nil, false}, // Fuera del rango de índices sin reforzar
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.path, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %v, se esperaba %v", tt.path, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: %v, se esperaba %v", tt.path, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// KeyType identifica el esquema de firma de una clave.
+type KeyType string
+
+// Esquemas de firma soportados.
+const (
+	KeyTypeP256    KeyType = "p256"    // ECDSA sobre P-256 (por defecto)
+	KeyTypeEd25519 KeyType = "ed25519" // Ed25519, para firmantes de hardware que solo lo soportan
+)
+
+// ed25519Prefix etiqueta las claves públicas Ed25519. Las claves P-256 se codifican sin
+// etiqueta para que las direcciones y claves existentes sigan siendo válidas.
+const ed25519Prefix = "ed25519:"
+
+// Key es una clave privada de cualquiera de los esquemas de firma soportados.
+type Key interface {
+	Type() KeyType
+	PublicKey() string                // Clave pública codificada con su etiqueta de tipo
+	Address() string                  // Dirección de la cuenta (hash hexadecimal)
+	Sign(hash []byte) (string, error) // Firma en hexadecimal
+	Bytes() []byte                    // Material privado: escalar P-256 o semilla Ed25519
+}
+
+// ParseKeyType interpreta el nombre de un esquema de firma; vacío equivale a P-256.
+func ParseKeyType(name string) (KeyType, error) {
+	switch KeyType(strings.ToLower(name)) {
+	case "", KeyTypeP256:
+		return KeyTypeP256, nil
+	case KeyTypeEd25519:
+		return KeyTypeEd25519, nil
+	default:
+		return "", fmt.Errorf("tipo de clave desconocido: %s", name)
+	}
+}
+
+// AddressVersion devuelve la versión de dirección de las cuentas de este esquema.
+func (t KeyType) AddressVersion() byte {
+	if t == KeyTypeEd25519 {
+		return AddressVersionEd25519
+	}
+	return AddressVersionP256
+}
+
+// NewKey genera una nueva clave privada del tipo indicado.
+func NewKey(keyType KeyType) (Key, error) {
+	switch keyType {
+	case KeyTypeP256:
+		privateKey, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		return P256Key(privateKey), nil
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519Key{privateKey}, nil
+	default:
+		return nil, fmt.Errorf("tipo de clave desconocido: %s", keyType)
+	}
+}
+
+// ParseKey reconstruye una clave privada del tipo indicado a partir de su material en hexadecimal.
+func ParseKey(keyType KeyType, encoded string) (Key, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("clave privada no es hexadecimal válido: %w", err)
+	}
+	return keyFromBytes(keyType, data)
+}
+
+// keyFromBytes construye una clave a partir de su material privado.
+func keyFromBytes(keyType KeyType, data []byte) (Key, error) {
+	switch keyType {
+	case KeyTypeP256:
+		privateKey, err := privateKeyFromScalar(data)
+		if err != nil {
+			return nil, err
+		}
+		return P256Key(privateKey), nil
+	case KeyTypeEd25519:
+		if len(data) != ed25519.SeedSize {
+			return nil, fmt.Errorf("la semilla Ed25519 debe tener %d bytes", ed25519.SeedSize)
+		}
+		return ed25519Key{ed25519.NewKeyFromSeed(data)}, nil
+	default:
+		return nil, fmt.Errorf("tipo de clave desconocido: %s", keyType)
+	}
+}
+
+// P256Key adapta una clave privada ECDSA P-256 a la interfaz Key.
+func P256Key(privateKey *ecdsa.PrivateKey) Key {
+	return p256Key{privateKey}
+}
+
+type p256Key struct {
+	*ecdsa.PrivateKey
+}
+
+func (k p256Key) Type() KeyType                    { return KeyTypeP256 }
+func (k p256Key) PublicKey() string                { return EncodePublicKey(&k.PrivateKey.PublicKey) }
+func (k p256Key) Address() string                  { return Address(&k.PrivateKey.PublicKey) }
+func (k p256Key) Sign(hash []byte) (string, error) { return Sign(k.PrivateKey, hash) }
+func (k p256Key) Bytes() []byte                    { return k.D.FillBytes(make([]byte, 32)) }
+
+type ed25519Key struct {
+	ed25519.PrivateKey
+}
+
+func (k ed25519Key) Type() KeyType { return KeyTypeEd25519 }
+
+func (k ed25519Key) PublicKey() string {
+	return ed25519Prefix + hex.EncodeToString(k.Public().(ed25519.PublicKey))
+}
+
+func (k ed25519Key) Address() string {
+	return ed25519Address(k.Public().(ed25519.PublicKey))
+}
+
+func (k ed25519Key) Sign(hash []byte) (string, error) {
+	return hex.EncodeToString(ed25519.Sign(k.PrivateKey, hash)), nil
+}
+
+func (k ed25519Key) Bytes() []byte { return k.Seed() }
+
+// ed25519Address deriva la dirección de una clave Ed25519. La etiqueta separa su espacio
+// de direcciones del de las claves P-256.
+func ed25519Address(pub ed25519.PublicKey) string {
+	address := blake2b.Sum256(append([]byte(KeyTypeEd25519), pub...))
+	return hex.EncodeToString(address[:])
+}
+
+// PublicKeyType devuelve el esquema de firma de una clave pública codificada.
+func PublicKeyType(encoded string) KeyType {
+	if strings.HasPrefix(encoded, ed25519Prefix) {
+		return KeyTypeEd25519
+	}
+	return KeyTypeP256
+}
+
+// parseEd25519PublicKey decodifica una clave pública Ed25519 etiquetada.
+func parseEd25519PublicKey(encoded string) (ed25519.PublicKey, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(encoded, ed25519Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("clave pública no es hexadecimal válido: %w", err)
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("clave pública Ed25519 inválida")
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// publicKeyBytes decodifica una clave pública de cualquier esquema. Las claves Ed25519
+// conservan su etiqueta, para que no coincidan con los bytes de ninguna clave P-256.
+func publicKeyBytes(encoded string) ([]byte, error) {
+	if PublicKeyType(encoded) == KeyTypeEd25519 {
+		pub, err := parseEd25519PublicKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(ed25519Prefix), pub...), nil
+	}
+
+	pub, err := ParsePublicKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return elliptic.Marshal(elliptic.P256(), pub.X, pub.Y), nil
+}
+
+// NormalizePublicKey valida una clave pública de cualquier esquema y devuelve su codificación canónica.
+func NormalizePublicKey(encoded string) (string, error) {
+	if PublicKeyType(encoded) == KeyTypeEd25519 {
+		pub, err := parseEd25519PublicKey(encoded)
+		if err != nil {
+			return "", err
+		}
+		return ed25519Prefix + hex.EncodeToString(pub), nil
+	}
+
+	pub, err := ParsePublicKey(encoded)
+	if err != nil {
+		return "", err
+	}
+	return EncodePublicKey(pub), nil
+}
+
+// AddressFromPublicKey deriva la dirección y su versión a partir de una clave pública codificada
+// de cualquier esquema.
+func AddressFromPublicKey(encoded string) (string, byte, error) {
+	if PublicKeyType(encoded) == KeyTypeEd25519 {
+		pub, err := parseEd25519PublicKey(encoded)
+		if err != nil {
+			return "", 0, err
+		}
+		return ed25519Address(pub), AddressVersionEd25519, nil
+	}
+
+	pub, err := ParsePublicKey(encoded)
+	if err != nil {
+		return "", 0, err
+	}
+	return Address(pub), AddressVersionP256, nil
+}
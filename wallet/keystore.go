@@ -0,0 +1,240 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parámetros de scrypt usados al cifrar nuevas claves.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// ErrWrongPassphrase indica que la contraseña no descifra el archivo de claves.
+var ErrWrongPassphrase = errors.New("contraseña incorrecta")
+
+// KeyFile es el formato en disco de una clave privada cifrada con contraseña.
+type KeyFile struct {
+	Version   int          `json:"version"`
+	Address   string       `json:"address"`
+	KeyType   KeyType      `json:"key_type,omitempty"` // Vacío en archivos P-256 anteriores a Ed25519
+	PublicKey string       `json:"public_key"`
+	Crypto    CryptoParams `json:"crypto"`
+}
+
+// CryptoParams describe cómo se cifró la clave privada (scrypt + AES-256-GCM).
+type CryptoParams struct {
+	KDF        string    `json:"kdf"`
+	KDFParams  KDFParams `json:"kdfparams"`
+	Cipher     string    `json:"cipher"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+}
+
+// KDFParams son los parámetros de derivación de la clave de cifrado.
+type KDFParams struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"dklen"`
+	Salt   string `json:"salt"`
+}
+
+// EncryptKey cifra una clave privada con una contraseña.
+func EncryptKey(key Key, passphrase string) (*KeyFile, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generando sal: %w", err)
+	}
+
+	params := KDFParams{N: scryptN, R: scryptR, P: scryptP, KeyLen: scryptKeyLen, Salt: hex.EncodeToString(salt)}
+	gcm, err := newGCM(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generando nonce: %w", err)
+	}
+
+	address := key.Address()
+	plaintext := key.Bytes()
+	// La dirección se autentica como dato adicional para detectar archivos manipulados.
+	ciphertext := gcm.Seal(nil, nonce, plaintext, []byte(address))
+
+	return &KeyFile{
+		Version:   1,
+		Address:   address,
+		KeyType:   key.Type(),
+		PublicKey: key.PublicKey(),
+		Crypto: CryptoParams{
+			KDF:        "scrypt",
+			KDFParams:  params,
+			Cipher:     "aes-256-gcm",
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: hex.EncodeToString(ciphertext),
+		},
+	}, nil
+}
+
+// DecryptKey descifra la clave privada de un archivo de claves.
+func DecryptKey(keyFile *KeyFile, passphrase string) (Key, error) {
+	if keyFile.Crypto.KDF != "scrypt" || keyFile.Crypto.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("cifrado no soportado: %s/%s", keyFile.Crypto.KDF, keyFile.Crypto.Cipher)
+	}
+
+	gcm, err := newGCM(passphrase, keyFile.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := hex.DecodeString(keyFile.Crypto.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("nonce inválido en el archivo de claves")
+	}
+	ciphertext, err := hex.DecodeString(keyFile.Crypto.Ciphertext)
+	if err != nil {
+		return nil, errors.New("texto cifrado inválido en el archivo de claves")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(keyFile.Address))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	keyType, err := ParseKeyType(string(keyFile.KeyType))
+	if err != nil {
+		return nil, err
+	}
+	key, err := keyFromBytes(keyType, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if key.Address() != keyFile.Address {
+		return nil, errors.New("la clave descifrada no corresponde a la dirección del archivo")
+	}
+	return key, nil
+}
+
+// newGCM deriva la clave de cifrado con scrypt y crea el cifrador AES-GCM.
+func newGCM(passphrase string, params KDFParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, errors.New("sal inválida en el archivo de claves")
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("error derivando la clave de cifrado: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creando el cifrador: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keystore guarda archivos de claves cifradas en un directorio, uno por dirección.
+type Keystore struct {
+	Dir string
+}
+
+// NewKeystore crea (si no existe) el directorio del almacén de claves.
+func NewKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creando el directorio de claves: %w", err)
+	}
+	return &Keystore{Dir: dir}, nil
+}
+
+// Store cifra y guarda una clave privada, devolviendo su dirección.
+func (ks *Keystore) Store(key Key, passphrase string) (string, error) {
+	keyFile, err := EncryptKey(key, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	path := ks.path(keyFile.Address)
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("la clave de %s ya existe en el almacén", keyFile.Address)
+	}
+
+	data, err := json.MarshalIndent(keyFile, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error serializando el archivo de claves: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("error guardando el archivo de claves: %w", err)
+	}
+	return keyFile.Address, nil
+}
+
+// Load descifra la clave privada de una dirección del almacén.
+func (ks *Keystore) Load(address, passphrase string) (Key, error) {
+	address, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile, err := ks.read(ks.path(address))
+	if err != nil {
+		return nil, err
+	}
+	return DecryptKey(keyFile, passphrase)
+}
+
+// List devuelve los archivos de claves del almacén ordenados por dirección.
+func (ks *Keystore) List() ([]KeyFile, error) {
+	paths, err := filepath.Glob(filepath.Join(ks.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keyFiles := make([]KeyFile, 0, len(paths))
+	for _, path := range paths {
+		keyFile, err := ks.read(path)
+		if err != nil {
+			return nil, err
+		}
+		keyFiles = append(keyFiles, *keyFile)
+	}
+	return keyFiles, nil
+}
+
+// read carga un archivo de claves del disco.
+func (ks *Keystore) read(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no existe la clave %s en el almacén", strings.TrimSuffix(filepath.Base(path), ".json"))
+	} else if err != nil {
+		return nil, fmt.Errorf("error leyendo el archivo de claves: %w", err)
+	}
+
+	var keyFile KeyFile
+	if err := json.Unmarshal(data, &keyFile); err != nil {
+		return nil, fmt.Errorf("archivo de claves inválido %s: %w", path, err)
+	}
+	return &keyFile, nil
+}
+
+// path devuelve la ruta del archivo de claves de una dirección.
+func (ks *Keystore) path(address string) string {
+	return filepath.Join(ks.Dir, address+".json")
+}
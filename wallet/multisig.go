@@ -0,0 +1,113 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// MaxMultisigKeys es el número máximo de claves de una política multifirma.
+const MaxMultisigKeys = 16
+
+// multisigTag separa el espacio de direcciones multifirma del de claves individuales.
+const multisigTag = "qubit-multisig"
+
+// MultisigPolicy describe una cuenta que requiere Threshold firmas de entre PublicKeys.
+type MultisigPolicy struct {
+	Threshold  int      `json:"threshold"`
+	PublicKeys []string `json:"public_keys"` // Claves públicas normalizadas, ordenadas
+}
+
+// NewMultisigPolicy valida una política M de N y ordena sus claves, de modo que la misma
+// combinación de claves produzca siempre la misma dirección.
+func NewMultisigPolicy(threshold int, publicKeys []string) (*MultisigPolicy, error) {
+	if len(publicKeys) == 0 || len(publicKeys) > MaxMultisigKeys {
+		return nil, fmt.Errorf("una cuenta multifirma necesita entre 1 y %d claves", MaxMultisigKeys)
+	}
+	if threshold < 1 || threshold > len(publicKeys) {
+		return nil, fmt.Errorf("umbral %d inválido para %d claves", threshold, len(publicKeys))
+	}
+
+	keys := make([]string, 0, len(publicKeys))
+	seen := make(map[string]bool)
+	for _, encoded := range publicKeys {
+		key, err := NormalizePublicKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
+			return nil, errors.New("la política multifirma contiene claves duplicadas")
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return &MultisigPolicy{Threshold: threshold, PublicKeys: keys}, nil
+}
+
+// Address deriva la dirección de la cuenta: Blake2b de la etiqueta, el umbral y las claves
+// ordenadas, decodificadas según su esquema.
+func (p *MultisigPolicy) Address() (string, error) {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(multisigTag))
+	binary.Write(h, binary.BigEndian, uint32(p.Threshold))
+	for _, key := range p.PublicKeys {
+		data, err := publicKeyBytes(key)
+		if err != nil {
+			return "", err
+		}
+		binary.Write(h, binary.BigEndian, uint32(len(data)))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Equal indica si dos políticas tienen el mismo umbral y las mismas claves.
+func (p *MultisigPolicy) Equal(other *MultisigPolicy) bool {
+	if p.Threshold != other.Threshold || len(p.PublicKeys) != len(other.PublicKeys) {
+		return false
+	}
+	for i, key := range p.PublicKeys {
+		if other.PublicKeys[i] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// HasKey indica si la clave pública pertenece a la política.
+func (p *MultisigPolicy) HasKey(publicKey string) bool {
+	for _, key := range p.PublicKeys {
+		if key == publicKey {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySignatures comprueba que al menos Threshold claves distintas de la política firmaron el hash.
+func (p *MultisigPolicy) VerifySignatures(hash []byte, signatures []Signature) error {
+	signers := make(map[string]bool)
+	for _, sig := range signatures {
+		if !p.HasKey(sig.PublicKey) {
+			return fmt.Errorf("la clave %s no pertenece a la cuenta multifirma", sig.PublicKey)
+		}
+		if signers[sig.PublicKey] {
+			return fmt.Errorf("la clave %s firmó más de una vez", sig.PublicKey)
+		}
+		if err := Verify(sig.PublicKey, hash, sig.Signature); err != nil {
+			return err
+		}
+		signers[sig.PublicKey] = true
+	}
+
+	if len(signers) < p.Threshold {
+		return fmt.Errorf("firmas insuficientes: %d de %d requeridas", len(signers), p.Threshold)
+	}
+	return nil
+}
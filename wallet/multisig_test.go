@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"testing"
+)
+
+func newTestKeys(t *testing.T, keyType KeyType, n int) []string {
+	t.Helper()
+	keys := make([]string, n)
+	for i := range keys {
+		key, err := NewKey(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key.PublicKey()
+	}
+	return keys
+}
+
+func policyAddress(t *testing.T, threshold int, keys []string) string {
+	t.Helper()
+	policy, err := NewMultisigPolicy(threshold, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := policy.Address()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address
+}
+
+// TestMultisigAddressDependsOnKeys comprueba que políticas con claves distintas tengan
+// direcciones distintas en ambos esquemas de firma.
+func TestMultisigAddressDependsOnKeys(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeP256, KeyTypeEd25519} {
+		first := newTestKeys(t, keyType, 2)
+		second := newTestKeys(t, keyType, 2)
+		for _, threshold := range []int{1, 2} {
+			a, b := policyAddress(t, threshold, first), policyAddress(t, threshold, second)
+			if a == b {
+				t.Errorf("%s: dos políticas %d de 2 distintas comparten la dirección %s", keyType, threshold, a)
+			}
+		}
+		if policyAddress(t, 1, first[:1]) == policyAddress(t, 1, second[:1]) {
+			t.Errorf("%s: dos políticas 1 de 1 distintas comparten dirección", keyType)
+		}
+	}
+}
+
+// TestMultisigAddressIgnoresKeyOrder comprueba que el orden de las claves no cambie la
+// dirección y que el umbral sí la cambie.
+func TestMultisigAddressIgnoresKeyOrder(t *testing.T) {
+	keys := append(newTestKeys(t, KeyTypeP256, 2), newTestKeys(t, KeyTypeEd25519, 1)...)
+	reversed := []string{keys[2], keys[1], keys[0]}
+
+	if policyAddress(t, 2, keys) != policyAddress(t, 2, reversed) {
+		t.Error("el orden de las claves cambió la dirección")
+	}
+	if policyAddress(t, 2, keys) == policyAddress(t, 3, keys) {
+		t.Error("el umbral no cambió la dirección")
+	}
+}
+
+// TestMultisigAddressRejectsInvalidKeys comprueba que una clave que no se puede decodificar
+// haga fallar la derivación en lugar de ignorarse.
+func TestMultisigAddressRejectsInvalidKeys(t *testing.T) {
+	policies := []*MultisigPolicy{
+		{Threshold: 1, PublicKeys: []string{"ed25519:zz"}},
+		{Threshold: 1, PublicKeys: []string{"0102"}},
+	}
+	for _, policy := range policies {
+		if address, err := policy.Address(); err == nil {
+			t.Errorf("la política %v derivó la dirección %s", policy.PublicKeys, address)
+		}
+	}
+}
+
+// TestNewMultisigPolicy comprueba los límites del umbral y del número de claves.
+func TestNewMultisigPolicy(t *testing.T) {
+	keys := newTestKeys(t, KeyTypeEd25519, MaxMultisigKeys+1)
+	tests := []struct {
+		name      string
+		threshold int
+		keys      []string
+		ok        bool
+	}{
+		{"1 de 1", 1, keys[:1], true},
+		{"2 de 3", 2, keys[:3], true},
+		{"máximo de claves", MaxMultisigKeys, keys[:MaxMultisigKeys], true},
+		{"sin claves", 1, nil, false},
+		{"demasiadas claves", 1, keys, false},
+		{"umbral cero", 0, keys[:2], false},
+		{"umbral mayor que las claves", 3, keys[:2], false},
+		{"claves duplicadas", 1, []string{keys[0], keys[0]}, false},
+		{"clave inválida", 1, []string{"ed25519:00"}, false},
+	}
+	for _, tt := range tests {
+		_, err := NewMultisigPolicy(tt.threshold, tt.keys)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+}
+
+// TestMultisigVerifySignatures comprueba que solo se acepten firmas distintas de claves de
+// la política que alcancen el umbral.
+func TestMultisigVerifySignatures(t *testing.T) {
+	var keys []Key
+	var publicKeys []string
+	for _, keyType := range []KeyType{KeyTypeP256, KeyTypeEd25519, KeyTypeEd25519} {
+		key, err := NewKey(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+		publicKeys = append(publicKeys, key.PublicKey())
+	}
+	policy, err := NewMultisigPolicy(2, publicKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider, err := NewKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := SigningHash("a", "b", 10, "", nil, 1)
+	sign := func(key Key) Signature {
+		signature, err := key.Sign(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Signature{PublicKey: key.PublicKey(), Signature: signature}
+	}
+
+	tests := []struct {
+		name       string
+		signatures []Signature
+		ok         bool
+	}{
+		{"umbral alcanzado", []Signature{sign(keys[0]), sign(keys[2])}, true},
+		{"todas las claves", []Signature{sign(keys[0]), sign(keys[1]), sign(keys[2])}, true},
+		{"firmas insuficientes", []Signature{sign(keys[1])}, false},
+		{"firma repetida", []Signature{sign(keys[1]), sign(keys[1])}, false},
+		{"clave ajena", []Signature{sign(keys[0]), sign(outsider)}, false},
+	}
+	for _, tt := range tests {
+		if err := policy.VerifySignatures(hash, tt.signatures); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Transfer es una transferencia firmada lista para enviarse a POST /transactions.
+type Transfer struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Amount    int64  `json:"amount"`
+	Nonce     uint64 `json:"nonce"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// signingData son los campos de una transacción cubiertos por la firma.
+type signingData struct {
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Amount  int64           `json:"amount"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Nonce   uint64          `json:"nonce"`
+}
+
+// CanonicalPayload normaliza los datos de una transacción (claves ordenadas, sin espacios)
+// para que el hash no dependa del formato con que se almacenó el JSON.
+func CanonicalPayload(payload json.RawMessage) json.RawMessage {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return payload
+	}
+
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return payload
+	}
+	return canonical
+}
+
+// SigningHash calcula el mensaje que firma el emisor de una transacción.
+func SigningHash(from, to string, amount int64, txType string, payload json.RawMessage, nonce uint64) []byte {
+	data, _ := json.Marshal(signingData{
+		From:    from,
+		To:      to,
+		Amount:  amount,
+		Type:    txType,
+		Payload: CanonicalPayload(payload),
+		Nonce:   nonce,
+	})
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Sign firma un hash con la clave privada y devuelve la firma ASN.1 en hexadecimal.
+func Sign(privateKey *ecdsa.PrivateKey, hash []byte) (string, error) {
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, hash)
+	if err != nil {
+		return "", fmt.Errorf("error firmando: %w", err)
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+// Signature es una firma parcial de una transacción multifirma.
+type Signature struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// Verify comprueba una firma en hexadecimal sobre un hash con una clave pública codificada,
+// según el esquema indicado por la etiqueta de la clave (ASN.1 para P-256).
+func Verify(publicKey string, hash []byte, signature string) error {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("firma no es hexadecimal válido: %w", err)
+	}
+
+	if PublicKeyType(publicKey) == KeyTypeEd25519 {
+		pub, err := parseEd25519PublicKey(publicKey)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(pub, hash, sig) {
+			return errors.New("firma inválida")
+		}
+		return nil
+	}
+
+	pub, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	if !ecdsa.VerifyASN1(pub, hash, sig) {
+		return errors.New("firma inválida")
+	}
+	return nil
+}
+
+// NewTransfer construye y firma una transferencia simple desde la cuenta de la clave privada.
+// El destino puede indicarse en cualquier formato; la firma cubre siempre el hash hexadecimal.
+func NewTransfer(key Key, to string, amount int64, nonce uint64) (*Transfer, error) {
+	to, err := ParseAddress(to)
+	if err != nil {
+		return nil, err
+	}
+
+	from := key.Address()
+	signature, err := key.Sign(SigningHash(from, to, amount, "", nil, nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transfer{
+		From:      from,
+		To:        to,
+		Amount:    amount,
+		Nonce:     nonce,
+		PublicKey: key.PublicKey(),
+		Signature: signature,
+	}, nil
+}
+
+// TxAuth son los campos de autenticación que acompañan a una transacción firmada.
+type TxAuth struct {
+	Nonce     uint64 `json:"nonce"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// Authorize firma una transacción de cualquier tipo desde la cuenta de la clave y devuelve
+// sus campos de autenticación. El destino debe estar en forma canónica (hash hexadecimal).
+func Authorize(key Key, to string, amount int64, txType string, payload json.RawMessage, nonce uint64) (TxAuth, error) {
+	signature, err := key.Sign(SigningHash(key.Address(), to, amount, txType, payload, nonce))
+	if err != nil {
+		return TxAuth{}, err
+	}
+	return TxAuth{Nonce: nonce, PublicKey: key.PublicKey(), Signature: signature}, nil
+}
+
+// Output es un destinatario de un pago múltiple.
+type Output struct {
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+}
+
+// Batch es un pago múltiple firmado listo para enviarse a POST /transactions/batch.
+type Batch struct {
+	From      string   `json:"from"`
+	Outputs   []Output `json:"outputs"`
+	Nonce     uint64   `json:"nonce"`
+	PublicKey string   `json:"public_key"`
+	Signature string   `json:"signature"`
+}
+
+// TxTypeBatch es el tipo de las transacciones de pago múltiple.
+const TxTypeBatch = "batch"
+
+// BatchPayload construye los datos de un pago múltiple y su monto total. Los destinos se
+// normalizan al hash hexadecimal que cubre la firma.
+func BatchPayload(outputs []Output) (json.RawMessage, int64, error) {
+	normalized := make([]Output, len(outputs))
+	var total int64
+	for i, output := range outputs {
+		to, err := ParseAddress(output.To)
+		if err != nil {
+			return nil, 0, fmt.Errorf("salida %d: %w", i, err)
+		}
+		if output.Amount <= 0 || total+output.Amount < total {
+			return nil, 0, fmt.Errorf("salida %d: monto inválido", i)
+		}
+		normalized[i] = Output{To: to, Amount: output.Amount}
+		total += output.Amount
+	}
+
+	payload, err := json.Marshal(struct {
+		Outputs []Output `json:"outputs"`
+	}{normalized})
+	if err != nil {
+		return nil, 0, err
+	}
+	return payload, total, nil
+}
+
+// NewBatch construye y firma un pago múltiple desde la cuenta de la clave privada.
+func NewBatch(key Key, outputs []Output, nonce uint64) (*Batch, error) {
+	payload, total, err := BatchPayload(outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	from := key.Address()
+	signature, err := key.Sign(SigningHash(from, "", total, TxTypeBatch, payload, nonce))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Batch{
+		From:      from,
+		Outputs:   outputs,
+		Nonce:     nonce,
+		PublicKey: key.PublicKey(),
+		Signature: signature,
+	}, nil
+}
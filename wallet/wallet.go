@@ -0,0 +1,79 @@
+// Package wallet permite a los clientes generar claves y derivar direcciones de Qubit sin
+// conexión, usando el mismo esquema que el nodo (Blake2b de la clave pública P-256 o Ed25519).
+// Las claves privadas nunca necesitan salir del cliente.
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// GenerateKey genera una nueva clave privada P-256.
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// Address deriva la dirección de una cuenta a partir de su clave pública P-256.
+func Address(pub *ecdsa.PublicKey) string {
+	// Crear un hash Blake2b de la clave pública
+	pubKey := append(pub.X.Bytes(), pub.Y.Bytes()...)
+	address := blake2b.Sum256(pubKey)
+
+	// Convertir la dirección a formato hexadecimal
+	return hex.EncodeToString(address[:])
+}
+
+// EncodePublicKey codifica una clave pública P-256 en hexadecimal (formato SEC1 sin comprimir).
+func EncodePublicKey(pub *ecdsa.PublicKey) string {
+	return hex.EncodeToString(elliptic.Marshal(elliptic.P256(), pub.X, pub.Y))
+}
+
+// ParsePublicKey decodifica una clave pública P-256 codificada con EncodePublicKey
+// y verifica que el punto pertenezca a la curva.
+func ParsePublicKey(encoded string) (*ecdsa.PublicKey, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("clave pública no es hexadecimal válido: %w", err)
+	}
+
+	x, y := elliptic.Unmarshal(elliptic.P256(), data)
+	if x == nil {
+		return nil, errors.New("clave pública P-256 inválida")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// EncodePrivateKey codifica el escalar de una clave privada en hexadecimal de 32 bytes.
+func EncodePrivateKey(privateKey *ecdsa.PrivateKey) string {
+	return hex.EncodeToString(privateKey.D.FillBytes(make([]byte, 32)))
+}
+
+// ParsePrivateKey reconstruye una clave privada P-256 a partir de su escalar en hexadecimal.
+func ParsePrivateKey(encoded string) (*ecdsa.PrivateKey, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("clave privada no es hexadecimal válido: %w", err)
+	}
+	return privateKeyFromScalar(data)
+}
+
+// privateKeyFromScalar construye una clave privada P-256 a partir de su escalar.
+func privateKeyFromScalar(scalar []byte) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(scalar)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("clave privada fuera de rango")
+	}
+
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return privateKey, nil
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"blockchain-go/wallet"
+
+	"golang.org/x/term"
+)
+
+const walletUsage = `Uso: qubit wallet <comando> [opciones]
+
+Comandos:
+  create     Genera una nueva clave y la guarda cifrada en el almacén (-type p256|ed25519)
+  import     Importa una clave privada en hexadecimal (-key, -type)
+  list       Lista las direcciones del almacén
+  transfer   Firma una transferencia y la envía al nodo (-from, -to, -amount)
+  batch      Firma un pago múltiple desde un CSV "dirección,monto" y lo envía (-from, -file)
+  mnemonic   Genera una frase semilla para una cartera jerárquica
+  recover    Recupera desde una frase semilla las cuentas usadas en la cadena (-gap)
+  cosign     Firma una propuesta de transferencia multifirma (-from, -proposal)
+  channel-pay Firma fuera de la cadena el monto acumulado cedido en un canal de pago (-from, -channel, -amount)
+  deploy     Firma el despliegue de un contrato WASM y lo envía al nodo (-from, -file)
+
+Opciones comunes:
+  -keystore  Directorio del almacén de claves (por defecto ./keystore)
+  -node      URL del nodo (por defecto http://localhost:8080)
+  -prefix    Prefijo de red de las direcciones (por defecto qbt)`
+
+// runWallet ejecuta el subcomando `wallet` del binario.
+func runWallet(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(walletUsage)
+		return errors.New("falta el comando de wallet")
+	}
+
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("wallet "+command, flag.ExitOnError)
+	keystoreDir := flags.String("keystore", "keystore", "directorio del almacén de claves")
+	node := flags.String("node", "http://localhost:8080", "URL del nodo")
+	flags.StringVar(&addressPrefix, "prefix", wallet.DefaultAddressPrefix, "prefijo de red de las direcciones")
+
+	switch command {
+	case "create":
+		keyType := flags.String("type", string(wallet.KeyTypeP256), "esquema de firma: p256 o ed25519")
+		flags.Parse(args)
+		return walletCreate(*keystoreDir, *keyType)
+	case "import":
+		key := flags.String("key", "", "clave privada en hexadecimal (escalar P-256 o semilla Ed25519)")
+		keyType := flags.String("type", string(wallet.KeyTypeP256), "esquema de firma: p256 o ed25519")
+		flags.Parse(args)
+		return walletImport(*keystoreDir, *key, *keyType)
+	case "list":
+		flags.Parse(args)
+		return walletList(*keystoreDir)
+	case "transfer":
+		from := flags.String("from", "", "dirección emisora (debe estar en el almacén)")
+		to := flags.String("to", "", "dirección destino")
+		amount := flags.Int64("amount", 0, "monto a transferir")
+		flags.Parse(args)
+		return walletTransfer(*keystoreDir, *node, *from, *to, *amount)
+	case "batch":
+		from := flags.String("from", "", "dirección emisora (debe estar en el almacén)")
+		file := flags.String("file", "", "archivo CSV con una línea dirección,monto por destinatario")
+		flags.Parse(args)
+		return walletBatch(*keystoreDir, *node, *from, *file)
+	case "mnemonic":
+		flags.Parse(args)
+		return walletMnemonic()
+	case "recover":
+		gap := flags.Int("gap", wallet.DefaultGapLimit, "direcciones vacías consecutivas antes de detener el escaneo")
+		flags.Parse(args)
+		return walletRecover(*keystoreDir, *node, *gap)
+	case "cosign":
+		from := flags.String("from", "", "dirección del titular que firma (debe estar en el almacén)")
+		proposal := flags.Int("proposal", 0, "identificador de la propuesta")
+		flags.Parse(args)
+		return walletCosign(*keystoreDir, *node, *from, *proposal)
+	case "channel-pay":
+		from := flags.String("from", "", "dirección del pagador (debe estar en el almacén)")
+		channel := flags.String("channel", "", "identificador del canal")
+		amount := flags.Int64("amount", 0, "monto acumulado cedido al receptor")
+		flags.Parse(args)
+		return walletChannelPay(*keystoreDir, *from, *channel, *amount)
+	case "deploy":
+		from := flags.String("from", "", "dirección del desplegador (debe estar en el almacén)")
+		file := flags.String("file", "", "módulo WASM del contrato")
+		flags.Parse(args)
+		return walletDeploy(*keystoreDir, *node, *from, *file)
+	default:
+		fmt.Println(walletUsage)
+		return fmt.Errorf("comando de wallet desconocido: %s", command)
+	}
+}
+
+// addressPrefix es el prefijo de red con el que se muestran las direcciones.
+var addressPrefix = wallet.DefaultAddressPrefix
+
+// displayAddress codifica una dirección con prefijo de red, la versión de su esquema de firma
+// y checksum.
+func displayAddress(address string, keyType wallet.KeyType) string {
+	encoded, err := wallet.EncodeAddress(addressPrefix, keyType.AddressVersion(), address)
+	if err != nil {
+		return address
+	}
+	return encoded
+}
+
+// walletCreate genera una clave nueva del esquema indicado y la guarda cifrada.
+func walletCreate(keystoreDir, typeName string) error {
+	keyType, err := wallet.ParseKeyType(typeName)
+	if err != nil {
+		return err
+	}
+
+	ks, err := wallet.NewKeystore(keystoreDir)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+
+	key, err := wallet.NewKey(keyType)
+	if err != nil {
+		return fmt.Errorf("error generando la clave: %w", err)
+	}
+
+	address, err := ks.Store(key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dirección: %s\n", displayAddress(address, keyType))
+	fmt.Printf("Clave pública: %s\n", key.PublicKey())
+	return nil
+}
+
+// walletImport cifra y guarda una clave privada existente.
+func walletImport(keystoreDir, encoded, typeName string) error {
+	if encoded == "" {
+		return errors.New("indique la clave privada con -key")
+	}
+
+	keyType, err := wallet.ParseKeyType(typeName)
+	if err != nil {
+		return err
+	}
+	key, err := wallet.ParseKey(keyType, strings.TrimSpace(encoded))
+	if err != nil {
+		return err
+	}
+
+	ks, err := wallet.NewKeystore(keystoreDir)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+
+	address, err := ks.Store(key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Clave importada para la dirección %s\n", displayAddress(address, keyType))
+	return nil
+}
+
+// walletList muestra las direcciones guardadas en el almacén.
+func walletList(keystoreDir string) error {
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	keyFiles, err := ks.List()
+	if err != nil {
+		return err
+	}
+
+	if len(keyFiles) == 0 {
+		fmt.Println("El almacén no contiene claves.")
+		return nil
+	}
+	for _, keyFile := range keyFiles {
+		keyType, err := wallet.ParseKeyType(string(keyFile.KeyType))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (%s)\n", displayAddress(keyFile.Address, keyType), keyType)
+	}
+	return nil
+}
+
+// walletTransfer firma una transferencia con el siguiente nonce de la cuenta y la envía al nodo.
+func walletTransfer(keystoreDir, node, from, to string, amount int64) error {
+	if from == "" || to == "" || amount <= 0 {
+		return errors.New("indique -from, -to y un -amount mayor que cero")
+	}
+
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := accountNonce(node, from)
+	if err != nil {
+		return err
+	}
+
+	transfer, err := wallet.NewTransfer(key, to, amount, nonce+1)
+	if err != nil {
+		return err
+	}
+
+	var response map[string]string
+	if err := nodeRequest(http.MethodPost, node+"/transactions", transfer, &response); err != nil {
+		return fmt.Errorf("error enviando la transacción: %w", err)
+	}
+
+	fmt.Printf("%s (nonce %d)\n", response["message"], transfer.Nonce)
+	return nil
+}
+
+// walletBatch firma un pago múltiple con los destinatarios de un archivo CSV y lo envía al nodo.
+func walletBatch(keystoreDir, node, from, file string) error {
+	if from == "" || file == "" {
+		return errors.New("indique -from y -file")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("error abriendo %s: %w", file, err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return fmt.Errorf("error leyendo %s: %w", file, err)
+	}
+
+	var outputs []wallet.Output
+	for i, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("línea %d: se esperaba dirección,monto", i+1)
+		}
+		amount, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("línea %d: monto inválido: %w", i+1, err)
+		}
+		outputs = append(outputs, wallet.Output{To: strings.TrimSpace(record[0]), Amount: amount})
+	}
+	if len(outputs) == 0 {
+		return errors.New("el archivo no contiene destinatarios")
+	}
+
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := accountNonce(node, from)
+	if err != nil {
+		return err
+	}
+
+	batch, err := wallet.NewBatch(key, outputs, nonce+1)
+	if err != nil {
+		return err
+	}
+
+	var response map[string]string
+	if err := nodeRequest(http.MethodPost, node+"/transactions/batch", batch, &response); err != nil {
+		return fmt.Errorf("error enviando el pago múltiple: %w", err)
+	}
+
+	fmt.Printf("%s (%d destinatarios, nonce %d)\n", response["message"], len(outputs), batch.Nonce)
+	return nil
+}
+
+// walletCosign firma una propuesta multifirma. El mensaje se recalcula localmente a partir de
+// los datos de la propuesta, de modo que el nodo no puede hacer firmar otra transferencia.
+func walletCosign(keystoreDir, node, from string, id int) error {
+	if from == "" || id <= 0 {
+		return errors.New("indique -from y -proposal")
+	}
+
+	url := fmt.Sprintf("%s/multisig/proposals/%d", node, id)
+	var proposal struct {
+		Account     string `json:"account"`
+		To          string `json:"to"`
+		Amount      int64  `json:"amount"`
+		Nonce       uint64 `json:"nonce"`
+		Status      string `json:"status"`
+		SigningHash string `json:"signing_hash"`
+	}
+	if err := nodeRequest(http.MethodGet, url, nil, &proposal); err != nil {
+		return fmt.Errorf("error obteniendo la propuesta: %w", err)
+	}
+
+	hash := wallet.SigningHash(proposal.Account, proposal.To, proposal.Amount, "", nil, proposal.Nonce)
+	if hex.EncodeToString(hash) != proposal.SigningHash {
+		return errors.New("el mensaje de la propuesta no coincide con sus datos")
+	}
+
+	account, err := wallet.EncodeAddress(addressPrefix, wallet.AddressVersionMultisig, proposal.Account)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Propuesta %d: %d de %s a %s (nonce %d)\n", id, proposal.Amount, account, displayAddress(proposal.To, wallet.KeyTypeP256), proposal.Nonce)
+
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	signature, err := key.Sign(hash)
+	if err != nil {
+		return err
+	}
+
+	body := wallet.Signature{PublicKey: key.PublicKey(), Signature: signature}
+	if err := nodeRequest(http.MethodPost, url+"/signatures", body, &proposal); err != nil {
+		return fmt.Errorf("error enviando la firma: %w", err)
+	}
+
+	fmt.Printf("Firma enviada, estado de la propuesta: %s\n", proposal.Status)
+	return nil
+}
+
+// walletChannelPay firma una actualización de un canal de pago y la imprime en JSON para
+// entregarla al receptor. No contacta al nodo: el pago no pasa por la cadena.
+func walletChannelPay(keystoreDir, from, channel string, amount int64) error {
+	if from == "" || channel == "" || amount <= 0 {
+		return errors.New("indique -from, -channel y un -amount positivo")
+	}
+
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	update, err := wallet.SignChannelUpdate(key, channel, amount)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// walletDeploy firma el despliegue de un contrato WASM con el siguiente nonce de la cuenta, lo
+// envía al nodo y muestra la dirección que tendrá el contrato.
+func walletDeploy(keystoreDir, node, from, file string) error {
+	if from == "" || file == "" {
+		return errors.New("indique -from y -file")
+	}
+
+	code, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error leyendo %s: %w", file, err)
+	}
+
+	ks := &wallet.Keystore{Dir: keystoreDir}
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return err
+	}
+	key, err := ks.Load(from, passphrase)
+	if err != nil {
+		return err
+	}
+
+	nonce, err := accountNonce(node, from)
+	if err != nil {
+		return err
+	}
+
+	deployment, err := wallet.NewDeployment(key, code, nonce+1)
+	if err != nil {
+		return err
+	}
+
+	var response map[string]string
+	if err := nodeRequest(http.MethodPost, node+"/wasm-contracts", deployment, &response); err != nil {
+		return fmt.Errorf("error enviando el despliegue: %w", err)
+	}
+
+	fmt.Printf("%s (nonce %d)\nContrato: %s\n", response["message"], deployment.Nonce, response["contract"])
+	return nil
+}
+
+// walletMnemonic genera y muestra una frase semilla nueva.
+func walletMnemonic() error {
+	mnemonic, err := wallet.NewMnemonic()
+	if err != nil {
+		return err
+	}
+
+	hd, err := wallet.NewHDWallet(mnemonic, "")
+	if err != nil {
+		return err
+	}
+	key, err := hd.Account(0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Guarde esta frase en un lugar seguro; permite recuperar todas sus cuentas:")
+	fmt.Println(mnemonic)
+	fmt.Printf("Primera dirección (%s): %s\n", wallet.AccountPath(0), displayAddress(wallet.Address(&key.PublicKey), wallet.KeyTypeP256))
+	return nil
+}
+
+// walletRecover deriva las cuentas de una frase semilla, busca las usadas en el nodo
+// y las guarda cifradas en el almacén.
+func walletRecover(keystoreDir, node string, gap int) error {
+	mnemonic, err := readPassphrase("Frase semilla: ")
+	if err != nil {
+		return err
+	}
+	seedPassphrase, err := readPassphrase("Contraseña de la frase (vacía si no tiene): ")
+	if err != nil {
+		return err
+	}
+
+	hd, err := wallet.NewHDWallet(mnemonic, seedPassphrase)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := hd.Scan(func(address string) (bool, error) {
+		var account struct {
+			Exists  bool   `json:"exists"`
+			Balance int64  `json:"balance"`
+			Nonce   uint64 `json:"nonce"`
+		}
+		if err := nodeRequest(http.MethodGet, node+"/accounts/"+address, nil, &account); err != nil {
+			return false, err
+		}
+		return account.Exists || account.Balance > 0 || account.Nonce > 0, nil
+	}, gap)
+	if err != nil {
+		return err
+	}
+
+	if len(accounts) == 0 {
+		fmt.Println("No se encontraron cuentas usadas para esta frase.")
+		return nil
+	}
+
+	ks, err := wallet.NewKeystore(keystoreDir)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		address := displayAddress(account.Address, wallet.KeyTypeP256)
+		if _, err := ks.Store(wallet.P256Key(account.Key), passphrase); err != nil {
+			fmt.Printf("%s %s: %s\n", account.Path, address, err)
+			continue
+		}
+		fmt.Printf("%s %s recuperada\n", account.Path, address)
+	}
+	return nil
+}
+
+// accountNonce obtiene del nodo el último nonce usado por una cuenta, incluidas sus
+// transacciones pendientes.
+func accountNonce(node, address string) (uint64, error) {
+	var account struct {
+		PendingNonce uint64 `json:"pending_nonce"`
+	}
+	if err := nodeRequest(http.MethodGet, node+"/accounts/"+address, nil, &account); err != nil {
+		return 0, fmt.Errorf("error obteniendo el nonce: %w", err)
+	}
+	return account.PendingNonce, nil
+}
+
+// nodeRequest envía una solicitud JSON al nodo y decodifica la respuesta.
+func nodeRequest(method, url string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("el nodo respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readNewPassphrase pide una contraseña nueva dos veces para confirmarla.
+func readNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("Contraseña: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("la contraseña no puede estar vacía")
+	}
+
+	confirmation, err := readPassphrase("Repita la contraseña: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != confirmation {
+		return "", errors.New("las contraseñas no coinciden")
+	}
+	return passphrase, nil
+}
+
+// readPassphrase lee una contraseña sin eco desde la terminal, o una línea de la entrada
+// estándar si no hay terminal (por ejemplo en scripts).
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		return string(passphrase), err
+	}
+
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stdinReader se comparte entre lecturas para no perder datos en el búfer.
+var stdinReader = bufio.NewReader(os.Stdin)
@@ -0,0 +1,249 @@
+//! SDK para escribir contratos WASM de Qubit.
+//!
+//! Implementa la convención de llamada del nodo:
+//!
+//! * El contrato exporta `alloc(size: i32) -> i32`, con la que el nodo reserva un búfer para
+//!   copiar la entrada en la memoria lineal del contrato.
+//! * Cada punto de entrada tiene la firma `(ptr: i32, len: i32) -> i64`: recibe la entrada por
+//!   puntero y longitud y devuelve la salida empaquetada, con el puntero en los 32 bits altos y
+//!   la longitud en los bajos.
+//!
+//! La macro [`entry!`] genera los puntos de entrada a partir de funciones `fn(&[u8]) -> Vec<u8>`,
+//! y los módulos de este crate envuelven las funciones del host importadas de `env`.
+
+mod sys {
+    #[link(wasm_import_module = "env")]
+    extern "C" {
+        pub fn storage_read(key_ptr: i32, key_len: i32, out_ptr: i32, out_cap: i32) -> i32;
+        pub fn storage_write(key_ptr: i32, key_len: i32, value_ptr: i32, value_len: i32);
+        pub fn storage_remove(key_ptr: i32, key_len: i32);
+        pub fn balance(address_ptr: i32, address_len: i32) -> i64;
+        pub fn transfer(to_ptr: i32, to_len: i32, amount: i64) -> i32;
+        pub fn caller(out_ptr: i32, out_cap: i32) -> i32;
+        pub fn contract_address(out_ptr: i32, out_cap: i32) -> i32;
+        pub fn block_height() -> i64;
+        pub fn block_timestamp() -> i64;
+        pub fn log(message_ptr: i32, message_len: i32);
+        pub fn emit_event(topics_ptr: i32, topics_count: i32, data_ptr: i32, data_len: i32);
+        pub fn call_contract(
+            contract_ptr: i32,
+            contract_len: i32,
+            method_ptr: i32,
+            method_len: i32,
+            args_ptr: i32,
+            args_len: i32,
+            value: i64,
+            gas: i64,
+        ) -> i32;
+        pub fn call_output(out_ptr: i32, out_cap: i32) -> i32;
+    }
+}
+
+/// Reserva un búfer de `size` bytes para que el nodo copie la entrada. La memoria no se
+/// libera: cada llamada usa una instancia nueva del contrato.
+#[no_mangle]
+pub extern "C" fn alloc(size: i32) -> i32 {
+    let mut buffer = Vec::<u8>::with_capacity(size.max(0) as usize);
+    let ptr = buffer.as_mut_ptr();
+    core::mem::forget(buffer);
+    ptr as i32
+}
+
+/// Ejecuta un punto de entrada: lee la entrada de la memoria, llama al manejador y devuelve
+/// la salida empaquetada según la convención de llamada. Lo usa la macro [`entry!`].
+pub fn dispatch(ptr: i32, len: i32, handler: fn(&[u8]) -> Vec<u8>) -> i64 {
+    let input: &[u8] = if len > 0 {
+        unsafe { core::slice::from_raw_parts(ptr as *const u8, len as usize) }
+    } else {
+        &[]
+    };
+
+    let output = core::mem::ManuallyDrop::new(handler(input));
+    ((output.as_ptr() as u32 as i64) << 32) | output.len() as u32 as i64
+}
+
+/// Exporta funciones `fn(&[u8]) -> Vec<u8>` como puntos de entrada del contrato.
+///
+/// ```ignore
+/// qubit_sdk::entry!(execute => handle_execute, get => handle_get);
+/// ```
+#[macro_export]
+macro_rules! entry {
+    ($($name:ident => $handler:path),+ $(,)?) => {
+        $(
+            #[no_mangle]
+            pub extern "C" fn $name(ptr: i32, len: i32) -> i64 {
+                $crate::dispatch(ptr, len, $handler)
+            }
+        )+
+    };
+}
+
+/// Protege al contrato de la reentrada: el nodo rechaza cualquier llamada a él mientras ya se
+/// está ejecutando, directa o a través de otros contratos. Se invoca una vez en el crate.
+///
+/// ```ignore
+/// qubit_sdk::nonreentrant!();
+/// ```
+#[macro_export]
+macro_rules! nonreentrant {
+    () => {
+        #[no_mangle]
+        pub extern "C" fn qubit_nonreentrant() {}
+    };
+}
+
+/// Lee un valor de tamaño desconocido de una función del host que escribe en
+/// `(out_ptr, out_cap)` solo si el valor entra y siempre devuelve su longitud.
+fn read_with(read: impl Fn(i32, i32) -> i32) -> Option<Vec<u8>> {
+    let len = read(0, 0);
+    if len < 0 {
+        return None;
+    }
+    let mut buffer = vec![0u8; len as usize];
+    if len > 0 {
+        read(buffer.as_mut_ptr() as i32, len);
+    }
+    Some(buffer)
+}
+
+/// Almacenamiento persistente clave-valor del contrato.
+pub mod storage {
+    use super::{read_with, sys};
+
+    /// Devuelve el valor de una clave, o `None` si no existe.
+    pub fn get(key: &[u8]) -> Option<Vec<u8>> {
+        read_with(|ptr, cap| unsafe {
+            sys::storage_read(key.as_ptr() as i32, key.len() as i32, ptr, cap)
+        })
+    }
+
+    /// Guarda el valor de una clave.
+    pub fn set(key: &[u8], value: &[u8]) {
+        unsafe {
+            sys::storage_write(
+                key.as_ptr() as i32,
+                key.len() as i32,
+                value.as_ptr() as i32,
+                value.len() as i32,
+            )
+        }
+    }
+
+    /// Elimina una clave.
+    pub fn remove(key: &[u8]) {
+        unsafe { sys::storage_remove(key.as_ptr() as i32, key.len() as i32) }
+    }
+}
+
+/// Saldo de una cuenta (dirección `qbt1...` o hexadecimal).
+pub fn balance(address: &str) -> i64 {
+    unsafe { sys::balance(address.as_ptr() as i32, address.len() as i32) }
+}
+
+/// Transfiere `amount` desde la cuenta del contrato. Devuelve `false` si el saldo no alcanza.
+pub fn transfer(to: &str, amount: i64) -> bool {
+    unsafe { sys::transfer(to.as_ptr() as i32, to.len() as i32, amount) == 0 }
+}
+
+/// Dirección hexadecimal de la cuenta que llama al contrato.
+pub fn caller() -> String {
+    let bytes = read_with(|ptr, cap| unsafe { sys::caller(ptr, cap) }).unwrap_or_default();
+    String::from_utf8(bytes).unwrap_or_default()
+}
+
+/// Dirección del propio contrato, que también es su cuenta.
+pub fn contract_address() -> String {
+    let bytes =
+        read_with(|ptr, cap| unsafe { sys::contract_address(ptr, cap) }).unwrap_or_default();
+    String::from_utf8(bytes).unwrap_or_default()
+}
+
+/// Altura del bloque en el que se ejecuta la llamada.
+pub fn block_height() -> i64 {
+    unsafe { sys::block_height() }
+}
+
+/// Marca de tiempo del bloque en segundos Unix.
+pub fn block_timestamp() -> i64 {
+    unsafe { sys::block_timestamp() }
+}
+
+/// Llama a un método de otro contrato (dirección `qbt1r...` o ID), transfiriéndole `value`
+/// desde la cuenta de este contrato. `gas` limita el gas que puede consumir; con 0 recibe todo
+/// el restante salvo 1/64. Si la llamada falla, sus cambios se descartan y se devuelve el motivo.
+pub fn call(
+    contract: &str,
+    method: &str,
+    args: &[u8],
+    value: i64,
+    gas: i64,
+) -> Result<Vec<u8>, String> {
+    let status = unsafe {
+        sys::call_contract(
+            contract.as_ptr() as i32,
+            contract.len() as i32,
+            method.as_ptr() as i32,
+            method.len() as i32,
+            args.as_ptr() as i32,
+            args.len() as i32,
+            value,
+            gas,
+        )
+    };
+    let output = read_with(|ptr, cap| unsafe { sys::call_output(ptr, cap) }).unwrap_or_default();
+    if status == 0 {
+        Ok(output)
+    } else {
+        Err(String::from_utf8_lossy(&output).into_owned())
+    }
+}
+
+/// Agrega un mensaje a los registros del contrato.
+pub fn log(message: &str) {
+    unsafe { sys::log(message.as_ptr() as i32, message.len() as i32) }
+}
+
+/// Eventos con temas indexados, que el nodo guarda en el recibo de la llamada.
+pub mod events {
+    use super::sys;
+
+    /// Tema de un evento: 32 bytes por los que se filtran los eventos.
+    pub type Topic = [u8; 32];
+
+    /// Emite un evento con hasta cuatro temas. Se descarta si la llamada falla.
+    pub fn emit(topics: &[Topic], data: &[u8]) {
+        unsafe {
+            sys::emit_event(
+                topics.as_ptr() as i32,
+                topics.len() as i32,
+                data.as_ptr() as i32,
+                data.len() as i32,
+            )
+        }
+    }
+
+    /// Tema a partir de un nombre corto, completado con ceros a la derecha. Falla si el nombre
+    /// supera 32 bytes.
+    pub fn topic(name: &[u8]) -> Topic {
+        assert!(name.len() <= 32, "un tema no puede superar 32 bytes");
+        let mut topic = [0u8; 32];
+        topic[..name.len()].copy_from_slice(name);
+        topic
+    }
+
+    /// Tema a partir de una dirección hexadecimal, como la que devuelve [`crate::caller`].
+    /// Devuelve `None` si no son 32 bytes en hexadecimal.
+    pub fn address_topic(address: &str) -> Option<Topic> {
+        let digits = address.as_bytes();
+        if digits.len() != 64 {
+            return None;
+        }
+        let nibble = |c: u8| (c as char).to_digit(16).map(|d| d as u8);
+        let mut topic = [0u8; 32];
+        for (i, pair) in digits.chunks(2).enumerate() {
+            topic[i] = nibble(pair[0])? << 4 | nibble(pair[1])?;
+        }
+        Some(topic)
+    }
+}
@@ -1,6 +1,4 @@
 use serde::{Deserialize, Serialize};
-use wasm_bindgen::prelude::*;
-use serde_wasm_bindgen::{from_value, to_value};
 
 #[derive(Serialize, Deserialize)]
 pub struct InputData {
@@ -14,18 +12,28 @@ pub struct OutputData {
     pub message: String,
 }
 
-#[wasm_bindgen]
-pub fn process_data(input: JsValue) -> Result<JsValue, JsValue> {
-    // Usa `serde-wasm-bindgen` para deserializar
-    let input_data: InputData = from_value(input).map_err(|err| JsValue::from_str(&err.to_string()))?;
+qubit_sdk::entry!(execute => process_data);
 
-    // Procesa los datos
-    let output_data = OutputData {
-        success: true,
-        message: format!("Key: {}, Value: {}", input_data.key, input_data.value),
+/// Guarda el valor bajo la clave en el almacenamiento del contrato y responde con un resumen.
+pub fn process_data(input: &[u8]) -> Vec<u8> {
+    let output_data = match serde_json::from_slice::<InputData>(input) {
+        Ok(input_data) => {
+            qubit_sdk::storage::set(input_data.key.as_bytes(), input_data.value.as_bytes());
+            qubit_sdk::log(&format!("clave {} guardada", input_data.key));
+            qubit_sdk::events::emit(
+                &[qubit_sdk::events::topic(b"stored")],
+                input_data.key.as_bytes(),
+            );
+            OutputData {
+                success: true,
+                message: format!("Key: {}, Value: {}", input_data.key, input_data.value),
+            }
+        }
+        Err(err) => OutputData {
+            success: false,
+            message: err.to_string(),
+        },
     };
 
-    // Usa `serde-wasm-bindgen` para serializar
-    to_value(&output_data).map_err(|err| JsValue::from_str(&err.to_string()))
+    serde_json::to_vec(&output_data).unwrap_or_default()
 }
-